@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"time"
 
+	"github.com/andrebq/gofunc/funcs"
 	"github.com/andrebq/gofunc/installers"
 	"github.com/andrebq/gofunc/pkg/uploader"
 
@@ -29,6 +33,8 @@ func newApp() *cli.App {
 	app.Commands = []*cli.Command{
 		serveCmd(),
 		uploadCmd(),
+		schedulesCmd(),
+		modulesCmd(),
 		installCmd(),
 	}
 	return app
@@ -49,6 +55,8 @@ func installK8SCmd() *cli.Command {
 	var namespace string
 	var name string = "gofunc"
 	var image string = "andrebq/gofunc:latest"
+	var port uint = 9000
+	var adminPort uint
 	return &cli.Command{
 		Name:  "k8s",
 		Usage: "Renders a GoFunc server manifest on Kubernetes, you must provide a Yaml file to be used as template (or stdin).\nThe template is assumed to be trustworthy",
@@ -77,9 +85,21 @@ func installK8SCmd() *cli.Command {
 				Value:       image,
 				Destination: &image,
 			},
+			&cli.UintFlag{
+				Name:        "port",
+				Usage:       "Port of the public (function invocation) listener",
+				Value:       port,
+				Destination: &port,
+			},
+			&cli.UintFlag{
+				Name:        "admin-port",
+				Usage:       "Port of the admin listener, the public listener is shared when unset",
+				Value:       adminPort,
+				Destination: &adminPort,
+			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return installers.K8S(ctx.Context, ctx.App.Writer, yamlFile, name, namespace, image)
+			return installers.K8S(ctx.Context, ctx.App.Writer, yamlFile, name, namespace, image, port, adminPort)
 		},
 	}
 }
@@ -88,6 +108,7 @@ func uploadCmd() *cli.Command {
 	var dir string = "."
 	var name string = ""
 	var addr string = "http://127.0.0.1:9000"
+	var opts uploader.Options
 
 	return &cli.Command{
 		Name:  "upload",
@@ -112,9 +133,79 @@ func uploadCmd() *cli.Command {
 				Destination: &addr,
 				Value:       addr,
 			},
+			&cli.StringFlag{
+				Name:        "ca-cert",
+				Usage:       "PEM bundle used to verify the server certificate",
+				Destination: &opts.CAFile,
+			},
+			&cli.StringFlag{
+				Name:        "client-cert",
+				Usage:       "Client certificate used to authenticate against the admin routes",
+				Destination: &opts.CertFile,
+			},
+			&cli.StringFlag{
+				Name:        "client-key",
+				Usage:       "Private key of the client certificate",
+				Destination: &opts.KeyFile,
+			},
+			&cli.StringFlag{
+				Name:        "kind",
+				Usage:       "Function kind (service or worker), overrides the gofunc.json manifest",
+				Destination: &opts.Kind,
+			},
+			&cli.BoolFlag{
+				Name:        "vendor",
+				Usage:       "Vendor the dependencies in the upload, so the server does not download modules",
+				Destination: &opts.Vendor,
+			},
+			&cli.BoolFlag{
+				Name:        "prebuilt",
+				Usage:       "Build locally for the platform of the server and upload the binary instead of the sources",
+				Destination: &opts.Prebuilt,
+			},
+			&cli.StringFlag{
+				Name:        "package",
+				Usage:       "Main package to build, relative to --dir (eg.: ./cmd/server)",
+				Destination: &opts.Build.Package,
+			},
+			&cli.StringFlag{
+				Name:        "tags",
+				Usage:       "Comma separated list of build tags",
+				Destination: &opts.Build.Tags,
+			},
+			&cli.StringFlag{
+				Name:        "ldflags",
+				Usage:       "Flags given to go build -ldflags (eg.: -X main.version=v1.2.3)",
+				Destination: &opts.Build.LDFlags,
+			},
+			&cli.StringFlag{
+				Name:        "gcflags",
+				Usage:       "Flags given to go build -gcflags",
+				Destination: &opts.Build.GCFlags,
+			},
+			&cli.BoolFlag{
+				Name:  "trimpath",
+				Usage: "Build with -trimpath",
+			},
+			&cli.BoolFlag{
+				Name:  "cgo",
+				Usage: "Build with CGO_ENABLED=1 (or 0 with --cgo=false)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "build-env",
+				Usage: "KEY=VALUE variables for go build, can be repeated",
+			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return uploader.Upload(ctx.Context, addr, name, dir)
+			// flags that are not set keep the settings of the manifest
+			if ctx.IsSet("trimpath") {
+				opts.Build.TrimPath = strconv.FormatBool(ctx.Bool("trimpath"))
+			}
+			if ctx.IsSet("cgo") {
+				opts.Build.CGO = strconv.FormatBool(ctx.Bool("cgo"))
+			}
+			opts.Build.Env = ctx.StringSlice("build-env")
+			return uploader.UploadWithOptions(ctx.Context, addr, name, dir, opts)
 		},
 	}
 }
@@ -123,6 +214,9 @@ func serveCmd() *cli.Command {
 	var bindPort uint = 9000
 	var bindAddr string = "0.0.0.0"
 	var baseDir string
+	var adminSocketMode string = "0600"
+	var cfg server.Config
+	var buildCPUTime time.Duration
 	return &cli.Command{
 		Name:  "serve",
 		Usage: "Start the GoFunc server",
@@ -149,9 +243,142 @@ func serveCmd() *cli.Command {
 				EnvVars:     []string{"BASE_DIR"},
 				Required:    true,
 			},
+			&cli.StringFlag{
+				Name:        "admin-address",
+				Usage:       "Serve admin routes on a dedicated listener (host:port or unix:/path/to/socket)",
+				Destination: &cfg.AdminAddr,
+				EnvVars:     []string{"ADMIN_ADDR"},
+			},
+			&cli.StringFlag{
+				Name:        "admin-socket-mode",
+				Usage:       "File permissions (octal) of the admin unix socket",
+				Destination: &adminSocketMode,
+				Value:       adminSocketMode,
+				EnvVars:     []string{"ADMIN_SOCKET_MODE"},
+			},
+			&cli.StringFlag{
+				Name:        "tls-cert",
+				Usage:       "Certificate file, enables TLS (reloaded when changed)",
+				Destination: &cfg.TLS.CertFile,
+				EnvVars:     []string{"TLS_CERT"},
+			},
+			&cli.StringFlag{
+				Name:        "tls-key",
+				Usage:       "Private key file for the certificate (reloaded when changed)",
+				Destination: &cfg.TLS.KeyFile,
+				EnvVars:     []string{"TLS_KEY"},
+			},
+			&cli.StringFlag{
+				Name:        "tls-client-ca",
+				Usage:       "CA bundle used to authenticate clients of the admin routes",
+				Destination: &cfg.TLS.ClientCAFile,
+				EnvVars:     []string{"TLS_CLIENT_CA"},
+			},
+			&cli.BoolFlag{
+				Name:        "http2",
+				Usage:       "Enable HTTP/2 on TLS listeners",
+				Destination: &cfg.HTTP2,
+				Value:       true,
+				EnvVars:     []string{"HTTP2"},
+			},
+			&cli.BoolFlag{
+				Name:        "h2c",
+				Usage:       "Enable HTTP/2 without TLS (used by gRPC clients) on plain listeners",
+				Destination: &cfg.H2C,
+				Value:       true,
+				EnvVars:     []string{"H2C"},
+			},
+			&cli.StringFlag{
+				Name:        "module-mirror",
+				Usage:       "How builds use the local module mirror: off, prefer (mirror first) or only (offline)",
+				Destination: &cfg.Modules.Mirror,
+				Value:       server.MirrorOff,
+				EnvVars:     []string{"MODULE_MIRROR"},
+			},
+			&cli.StringFlag{
+				Name:        "gosumdb",
+				Usage:       "GOSUMDB used by builds (eg.: off in air-gapped hosts)",
+				Destination: &cfg.Modules.SumDB,
+				EnvVars:     []string{"BUILD_GOSUMDB"},
+			},
+			&cli.StringFlag{
+				Name:        "gonosumdb",
+				Usage:       "GONOSUMDB patterns used by builds",
+				Destination: &cfg.Modules.NoSumDB,
+				EnvVars:     []string{"BUILD_GONOSUMDB"},
+			},
+			&cli.DurationFlag{
+				Name:        "build-timeout",
+				Usage:       "Maximum duration of a build",
+				Destination: &cfg.BuildTimeout,
+				Value:       funcs.DefaultBuildTimeout,
+				EnvVars:     []string{"BUILD_TIMEOUT"},
+			},
+			&cli.Int64Flag{
+				Name:        "build-max-memory",
+				Usage:       "Address space, in bytes, of go build and each compiler it starts (0 disables the limit)",
+				Destination: &cfg.BuildLimits.MaxMemory,
+				EnvVars:     []string{"BUILD_MAX_MEMORY"},
+			},
+			&cli.DurationFlag{
+				Name:        "build-max-cpu-time",
+				Usage:       "CPU time of go build and each compiler it starts (0 disables the limit)",
+				Destination: &buildCPUTime,
+				EnvVars:     []string{"BUILD_MAX_CPU_TIME"},
+			},
+			&cli.IntFlag{
+				Name:        "build-parallel",
+				Usage:       "Number of builds, and of compilers per build, running at the same time (0 uses the number of CPUs)",
+				Destination: &cfg.BuildParallel,
+				EnvVars:     []string{"BUILD_PARALLEL"},
+			},
+			&cli.Int64Flag{
+				Name:        "max-upload-size",
+				Usage:       "Size, in bytes, of uploaded archives",
+				Destination: &cfg.Archive.MaxUpload,
+				Value:       funcs.DefaultMaxUpload,
+				EnvVars:     []string{"MAX_UPLOAD_SIZE"},
+			},
+			&cli.Int64Flag{
+				Name:        "max-source-size",
+				Usage:       "Uncompressed size, in bytes, of all files in an upload",
+				Destination: &cfg.Archive.MaxSize,
+				Value:       funcs.DefaultMaxSize,
+				EnvVars:     []string{"MAX_SOURCE_SIZE"},
+			},
+			&cli.IntFlag{
+				Name:        "max-source-files",
+				Usage:       "Number of files and directories in an upload",
+				Destination: &cfg.Archive.MaxFiles,
+				Value:       funcs.DefaultMaxFiles,
+				EnvVars:     []string{"MAX_SOURCE_FILES"},
+			},
+			&cli.Int64Flag{
+				Name:        "max-file-size",
+				Usage:       "Uncompressed size, in bytes, of each file in an upload",
+				Destination: &cfg.Archive.MaxFileSize,
+				Value:       funcs.DefaultMaxFileSize,
+				EnvVars:     []string{"MAX_FILE_SIZE"},
+			},
+			&cli.Float64Flag{
+				Name:        "max-compression-ratio",
+				Usage:       "Compression ratio of each file larger than 1MB in an upload",
+				Destination: &cfg.Archive.MaxRatio,
+				Value:       funcs.DefaultMaxRatio,
+				EnvVars:     []string{"MAX_COMPRESSION_RATIO"},
+			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return server.Run(ctx.Context, bindAddr, bindPort, baseDir)
+			cfg.Addr = bindAddr
+			cfg.BuildLimits.MaxCPUTime = funcs.Duration(buildCPUTime)
+			cfg.Port = bindPort
+			cfg.BaseDir = baseDir
+			mode, err := strconv.ParseUint(adminSocketMode, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid admin-socket-mode %q: %w", adminSocketMode, err)
+			}
+			cfg.AdminSocketMode = os.FileMode(mode)
+			return server.Run(ctx.Context, cfg)
 		},
 	}
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/andrebq/gofunc/pkg/uploader"
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	// mirrorModule is an entry of GET /_admin/modules
+	mirrorModule struct {
+		Path     string   `json:"path"`
+		Versions []string `json:"versions"`
+	}
+)
+
+func modulesCmd() *cli.Command {
+	var addr string = "http://127.0.0.1:9000"
+	var opts uploader.Options
+	var dir string = "."
+	var file, module, version string
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "addr",
+			Usage:       "Server address (including scheme and port)",
+			Destination: &addr,
+			Value:       addr,
+		},
+		&cli.StringFlag{
+			Name:        "ca-cert",
+			Usage:       "PEM bundle used to verify the server certificate",
+			Destination: &opts.CAFile,
+		},
+		&cli.StringFlag{
+			Name:        "client-cert",
+			Usage:       "Client certificate used to authenticate against the admin routes",
+			Destination: &opts.CertFile,
+		},
+		&cli.StringFlag{
+			Name:        "client-key",
+			Usage:       "Private key of the client certificate",
+			Destination: &opts.KeyFile,
+		},
+	}
+	var stored struct{ Files int }
+	return &cli.Command{
+		Name:  "modules",
+		Usage: "Manage the module mirror used by offline builds",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the modules in the mirror",
+				Flags: flags,
+				Action: func(ctx *cli.Context) error {
+					var mods []mirrorModule
+					if err := adminCall(ctx, opts, http.MethodGet, addr, &mods, "_admin", "modules"); err != nil {
+						return err
+					}
+					tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "MODULE\tVERSIONS")
+					for _, m := range mods {
+						fmt.Fprintf(tw, "%v\t%v\n", m.Path, strings.Join(m.Versions, " "))
+					}
+					return tw.Flush()
+				},
+			},
+			{
+				Name:  "push",
+				Usage: "Download the modules needed by a function and add them to the mirror",
+				Flags: append(flags, &cli.StringFlag{
+					Name:        "dir",
+					Usage:       "Directory with the go.mod of the function",
+					Destination: &dir,
+					Value:       dir,
+				}),
+				Action: func(ctx *cli.Context) error {
+					tmp, err := os.CreateTemp("", "gofunc-modules-*.zip")
+					if err != nil {
+						return err
+					}
+					tmp.Close()
+					defer os.Remove(tmp.Name())
+					if err := uploader.PackModules(ctx.Context, dir, tmp.Name()); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					f, err := os.Open(tmp.Name())
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					if err := adminSend(ctx, opts, http.MethodPut, addr, nil, f, &stored, "_admin", "modules", "cache"); err != nil {
+						return err
+					}
+					fmt.Printf("stored %v files\n", stored.Files)
+					return nil
+				},
+			},
+			{
+				Name:  "add",
+				Usage: "Add a module zip (as served by a module proxy) to the mirror",
+				Flags: append(flags,
+					&cli.StringFlag{Name: "file", Usage: "Module zip", Destination: &file, Required: true},
+					&cli.StringFlag{Name: "module", Usage: "Module path", Destination: &module, Required: true},
+					&cli.StringFlag{Name: "version", Usage: "Module version", Destination: &version, Required: true},
+				),
+				Action: func(ctx *cli.Context) error {
+					f, err := os.Open(file)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					query := url.Values{"module": {module}, "version": {version}}
+					return adminSend(ctx, opts, http.MethodPut, addr, query, f, &stored, "_admin", "modules", "zip")
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andrebq/gofunc/pkg/uploader"
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	// scheduleStatus is the subset of the server response printed by the CLI
+	scheduleStatus struct {
+		Func     string     `json:"func"`
+		ID       string     `json:"id"`
+		Cron     string     `json:"cron"`
+		TimeZone string     `json:"timeZone"`
+		Paused   bool       `json:"paused"`
+		Next     *time.Time `json:"next"`
+		Running  int        `json:"running"`
+		Runs     []struct {
+			Trigger  string    `json:"trigger"`
+			Started  time.Time `json:"started"`
+			Duration string    `json:"duration"`
+			Status   int       `json:"status"`
+			Outcome  string    `json:"outcome"`
+			Error    string    `json:"error"`
+		} `json:"runs"`
+	}
+)
+
+func schedulesCmd() *cli.Command {
+	var addr string = "http://127.0.0.1:9000"
+	var name string
+	var id string
+	var opts uploader.Options
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "addr",
+			Usage:       "Server address (including scheme and port)",
+			Destination: &addr,
+			Value:       addr,
+		},
+		&cli.StringFlag{
+			Name:        "ca-cert",
+			Usage:       "PEM bundle used to verify the server certificate",
+			Destination: &opts.CAFile,
+		},
+		&cli.StringFlag{
+			Name:        "client-cert",
+			Usage:       "Client certificate used to authenticate against the admin routes",
+			Destination: &opts.CertFile,
+		},
+		&cli.StringFlag{
+			Name:        "client-key",
+			Usage:       "Private key of the client certificate",
+			Destination: &opts.KeyFile,
+		},
+	}
+	nameFlag := &cli.StringFlag{
+		Name:        "name",
+		Usage:       "Function name",
+		Destination: &name,
+		Required:    true,
+	}
+	idFlag := &cli.StringFlag{
+		Name:        "id",
+		Usage:       "Schedule id",
+		Destination: &id,
+		Required:    true,
+	}
+	action := func(verb string) cli.ActionFunc {
+		return func(ctx *cli.Context) error {
+			var st scheduleStatus
+			if err := adminCall(ctx, opts, http.MethodPost, addr, &st, "_admin", name, "schedules", id, verb); err != nil {
+				return err
+			}
+			return printSchedules([]scheduleStatus{st})
+		}
+	}
+	return &cli.Command{
+		Name:  "schedules",
+		Usage: "Manage the cron schedules of functions",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List schedules and their recent runs",
+				Flags: append(flags, &cli.StringFlag{
+					Name:        "name",
+					Usage:       "Only list the schedules of this function",
+					Destination: &name,
+				}),
+				Action: func(ctx *cli.Context) error {
+					elems := []string{"_admin", "schedules"}
+					if name != "" {
+						elems = []string{"_admin", name, "schedules"}
+					}
+					var list []scheduleStatus
+					if err := adminCall(ctx, opts, http.MethodGet, addr, &list, elems...); err != nil {
+						return err
+					}
+					return printSchedules(list)
+				},
+			},
+			{
+				Name:   "pause",
+				Usage:  "Stop a schedule from firing",
+				Flags:  append(flags, nameFlag, idFlag),
+				Action: action("pause"),
+			},
+			{
+				Name:   "resume",
+				Usage:  "Resume a paused schedule",
+				Flags:  append(flags, nameFlag, idFlag),
+				Action: action("resume"),
+			},
+			{
+				Name:   "trigger",
+				Usage:  "Run a schedule now, even if it is paused",
+				Flags:  append(flags, nameFlag, idFlag),
+				Action: action("trigger"),
+			},
+		},
+	}
+}
+
+// adminCall sends a request without body to the admin api and decodes the json response into out
+func adminCall(ctx *cli.Context, opts uploader.Options, method, addr string, out any, elems ...string) error {
+	return adminSend(ctx, opts, method, addr, nil, nil, out, elems...)
+}
+
+// adminSend sends a request with the given query and body to the admin api and
+// decodes the json response into out
+func adminSend(ctx *cli.Context, opts uploader.Options, method, addr string, query url.Values, body io.Reader, out any, elems ...string) error {
+	client, err := opts.HTTPClient()
+	if err != nil {
+		return cli.Exit("invalid tls settings: "+err.Error(), 1)
+	}
+	serverURL, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	serverURL.Path = path.Join(append([]string{serverURL.Path}, elems...)...)
+	serverURL.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx.Context, method, serverURL.String(), body)
+	if err != nil {
+		return cli.Exit("failed to create request: "+err.Error(), 1)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/zip")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return cli.Exit("request failed: "+err.Error(), 1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return cli.Exit(fmt.Sprintf("request failed: status=%d body=%s", resp.StatusCode, string(body)), 1)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func printSchedules(list []scheduleStatus) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNC\tID\tCRON\tTZ\tSTATE\tNEXT\tLAST RUN\tOUTCOME")
+	for _, s := range list {
+		state := "active"
+		if s.Paused {
+			state = "paused"
+		} else if s.Running > 0 {
+			state = "running"
+		}
+		next, last, outcome := "-", "-", "-"
+		if s.Next != nil {
+			next = s.Next.Format(time.RFC3339)
+		}
+		if len(s.Runs) > 0 {
+			r := s.Runs[0]
+			if !r.Started.IsZero() {
+				last = r.Started.Format(time.RFC3339) + " (" + r.Duration + ")"
+			}
+			outcome = r.Outcome
+			if r.Status != 0 {
+				outcome = fmt.Sprintf("%v %d", outcome, r.Status)
+			}
+		}
+		tz := s.TimeZone
+		if tz == "" {
+			tz = "UTC"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", s.Func, s.ID, s.Cron, tz, state, next, last, outcome)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,374 @@
+package funcs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type (
+	// ArchiveLimits protect the server from malicious uploads (eg.: zip
+	// bombs). Zero values use the defaults.
+	ArchiveLimits struct {
+		// MaxUpload is the size of the uploaded archive
+		MaxUpload int64
+		// MaxSize is the uncompressed size of all files
+		MaxSize int64
+		// MaxFiles is the number of entries, including directories
+		MaxFiles int
+		// MaxFileSize is the uncompressed size of each file
+		MaxFileSize int64
+		// MaxRatio is the compression ratio of each file, only files
+		// larger than 1MB are checked
+		MaxRatio float64
+	}
+
+	// ArchiveFormat is the format of an uploaded archive, Compile selects
+	// it by the extension of the file
+	ArchiveFormat string
+
+	// ArchiveError explains why an archive was rejected
+	ArchiveError struct {
+		Reason string `json:"error"`
+		// Limit names the limit that was exceeded, it is empty for invalid entries
+		Limit string  `json:"limit,omitempty"`
+		Max   float64 `json:"max,omitempty"`
+		File  string  `json:"file,omitempty"`
+	}
+)
+
+const (
+	FormatZip    = ArchiveFormat(".zip")
+	FormatTarGz  = ArchiveFormat(".tar.gz")
+	FormatTarZst = ArchiveFormat(".tar.zst")
+	// FormatGoFile is a single main.go, a go.mod is generated for it
+	FormatGoFile = ArchiveFormat(".go")
+)
+
+const (
+	DefaultMaxUpload   = 100 << 20
+	DefaultMaxSize     = 512 << 20
+	DefaultMaxFiles    = 20000
+	DefaultMaxFileSize = 100 << 20
+	DefaultMaxRatio    = 100
+
+	// ratioMinSize skips the ratio check on small files, which compress
+	// well without being a threat
+	ratioMinSize = 1 << 20
+	// maxZstdMemory caps the memory used to decode zstd frames
+	maxZstdMemory = 128 << 20
+)
+
+func (e *ArchiveError) Error() string {
+	msg := "invalid archive: " + e.Reason
+	if e.File != "" {
+		msg += fmt.Sprintf(" (file %q)", e.File)
+	}
+	return msg
+}
+
+// TooLarge returns true when the archive exceeded a size limit, as opposed
+// to having invalid entries
+func (e *ArchiveError) TooLarge() bool {
+	return e.Limit != ""
+}
+
+// FormatOf returns the format of the archive named name, files without a
+// known extension are handled as zip archives
+func FormatOf(name string) ArchiveFormat {
+	for _, f := range []ArchiveFormat{FormatTarGz, FormatTarZst, FormatGoFile} {
+		if strings.HasSuffix(name, string(f)) {
+			return f
+		}
+	}
+	if strings.HasSuffix(name, ".tgz") {
+		return FormatTarGz
+	}
+	return FormatZip
+}
+
+func limitError(limit string, max float64, file string, format string, args ...any) error {
+	return &ArchiveError{Reason: fmt.Sprintf(format, args...), Limit: limit, Max: max, File: file}
+}
+
+// UploadLimit returns the maximum size of an upload
+func (l ArchiveLimits) UploadLimit() int64 {
+	return orDefault(l.MaxUpload, DefaultMaxUpload)
+}
+
+func (l ArchiveLimits) withDefaults() ArchiveLimits {
+	l.MaxUpload = orDefault(l.MaxUpload, DefaultMaxUpload)
+	l.MaxSize = orDefault(l.MaxSize, DefaultMaxSize)
+	l.MaxFiles = orDefault(l.MaxFiles, DefaultMaxFiles)
+	l.MaxFileSize = orDefault(l.MaxFileSize, DefaultMaxFileSize)
+	l.MaxRatio = orDefault(l.MaxRatio, DefaultMaxRatio)
+	return l
+}
+
+// orDefault returns v, or def when v is not set
+func orDefault[T int | int64 | float64](v, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// Validate rejects negative limits
+func (l ArchiveLimits) Validate() error {
+	if l.MaxUpload < 0 || l.MaxSize < 0 || l.MaxFiles < 0 || l.MaxFileSize < 0 || l.MaxRatio < 0 {
+		return errors.New("archive limits cannot be negative")
+	}
+	return nil
+}
+
+// check rejects zip archives that exceed the limits according to their
+// headers, extractZip also counts the bytes since headers can lie
+func (l ArchiveLimits) check(zr *zip.Reader) error {
+	if len(zr.File) > l.MaxFiles {
+		return limitError("maxFiles", float64(l.MaxFiles), "", "archive has %v entries, the limit is %v", len(zr.File), l.MaxFiles)
+	}
+	var total uint64
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode&os.ModeSymlink != 0:
+			return &ArchiveError{Reason: "symbolic links are not allowed", File: f.Name}
+		case mode&os.ModeType&^os.ModeDir != 0:
+			return &ArchiveError{Reason: fmt.Sprintf("special file mode %v is not allowed", mode.Type()), File: f.Name}
+		}
+		if f.UncompressedSize64 > uint64(l.MaxFileSize) {
+			return limitError("maxFileSize", float64(l.MaxFileSize), f.Name, "file has %v bytes, the limit is %v", f.UncompressedSize64, l.MaxFileSize)
+		}
+		if err := l.checkRatio(f.Name, f.UncompressedSize64, f.CompressedSize64); err != nil {
+			return err
+		}
+		total += f.UncompressedSize64
+		if total > uint64(l.MaxSize) {
+			return limitError("maxSize", float64(l.MaxSize), "", "archive expands to more than %v bytes", l.MaxSize)
+		}
+	}
+	return nil
+}
+
+// checkRatio rejects files, or whole tarballs when name is empty, that
+// expanded too much
+func (l ArchiveLimits) checkRatio(name string, size, compressed uint64) error {
+	if size <= ratioMinSize {
+		return nil
+	}
+	if ratio := float64(size) / float64(max(compressed, 1)); ratio > l.MaxRatio {
+		return limitError("maxRatio", l.MaxRatio, name, "compression ratio of %.0f, the limit is %v", ratio, l.MaxRatio)
+	}
+	return nil
+}
+
+// extract replaces the contents of srcdir with the files in archive, its
+// format is selected by FormatOf
+func (l ArchiveLimits) extract(archive, srcdir string) error {
+	l = l.withDefaults()
+	info, err := os.Stat(archive)
+	if err != nil {
+		return err
+	}
+	if info.Size() > l.MaxUpload {
+		return limitError("maxUpload", float64(l.MaxUpload), "", "upload has %v bytes, the limit is %v", info.Size(), l.MaxUpload)
+	}
+	switch format := FormatOf(archive); format {
+	case FormatTarGz, FormatTarZst:
+		return l.extractTar(archive, format, srcdir, info.Size())
+	case FormatGoFile:
+		return l.extractGoFile(archive, srcdir)
+	}
+	return l.extractZip(archive, srcdir)
+}
+
+func (l ArchiveLimits) extractZip(zipfile, srcdir string) error {
+	// Open the zip archive
+	zr, err := zip.OpenReader(zipfile)
+	if err != nil {
+		return &ArchiveError{Reason: "open zip: " + err.Error()}
+	}
+	defer zr.Close()
+	if err := l.check(&zr.Reader); err != nil {
+		return err
+	}
+	absSrc, err := resetDir(srcdir)
+	if err != nil {
+		return err
+	}
+
+	// Extract files
+	var total int64
+	for _, f := range zr.File {
+		dest, err := destPath(absSrc, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() || strings.HasSuffix(f.Name, "/") {
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return fmt.Errorf("makedir: %w", err)
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return &ArchiveError{Reason: "open zipped file: " + err.Error(), File: f.Name}
+		}
+		n, err := l.writeFile(rc, dest, f.Name, l.MaxSize-total)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if err := l.checkRatio(f.Name, uint64(n), f.CompressedSize64); err != nil {
+			return err
+		}
+		total += n
+	}
+	return nil
+}
+
+// extractTar extracts a compressed tarball, since tar has no index the
+// limits are checked while the files are written
+func (l ArchiveLimits) extractTar(archive string, format ArchiveFormat, srcdir string, size int64) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader
+	if format == FormatTarZst {
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxZstdMemory))
+		if err != nil {
+			return &ArchiveError{Reason: "open zstd: " + err.Error()}
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return &ArchiveError{Reason: "open gzip: " + err.Error()}
+		}
+		defer gz.Close()
+		r = gz
+	}
+	absSrc, err := resetDir(srcdir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	var total int64
+	for files := 0; ; {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return &ArchiveError{Reason: "read tar: " + err.Error()}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			// written by git archive, it holds no file
+			continue
+		case tar.TypeDir, tar.TypeReg:
+		case tar.TypeSymlink, tar.TypeLink:
+			return &ArchiveError{Reason: "symbolic and hard links are not allowed", File: hdr.Name}
+		default:
+			return &ArchiveError{Reason: fmt.Sprintf("special entry type %q is not allowed", hdr.Typeflag), File: hdr.Name}
+		}
+		if files++; files > l.MaxFiles {
+			return limitError("maxFiles", float64(l.MaxFiles), "", "archive has more than %v entries", l.MaxFiles)
+		}
+		dest, err := destPath(absSrc, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return fmt.Errorf("makedir: %w", err)
+			}
+			continue
+		}
+		n, err := l.writeFile(tr, dest, hdr.Name, l.MaxSize-total)
+		if err != nil {
+			return err
+		}
+		total += n
+		if err := l.checkRatio("", uint64(total), uint64(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// extractGoFile stores a single source file as main.go
+func (l ArchiveLimits) extractGoFile(file, srcdir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	absSrc, err := resetDir(srcdir)
+	if err != nil {
+		return err
+	}
+	_, err = l.writeFile(f, filepath.Join(absSrc, "main.go"), "main.go", l.MaxSize)
+	return err
+}
+
+// resetDir removes the files of the previous deploy (eg.: an old manifest),
+// so they do not leak into this one, and returns the absolute path of dir
+func resetDir(dir string) (string, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return "", fmt.Errorf("clean srcdir: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create srcdir: %w", err)
+	}
+	return filepath.Abs(dir)
+}
+
+// destPath returns where name is extracted, protecting against ZipSlip
+func destPath(absSrc, name string) (string, error) {
+	dest, err := filepath.Abs(filepath.Join(absSrc, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to get abs path: %w", err)
+	}
+	if dest != absSrc && !strings.HasPrefix(dest, absSrc+string(os.PathSeparator)) {
+		return "", &ArchiveError{Reason: "illegal file path", File: name}
+	}
+	return dest, nil
+}
+
+// writeFile copies r to dest, failing once it has more bytes than the
+// limits allow, whatever the archive headers say
+func (l ArchiveLimits) writeFile(r io.Reader, dest, name string, remaining int64) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, fmt.Errorf("mkdir for file: %w", err)
+	}
+	outFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("create file: %w", err)
+	}
+	defer outFile.Close()
+	limit := min(l.MaxFileSize, remaining)
+	n, err := io.Copy(outFile, io.LimitReader(r, limit+1))
+	switch {
+	case errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) || errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, gzip.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, tar.ErrHeader):
+		return n, &ArchiveError{Reason: err.Error(), File: name}
+	case err != nil:
+		return n, fmt.Errorf("copy file contents: %w", err)
+	case n > l.MaxFileSize:
+		return n, limitError("maxFileSize", float64(l.MaxFileSize), name, "file has more than %v bytes", l.MaxFileSize)
+	case n > remaining:
+		return n, limitError("maxSize", float64(l.MaxSize), "", "archive expands to more than %v bytes", l.MaxSize)
+	}
+	return n, outFile.Close()
+}
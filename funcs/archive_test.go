@@ -0,0 +1,165 @@
+package funcs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestArchiveLimits(t *testing.T) {
+	type entry struct {
+		name    string
+		mode    os.FileMode
+		content string
+	}
+	build := func(entries ...entry) string {
+		t.Helper()
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, e := range entries {
+			hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+			if e.mode != 0 {
+				hdr.SetMode(e.mode)
+			}
+			w, err := zw.CreateHeader(hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(e.content))
+		}
+		zw.Close()
+		p := filepath.Join(t.TempDir(), "src.zip")
+		os.WriteFile(p, buf.Bytes(), 0644)
+		return p
+	}
+	main := entry{name: "main.go", content: "package main\n\nfunc main() {}\n"}
+
+	tests := []struct {
+		name    string
+		limits  ArchiveLimits
+		archive string
+		limit   string
+		reason  string
+	}{
+		{"files", ArchiveLimits{MaxFiles: 1}, build(main, entry{name: "b.go", content: "package main"}), "maxFiles", ""},
+		{"file size", ArchiveLimits{MaxFileSize: 10}, build(main), "maxFileSize", ""},
+		{"total size", ArchiveLimits{MaxSize: 40}, build(main, entry{name: "b.go", content: "package main\n\nvar b = 1\n"}), "maxSize", ""},
+		{"ratio", ArchiveLimits{}, build(entry{name: "bomb.txt", content: strings.Repeat("0", 8<<20)}), "maxRatio", ""},
+		{"upload", ArchiveLimits{MaxUpload: 10}, build(main), "maxUpload", ""},
+		{"symlink", ArchiveLimits{}, build(entry{name: "link", mode: os.ModeSymlink | 0777, content: "/etc/passwd"}), "", "symbolic links"},
+		{"device", ArchiveLimits{}, build(entry{name: "dev", mode: os.ModeDevice | 0600}), "", "special file mode"},
+		{"zipslip", ArchiveLimits{}, build(entry{name: "../escape.go", content: "package main"}), "", "illegal file path"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.limits.extract(tc.archive, t.TempDir())
+			var archiveErr *ArchiveError
+			if !errors.As(err, &archiveErr) {
+				t.Fatalf("expected an archive error, got %v", err)
+			}
+			if archiveErr.Limit != tc.limit || !strings.Contains(archiveErr.Reason, tc.reason) {
+				t.Fatalf("unexpected error %+v", archiveErr)
+			}
+			if archiveErr.TooLarge() != (tc.limit != "") {
+				t.Fatalf("unexpected TooLarge for %+v", archiveErr)
+			}
+		})
+	}
+
+	if err := (ArchiveLimits{}).extract(build(main), t.TempDir()); err != nil {
+		t.Fatalf("valid archive was rejected: %v", err)
+	}
+}
+
+func TestExtract_Formats(t *testing.T) {
+	files := map[string]string{
+		"go.mod":      "module example.com/tarball\n\ngo 1.24\n",
+		"cmd/main.go": "package main\n\nfunc main() {}\n",
+	}
+	tarball := func(t *testing.T, format ArchiveFormat, extra ...*tar.Header) string {
+		t.Helper()
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": "abc"}})
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "cmd/", Mode: 0755})
+		for name, content := range files {
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))})
+			tw.Write([]byte(content))
+		}
+		for _, hdr := range extra {
+			tw.WriteHeader(hdr)
+		}
+		tw.Close()
+		p := filepath.Join(t.TempDir(), "src"+string(format))
+		out, _ := os.Create(p)
+		defer out.Close()
+		if format == FormatTarZst {
+			zw, _ := zstd.NewWriter(out)
+			zw.Write(buf.Bytes())
+			zw.Close()
+		} else {
+			gw := gzip.NewWriter(out)
+			gw.Write(buf.Bytes())
+			gw.Close()
+		}
+		return p
+	}
+
+	for _, format := range []ArchiveFormat{FormatTarGz, FormatTarZst} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := (ArchiveLimits{}).extract(tarball(t, format), dir); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range files {
+				if buf, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(buf) != content {
+					t.Fatalf("unexpected %v: %q %v", name, buf, err)
+				}
+			}
+			err := (ArchiveLimits{}).extract(tarball(t, format, &tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc"}), dir)
+			var archiveErr *ArchiveError
+			if !errors.As(err, &archiveErr) || !strings.Contains(archiveErr.Reason, "links are not allowed") {
+				t.Fatalf("links should be rejected, got %v", err)
+			}
+			err = (ArchiveLimits{MaxFiles: 2}).extract(tarball(t, format), dir)
+			if !errors.As(err, &archiveErr) || archiveErr.Limit != "maxFiles" {
+				t.Fatalf("expected maxFiles, got %v", err)
+			}
+		})
+	}
+
+	t.Run("bomb", func(t *testing.T) {
+		files = map[string]string{"zeros": strings.Repeat("0", 8<<20)}
+		err := (ArchiveLimits{}).extract(tarball(t, FormatTarGz), t.TempDir())
+		var archiveErr *ArchiveError
+		if !errors.As(err, &archiveErr) || archiveErr.Limit != "maxRatio" {
+			t.Fatalf("expected maxRatio, got %v", err)
+		}
+	})
+}
+
+func TestCompile_GoFile(t *testing.T) {
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "hello.go")
+	os.WriteFile(src, []byte("package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Print(\"single\") }\n"), 0644)
+	fn, err := Compile(src, filepath.Join(tmp, "src"), filepath.Join(tmp, "bin"), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(fn.Bin()).Output()
+	if err != nil || string(out) != "single" {
+		t.Fatalf("unexpected output %q: %v", out, err)
+	}
+	if gomod, _ := os.ReadFile(filepath.Join(tmp, "src", "go.mod")); !strings.HasPrefix(string(gomod), "module hello\n") {
+		t.Fatalf("unexpected go.mod %q", gomod)
+	}
+}
@@ -0,0 +1,66 @@
+package funcs
+
+import (
+	"errors"
+	"time"
+)
+
+type (
+	// Async controls the delivery of asynchronous invocations.
+	// Zero values use the defaults.
+	Async struct {
+		// MaxAttempts is the number of deliveries before a job is dead-lettered
+		MaxAttempts int `json:"maxAttempts,omitempty"`
+		// MinBackoff and MaxBackoff bound the exponential delay between attempts
+		MinBackoff Duration `json:"minBackoff,omitempty"`
+		MaxBackoff Duration `json:"maxBackoff,omitempty"`
+		// Retention is how long finished jobs (and their results) are kept
+		Retention Duration `json:"retention,omitempty"`
+	}
+)
+
+const (
+	DefaultAsyncMaxAttempts = 5
+	DefaultAsyncMinBackoff  = time.Second
+	DefaultAsyncMaxBackoff  = 5 * time.Minute
+	DefaultAsyncRetention   = 24 * time.Hour
+)
+
+func (a Async) validate() error {
+	if a.MaxAttempts < 0 || a.MinBackoff < 0 || a.MaxBackoff < 0 || a.Retention < 0 {
+		return errors.New("async: attempts, backoff and retention cannot be negative")
+	}
+	return nil
+}
+
+// Attempts returns the maximum number of deliveries, applying the default
+func (a Async) Attempts() int {
+	if a.MaxAttempts <= 0 {
+		return DefaultAsyncMaxAttempts
+	}
+	return a.MaxAttempts
+}
+
+// Backoff returns the delay before the next delivery, after attempt failed deliveries
+func (a Async) Backoff(attempt int) time.Duration {
+	lo, hi := a.MinBackoff.D(), a.MaxBackoff.D()
+	if lo <= 0 {
+		lo = DefaultAsyncMinBackoff
+	}
+	if hi <= 0 {
+		hi = DefaultAsyncMaxBackoff
+	}
+	d := lo
+	for i := 1; i < attempt && d < hi; i++ {
+		d *= 2
+	}
+	return min(d, hi)
+}
+
+// RetentionPeriod returns how long finished jobs are kept, applying the default
+func (a Async) RetentionPeriod() time.Duration {
+	if a.Retention <= 0 {
+		return DefaultAsyncRetention
+	}
+	return a.Retention.D()
+}
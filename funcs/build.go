@@ -0,0 +1,159 @@
+package funcs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type (
+	// BuildSettings control how the uploaded sources are built. They are
+	// read from the manifest at upload time, changing them in the stored
+	// config has no effect until the next upload.
+	BuildSettings struct {
+		// Package is the main package, relative to the root of the upload
+		// (eg.: ./cmd/server), the root is built when it is empty
+		Package string   `json:"package,omitempty"`
+		Tags    []string `json:"tags,omitempty"`
+		LDFlags string   `json:"ldflags,omitempty"`
+		GCFlags string   `json:"gcflags,omitempty"`
+		// TrimPath and CGO are left to the go defaults when nil
+		TrimPath *bool `json:"trimpath,omitempty"`
+		CGO      *bool `json:"cgo,omitempty"`
+		// Env holds additional variables for go build (eg.: GOEXPERIMENT)
+		Env map[string]string `json:"env,omitempty"`
+	}
+)
+
+var validTag = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// reservedBuildEnv are the variables controlled by the server, so uploads
+// cannot resolve modules from elsewhere or write outside of the caches
+var reservedBuildEnv = []string{
+	"GOPROXY", "GONOPROXY", "GOSUMDB", "GONOSUMDB", "GOPRIVATE", "GOINSECURE", "GOVCS",
+	"GOFLAGS", "GOTOOLCHAIN", "GOENV", "GOWORK", "GOPATH", "GOROOT",
+	"GOCACHE", "GOCACHEPROG", "GOMODCACHE", "GOTMPDIR",
+}
+
+func (b BuildSettings) validate() error {
+	if _, err := b.pkg(); err != nil {
+		return err
+	}
+	for _, t := range b.Tags {
+		if !validTag.MatchString(t) {
+			return fmt.Errorf("build: invalid tag %q", t)
+		}
+	}
+	for k := range b.Env {
+		if k == "" || !validTag.MatchString(k) {
+			return fmt.Errorf("build: invalid variable name %q", k)
+		}
+		if k == "CGO_ENABLED" {
+			return errors.New("build: set cgo instead of CGO_ENABLED")
+		}
+		if slices.Contains(reservedBuildEnv, k) {
+			return fmt.Errorf("build: %v is set by the server", k)
+		}
+	}
+	return nil
+}
+
+// pkg returns the package given to go build
+func (b BuildSettings) pkg() (string, error) {
+	if b.Package == "" {
+		return ".", nil
+	}
+	p := path.Clean(filepath.ToSlash(b.Package))
+	if path.IsAbs(p) || p == ".." || len(p) > 2 && p[:3] == "../" {
+		return "", fmt.Errorf("build: package %q is outside of the upload", b.Package)
+	}
+	if p == "." {
+		return p, nil
+	}
+	return "./" + p, nil
+}
+
+// Merge returns b with the values set in o taking precedence
+func (b BuildSettings) Merge(o BuildSettings) BuildSettings {
+	if o.Package != "" {
+		b.Package = o.Package
+	}
+	if o.Tags != nil {
+		b.Tags = o.Tags
+	}
+	if o.LDFlags != "" {
+		b.LDFlags = o.LDFlags
+	}
+	if o.GCFlags != "" {
+		b.GCFlags = o.GCFlags
+	}
+	if o.TrimPath != nil {
+		b.TrimPath = o.TrimPath
+	}
+	if o.CGO != nil {
+		b.CGO = o.CGO
+	}
+	if len(o.Env) > 0 {
+		env := maps.Clone(b.Env)
+		if env == nil {
+			env = map[string]string{}
+		}
+		maps.Copy(env, o.Env)
+		b.Env = env
+	}
+	return b
+}
+
+// flags returns the arguments given to go build, except the output and the package
+func (b BuildSettings) flags() []string {
+	var flags []string
+	if len(b.Tags) > 0 {
+		flags = append(flags, "-tags="+strings.Join(b.Tags, ","))
+	}
+	if b.LDFlags != "" {
+		flags = append(flags, "-ldflags="+b.LDFlags)
+	}
+	if b.GCFlags != "" {
+		flags = append(flags, "-gcflags="+b.GCFlags)
+	}
+	if b.TrimPath != nil && *b.TrimPath {
+		flags = append(flags, "-trimpath")
+	}
+	return flags
+}
+
+// env returns the variables set for go build, sorted to keep digests stable
+func (b BuildSettings) env() []string {
+	var env []string
+	for _, k := range slices.Sorted(maps.Keys(b.Env)) {
+		env = append(env, k+"="+b.Env[k])
+	}
+	if b.CGO != nil {
+		env = append(env, "CGO_ENABLED="+map[bool]string{true: "1", false: "0"}[*b.CGO])
+	}
+	return env
+}
+
+// readBuildSettings returns the build section of the manifest in srcdir
+func readBuildSettings(srcdir string) (BuildSettings, error) {
+	buf, err := os.ReadFile(filepath.Join(srcdir, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return BuildSettings{}, nil
+	} else if err != nil {
+		return BuildSettings{}, fmt.Errorf("read manifest: %w", err)
+	}
+	var manifest struct {
+		Build BuildSettings `json:"build"`
+	}
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return BuildSettings{}, fmt.Errorf("decode %v: %w", ManifestFile, err)
+	}
+	return manifest.Build, nil
+}
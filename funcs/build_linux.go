@@ -0,0 +1,16 @@
+package funcs
+
+import (
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// setProcessGroup runs cmd in its own process group, so canceling a build
+// also stops the compilers and linkers started by go build
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+	}
+}
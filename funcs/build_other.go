@@ -0,0 +1,8 @@
+//go:build !linux
+
+package funcs
+
+import "os/exec"
+
+// setProcessGroup is a no-op, canceling a build only stops the go command
+func setProcessGroup(cmd *exec.Cmd) {}
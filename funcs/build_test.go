@@ -0,0 +1,70 @@
+package funcs
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompile_BuildSettings(t *testing.T) {
+	tmp := t.TempDir()
+	files := map[string]string{
+		"go.mod":           "module example.com/settings\n\ngo 1.24\n",
+		"main.go":          "package main\n\nfunc main() { panic(\"root package should not be built\") }\n",
+		"cmd/app/main.go":  "package main\n\nvar version = \"dev\"\n\nvar edition = \"default\"\n\nfunc main() { print(version, \" \", edition) }\n",
+		"cmd/app/extra.go": "//go:build extra\n\npackage main\n\nfunc init() { edition = \"extra\" }\n",
+		ManifestFile:       `{"build": {"package": "./cmd/app", "tags": ["extra"], "ldflags": "-X main.version=v1", "cgo": false}}`,
+	}
+	zipPath := filepath.Join(tmp, "src.zip")
+	writeZip(t, zipPath, files)
+	srcDir, binDir := filepath.Join(tmp, "src"), filepath.Join(tmp, "bin")
+
+	run := func(overrides BuildSettings) (*Func, string) {
+		t.Helper()
+		fn, err := (&Builder{CacheDir: filepath.Join(tmp, "cache")}).CompileWith(context.Background(), zipPath, srcDir, binDir, "settings", overrides, Origin{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(fn.Bin()).CombinedOutput()
+		if err != nil {
+			t.Fatalf("run: %v: %s", err, out)
+		}
+		return fn, string(out)
+	}
+	fn, out := run(BuildSettings{})
+	if out != "v1 extra" {
+		t.Fatalf("manifest settings were not used, got %q", out)
+	}
+	if cfg := fn.Config().Build; cfg.Package != "./cmd/app" || cfg.CGO == nil || *cfg.CGO {
+		t.Fatalf("build settings were not recorded: %+v", cfg)
+	}
+	// overrides change the digest, so the cached binary is not reused
+	if _, out := run(BuildSettings{LDFlags: "-X main.version=v2", Tags: []string{}}); out != "v2 default" {
+		t.Fatalf("overrides were not used, got %q", out)
+	}
+
+	_, err := (&Builder{}).CompileWith(context.Background(), zipPath, srcDir, binDir, "settings", BuildSettings{Package: "../other"}, Origin{})
+	if err == nil || !strings.Contains(err.Error(), "outside of the upload") {
+		t.Fatalf("packages outside of the upload should be rejected, got %v", err)
+	}
+
+	writeZip(t, zipPath, map[string]string{
+		"go.mod":     "module example.com/settings\n\ngo 1.24\n",
+		"main.go":    "package main\n\nfunc main() {}\n",
+		ManifestFile: `{"build": {"env": {"GOPROXY": "https://proxy.golang.org"}}}`,
+	})
+	_, err = (&Builder{}).CompileWith(context.Background(), zipPath, srcDir, binDir, "settings", BuildSettings{}, Origin{})
+	if err == nil || !strings.Contains(err.Error(), "GOPROXY is set by the server") {
+		t.Fatalf("manifests should not override the module proxy, got %v", err)
+	}
+	writeZip(t, zipPath, map[string]string{
+		"go.mod":  "module example.com/settings\n\ngo 1.24\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	_, err = (&Builder{}).CompileWith(context.Background(), zipPath, srcDir, binDir, "settings", BuildSettings{Env: map[string]string{"GOFLAGS": "-mod=mod"}}, Origin{})
+	if err == nil || !strings.Contains(err.Error(), "GOFLAGS is set by the server") {
+		t.Fatalf("overrides should not set GOFLAGS, got %v", err)
+	}
+}
@@ -0,0 +1,466 @@
+package funcs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Builder compiles uploaded sources. Binaries are cached by the digest of
+	// the source tree, the Go toolchain and the build settings, so sources
+	// that did not change are not built again.
+	Builder struct {
+		// CacheDir holds the cached binaries, nothing is cached when it is empty
+		CacheDir string
+		// GoCache and GoModCache set GOCACHE and GOMODCACHE, go uses its
+		// own defaults when they are empty
+		GoCache    string
+		GoModCache string
+		// Env holds additional variables for the go commands (eg.: GOPROXY),
+		// they are part of the digest since they change how modules resolve
+		Env []string
+		// MaxBinaries is the number of cached binaries kept, the least
+		// recently used are removed first
+		MaxBinaries int
+		// Timeout bounds each build, DefaultBuildTimeout is used when it is zero
+		Timeout time.Duration
+		// Limits are applied to go build, the compilers and linkers it
+		// starts inherit them, so memory and cpu time are capped per process
+		Limits Resources
+		// Parallel is the number of compilers and linkers a build runs at
+		// the same time (go build -p) and the number of go commands b runs
+		// at the same time, the number of CPUs is used when zero
+		Parallel int
+		// Archive limits the uploaded archives
+		Archive ArchiveLimits
+
+		slotsOnce sync.Once
+		slots     chan struct{}
+		// locks holds a chan struct{} per function, see lock
+		locks sync.Map
+
+		toolchainOnce sync.Once
+		toolchain     string
+		toolchainErr  error
+	}
+)
+
+const (
+	// DefaultMaxBinaries is used when Builder.MaxBinaries is not set
+	DefaultMaxBinaries = 100
+	// DefaultBuildTimeout is used when Builder.Timeout is not set
+	DefaultBuildTimeout = 10 * time.Minute
+)
+
+// stampExt is the suffix of the files holding the stamp of cached binaries
+const stampExt = ".stamp"
+
+// ErrBuildLimit is wrapped by the errors of builds stopped by a limit
+var ErrBuildLimit = errors.New("build limit exceeded")
+
+// toolchainVars are the go env variables that change the output of go build
+var toolchainVars = []string{"GOVERSION", "GOOS", "GOARCH", "GOARM", "GOAMD64", "GOARM64", "CGO_ENABLED", "GOEXPERIMENT", "GOFLAGS"}
+
+// Compile builds the function from an archive (see FormatOf), it behaves like
+// Builder.Compile without a cache
+func Compile(zipfile string, srcdir string, bindir string, funcname string) (*Func, error) {
+	return (&Builder{}).Compile(zipfile, srcdir, bindir, funcname)
+}
+
+// Compile extracts zipfile into srcdir and builds it into bindir, reusing
+// a cached binary when the sources did not change
+func (b *Builder) Compile(zipfile string, srcdir string, bindir string, funcname string) (*Func, error) {
+	return b.CompileWith(context.Background(), zipfile, srcdir, bindir, funcname, BuildSettings{}, Origin{})
+}
+
+// CompileWith works like Compile, the values set in overrides take
+// precedence over the build section of the manifest and the build stops
+// when ctx is done. The provenance of the build, including origin, is
+// recorded next to the binary and stamped into it (see StampFlags).
+func (b *Builder) CompileWith(ctx context.Context, zipfile string, srcdir string, bindir string, funcname string, overrides BuildSettings, origin Origin) (*Func, error) {
+	start := time.Now()
+	if err := origin.Validate(); err != nil {
+		return nil, err
+	}
+	unlock, err := b.lock(ctx, funcname)
+	if err != nil {
+		return nil, fmt.Errorf("build canceled: %w", err)
+	}
+	defer unlock()
+	if err := b.Archive.extract(zipfile, srcdir); err != nil {
+		return nil, err
+	}
+	if FormatOf(zipfile) == FormatGoFile {
+		if err := b.initModule(ctx, srcdir, funcname); err != nil {
+			return nil, err
+		}
+	}
+	settings, err := readBuildSettings(srcdir)
+	if err != nil {
+		return nil, err
+	}
+	settings = settings.Merge(overrides)
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	pkg, _ := settings.pkg()
+	srcDigest, err := sourceDigest(srcdir)
+	if err != nil {
+		return nil, fmt.Errorf("digest sources: %w", err)
+	}
+	// the stamp comes first, so -X flags of the upload take precedence
+	stamp := StampFlags(srcDigest, origin.Git)
+	stamped := settings
+	stamped.LDFlags = strings.TrimSpace(stamp + " " + settings.LDFlags)
+
+	// Ensure bindir exists
+	if err := os.MkdirAll(bindir, 0755); err != nil {
+		return nil, fmt.Errorf("create bindir: %w", err)
+	}
+	outPath := filepath.Join(bindir, funcname+".out")
+	flags := []string{"build"}
+	if vendor, err := vendored(srcdir); err != nil {
+		return nil, err
+	} else if vendor {
+		// dependencies come from vendor/, even when GOFLAGS says otherwise
+		flags = append(flags, "-mod=vendor")
+	}
+	args := append(append(flags[:len(flags):len(flags)], stamped.flags()...), "-o", outPath, pkg)
+	buildEnv := settings.env()
+
+	digest := ""
+	if b.CacheDir != "" {
+		// the stamp is left out, so the same sources deployed from another
+		// commit or branch find the binary and only need to be linked again
+		key := append(append(flags, settings.flags()...), pkg)
+		if digest, err = b.digest(srcdir, append(key, buildEnv...)); err != nil {
+			return nil, fmt.Errorf("digest sources: %w", err)
+		}
+	}
+	cached := digest != "" && b.restore(digest, stamp, outPath)
+	if cached {
+		slog.Info("Reusing cached build", "name", funcname, "digest", digest)
+	} else {
+		if b.Parallel > 0 {
+			// -p does not change the output, so it is not part of the digest
+			args = append([]string{args[0], "-p", strconv.Itoa(b.Parallel)}, args[1:]...)
+		}
+		if err := b.run(ctx, srcdir, b.env(buildEnv...), args...); err != nil {
+			return nil, err
+		}
+		if digest != "" {
+			b.store(digest, stamp, outPath)
+		}
+	}
+
+	// Return an empty Func; the caller can set up runtime/proxy as needed
+	fn := &Func{
+		binfile: outPath,
+	}
+	if err := fn.loadConfig(); err != nil {
+		return nil, err
+	}
+	if err := fn.applyManifest(srcdir); err != nil {
+		return nil, err
+	}
+	cfg := fn.Config()
+	cfg.Build = settings
+	if err := fn.UpdateConfig(cfg); err != nil {
+		return nil, err
+	}
+	err = fn.recordProvenance(Provenance{
+		SourceDigest:  srcDigest,
+		Git:           origin.Git,
+		Cached:        cached,
+		BuildDuration: Duration(time.Since(start)),
+		Deployer:      origin.Deployer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+// initModule generates the go.mod of a single file upload, its dependencies
+// are resolved like any other build
+func (b *Builder) initModule(ctx context.Context, srcdir, funcname string) error {
+	if err := b.run(ctx, srcdir, b.env(), "mod", "init", funcname); err != nil {
+		return err
+	}
+	return b.run(ctx, srcdir, b.env(), "mod", "tidy")
+}
+
+// run runs go with args under the limits of b, errors caused by the limits
+// wrap ErrBuildLimit
+func (b *Builder) run(ctx context.Context, dir string, env []string, args ...string) error {
+	timeout := b.Timeout
+	if timeout <= 0 {
+		timeout = DefaultBuildTimeout
+	}
+	// concurrent uploads queue here, so the server never runs more
+	// toolchains than Parallel
+	release, err := b.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("build canceled: %w", err)
+	}
+	defer release()
+	buildCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// the limits are set before go starts, the compilers and linkers it
+	// starts inherit them
+	cmd, err := b.Limits.command(buildCtx, env, "go", args...)
+	if err != nil {
+		return fmt.Errorf("build %w", err)
+	}
+	cmd.Dir = dir
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.WaitDelay = time.Second
+	setProcessGroup(cmd)
+	err = cmd.Run()
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("build canceled: %w", ctx.Err())
+	case buildCtx.Err() != nil:
+		return fmt.Errorf("%w: build timed out after %v", ErrBuildLimit, timeout)
+	}
+	output := out.String()
+	switch {
+	case b.Limits.MaxMemory > 0 && outOfMemory(output+err.Error()):
+		return fmt.Errorf("%w: build ran out of memory (maxMemory=%v bytes per process): %s", ErrBuildLimit, b.Limits.MaxMemory, output)
+	case b.Limits.MaxCPUTime > 0 && strings.Contains(output+err.Error(), "CPU time limit exceeded"):
+		return fmt.Errorf("%w: build used too much cpu time (maxCPUTime=%v per process): %s", ErrBuildLimit, b.Limits.MaxCPUTime.D(), output)
+	}
+	return &BuildError{
+		Command:     "go " + args[0],
+		Err:         err.Error(),
+		Diagnostics: parseDiagnostics(dir, output),
+		Output:      output,
+	}
+}
+
+// lock waits until no other upload of funcname is being built, uploads of
+// the same function share srcdir and bindir
+func (b *Builder) lock(ctx context.Context, funcname string) (func(), error) {
+	v, _ := b.locks.LoadOrStore(funcname, make(chan struct{}, 1))
+	l := v.(chan struct{})
+	select {
+	case l <- struct{}{}:
+		return func() { <-l }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquire waits for one of the Parallel slots shared by the builds of b
+func (b *Builder) acquire(ctx context.Context) (func(), error) {
+	b.slotsOnce.Do(func() {
+		n := b.Parallel
+		if n <= 0 {
+			n = runtime.NumCPU()
+		}
+		b.slots = make(chan struct{}, n)
+	})
+	select {
+	case b.slots <- struct{}{}:
+		return func() { <-b.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// outOfMemory returns true if the output of a build shows that it failed to
+// allocate or reserve memory
+func outOfMemory(output string) bool {
+	for _, msg := range []string{"out of memory", "cannot allocate memory", "failed to reserve"} {
+		if strings.Contains(output, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// env returns the environment of the go commands, the variables set by
+// the builder come after extra so they cannot be overridden
+func (b *Builder) env(extra ...string) []string {
+	env := append(os.Environ(), extra...)
+	if b.GoCache != "" {
+		env = append(env, "GOCACHE="+b.GoCache)
+	}
+	if b.GoModCache != "" {
+		env = append(env, "GOMODCACHE="+b.GoModCache)
+	}
+	return append(env, b.Env...)
+}
+
+// digest hashes the source tree (except the manifest, its build section is
+// part of args), the toolchain and the build arguments
+func (b *Builder) digest(srcdir string, args []string) (string, error) {
+	b.toolchainOnce.Do(func() {
+		cmd := exec.Command("go", append([]string{"env"}, toolchainVars...)...)
+		cmd.Env = b.env()
+		out, err := cmd.Output()
+		b.toolchain, b.toolchainErr = string(out), err
+	})
+	if b.toolchainErr != nil {
+		return "", fmt.Errorf("go env: %w", b.toolchainErr)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "toolchain\x00%s\x00env\x00%s\x00args\x00%s\x00", b.toolchain, strings.Join(b.Env, "\x00"), strings.Join(args, "\x00"))
+	if err := hashTree(h, srcdir, ManifestFile); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTree writes the names, sizes and contents of the files under srcdir
+// to h, except skip (relative to srcdir)
+func hashTree(h io.Writer, srcdir string, skip string) error {
+	// WalkDir visits files in lexical order, which keeps the digest stable
+	return filepath.WalkDir(srcdir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(srcdir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == skip {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "file\x00%s\x00%d\x00", rel, info.Size())
+		_, err = io.Copy(h, f)
+		return err
+	})
+}
+
+// restore copies the cached binary to outPath, returns false on cache misses
+func (b *Builder) restore(digest, stamp, outPath string) bool {
+	cached := filepath.Join(b.CacheDir, digest)
+	if _, err := os.Stat(cached); err != nil {
+		return false
+	}
+	// the packages are still in GOCACHE, so a binary stamped differently
+	// costs a link
+	if prev, err := os.ReadFile(cached + stampExt); err != nil || string(prev) != stamp {
+		slog.Info("Cached build has a different stamp, linking again", "digest", digest)
+		return false
+	}
+	if err := replaceFile(cached, outPath); err != nil {
+		slog.Warn("Unable to reuse cached build", "digest", digest, "error", err)
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	return true
+}
+
+// store adds the binary to the cache, failures only cost a future rebuild
+func (b *Builder) store(digest, stamp, outPath string) {
+	if err := os.MkdirAll(b.CacheDir, 0755); err != nil {
+		slog.Warn("Unable to cache build", "digest", digest, "error", err)
+		return
+	}
+	cached := filepath.Join(b.CacheDir, digest)
+	if err := os.WriteFile(cached+stampExt, []byte(stamp), 0644); err != nil {
+		slog.Warn("Unable to cache build", "digest", digest, "error", err)
+		return
+	}
+	if err := replaceFile(outPath, cached); err != nil {
+		slog.Warn("Unable to cache build", "digest", digest, "error", err)
+		return
+	}
+	b.prune()
+}
+
+// prune removes the least recently used binaries above MaxBinaries
+func (b *Builder) prune() {
+	limit := b.MaxBinaries
+	if limit <= 0 {
+		limit = DefaultMaxBinaries
+	}
+	entries, err := os.ReadDir(b.CacheDir)
+	if err != nil {
+		return
+	}
+	type entry struct {
+		name string
+		used time.Time
+	}
+	var bins []entry
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), stampExt) {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			bins = append(bins, entry{e.Name(), info.ModTime()})
+		}
+	}
+	slices.SortFunc(bins, func(a, b entry) int { return a.used.Compare(b.used) })
+	for _, e := range bins[:max(len(bins)-limit, 0)] {
+		os.Remove(filepath.Join(b.CacheDir, e.name))
+		os.Remove(filepath.Join(b.CacheDir, e.name+stampExt))
+	}
+}
+
+// replaceFile atomically replaces dst with a copy of src, a running binary
+// at dst keeps working since it is replaced and not overwritten
+func replaceFile(src, dst string) error {
+	tmp := fmt.Sprintf("%v.tmp-%v", dst, time.Now().UnixNano())
+	if err := os.Link(src, tmp); err != nil {
+		if err := copyFile(src, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,74 @@
+package funcs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuilder_ReusesCachedBinaries(t *testing.T) {
+	tmp := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/cached\n\ngo 1.24\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	}
+	zipPath := filepath.Join(tmp, "src.zip")
+	writeZip(t, zipPath, files)
+
+	b := &Builder{CacheDir: filepath.Join(tmp, "cache")}
+	srcDir, binDir := filepath.Join(tmp, "src"), filepath.Join(tmp, "bin")
+	if _, err := b.Compile(zipPath, srcDir, binDir, "cached"); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(binDir, "cached.out"))
+
+	// without go in the PATH only cached builds succeed
+	t.Setenv("PATH", "")
+	files["gofunc.json"] = `{"env":{"GREETING":"hi"}}`
+	writeZip(t, zipPath, files)
+	fn, err := b.Compile(zipPath, srcDir, binDir, "cached")
+	if err != nil {
+		t.Fatalf("unchanged sources should not be built again: %v", err)
+	}
+	if _, err := os.Stat(fn.Bin()); err != nil {
+		t.Fatalf("binary was not restored: %v", err)
+	}
+	if fn.Config().Env["GREETING"] != "hi" {
+		t.Fatalf("manifest was not applied: %v", fn.Config().Env)
+	}
+
+	files["main.go"] = "package main\n\nfunc main() { println() }\n"
+	writeZip(t, zipPath, files)
+	if _, err := b.Compile(zipPath, srcDir, binDir, "cached"); err == nil {
+		t.Fatal("changed sources should be built again")
+	}
+}
+
+func TestBuilder_ConcurrentUploads(t *testing.T) {
+	tmp := t.TempDir()
+	// mixing both trees fails with x redeclared
+	var zips []string
+	for _, name := range []string{"a", "b"} {
+		zipPath := filepath.Join(tmp, name+".zip")
+		writeZip(t, zipPath, map[string]string{
+			"go.mod":     "module example.com/concurrent\n\ngo 1.24\n",
+			"main.go":    "package main\n\nfunc main() { print(x) }\n",
+			name + ".go": "package main\n\nvar x = \"" + name + "\"\n",
+		})
+		zips = append(zips, zipPath)
+	}
+	b := &Builder{}
+	srcDir, binDir := filepath.Join(tmp, "src"), filepath.Join(tmp, "bin")
+	errs := make(chan error, 6)
+	for i := range cap(errs) {
+		go func() {
+			_, err := b.Compile(zips[i%2], srcDir, binDir, "concurrent")
+			errs <- err
+		}()
+	}
+	for range cap(errs) {
+		if err := <-errs; err != nil {
+			t.Fatalf("concurrent uploads of the same function should not interfere: %v", err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type (
+	// ColdStart controls how requests are held while the function is
+	// starting, restarting after a crash or being redeployed
+	ColdStart struct {
+		// Timeout is how long a request waits for the function to be ready,
+		// defaults to DefaultColdStartTimeout
+		Timeout Duration `json:"timeout,omitempty"`
+		// MaxPending is the number of requests that can wait at the same time,
+		// defaults to DefaultMaxPending
+		MaxPending int `json:"maxPending,omitempty"`
+	}
+)
+
+const (
+	DefaultColdStartTimeout = 30 * time.Second
+	DefaultMaxPending       = 1000
+
+	// maxCrashWait is the number of crashes in a row after which requests
+	// stop waiting for the function to come back
+	maxCrashWait = 3
+)
+
+func (c ColdStart) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return DefaultColdStartTimeout
+	}
+	return c.Timeout.D()
+}
+
+func (c ColdStart) maxPending() int64 {
+	if c.MaxPending == 0 {
+		return DefaultMaxPending
+	}
+	return int64(c.MaxPending)
+}
+
+var (
+	errNotStarting    = errors.New("function is not running")
+	errCrashLoop      = errors.New("function keeps crashing")
+	errTooManyPending = errors.New("function starting, too many pending requests")
+	errStartTimeout   = errors.New("function did not start in time")
+)
+
+// waitProxy parks the request until the function is ready.
+//
+// If the function is not going to be ready, or the request waited for too long,
+// an error is sent to the client and nil is returned.
+func (f *Func) waitProxy(w http.ResponseWriter, r *http.Request, cs ColdStart) *backend {
+	b, err := f.waitBackend(r.Context(), cs)
+	switch {
+	case err == nil:
+		return b
+	case errors.Is(err, errNotStarting):
+		f.proxyError(w, r, nil)
+	case errors.Is(err, errCrashLoop):
+		f.writeError(w, http.StatusBadGateway, "crashed", err.Error())
+	case errors.Is(err, errTooManyPending), errors.Is(err, errStartTimeout):
+		f.writeError(w, http.StatusServiceUnavailable, "starting", err.Error())
+	default:
+		f.writeError(w, http.StatusServiceUnavailable, "starting", "request canceled while function was starting")
+	}
+	return nil
+}
+
+// waitBackend waits until the function is ready, up to the cold start timeout
+func (f *Func) waitBackend(ctx context.Context, cs ColdStart) (*backend, error) {
+	if b := f.proxy.Load(); b != nil {
+		return b, nil
+	}
+	if err := f.startingUp(); err != nil {
+		return nil, err
+	}
+	if f.pending.Add(1) > cs.maxPending() {
+		f.pending.Add(-1)
+		return nil, errTooManyPending
+	}
+	defer f.pending.Add(-1)
+
+	timeout := time.NewTimer(cs.timeout())
+	defer timeout.Stop()
+	for {
+		changed := f.stateChange()
+		if b := f.proxy.Load(); b != nil {
+			return b, nil
+		}
+		if err := f.startingUp(); err != nil {
+			return nil, err
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return nil, errStartTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// startingUp returns nil if the function is expected to be ready soon,
+// crashed functions are restarted by their supervisor unless they keep crashing
+func (f *Func) startingUp() error {
+	st := f.getState()
+	switch {
+	case st != stateStarting && st != stateCrashed:
+		return errNotStarting
+	case f.crashes.Load() >= maxCrashWait:
+		return errCrashLoop
+	}
+	return nil
+}
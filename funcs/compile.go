@@ -1,28 +1,40 @@
 package funcs
 
 import (
-	"archive/zip"
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"net"
-	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// DefaultStopTimeout is used when Config.StopTimeout is not set
+const DefaultStopTimeout = 10 * time.Second
+
 type (
 	Func struct {
 		binfile string
 		proc    *os.Process
-		proxy   *httputil.ReverseProxy
+		proxy   atomic.Pointer[backend]
+
+		state        atomic.Int32
+		crashes      atomic.Int32
+		stateLock    sync.Mutex
+		stateChanged chan struct{}
+		pending      atomic.Int64
+
+		cfgLock sync.Mutex
+		cfg     Config
+		hostEnv []string
 	}
 )
 
@@ -35,112 +47,73 @@ func (f *Func) Bin() string {
 	return f.binfile
 }
 
-func Compile(zipfile string, srcdir string, bindir string, funcname string) (*Func, error) {
-	// Open the zip archive
-	zr, err := zip.OpenReader(zipfile)
-	if err != nil {
-		return nil, fmt.Errorf("open zip: %w", err)
-	}
-	defer zr.Close()
+// SetHostEnv sets variables provided by the host (eg.: GOFUNC_URL),
+// they are applied the next time the function starts
+func (f *Func) SetHostEnv(env ...string) {
+	f.cfgLock.Lock()
+	defer f.cfgLock.Unlock()
+	f.hostEnv = slices.Clone(env)
+}
 
-	// Ensure srcdir exists
-	if err := os.MkdirAll(srcdir, 0755); err != nil {
-		return nil, fmt.Errorf("create srcdir: %w", err)
+func (f *Func) Run(ctx context.Context) error {
+	if f.binfile == "" {
+		return errors.New("no binary to run")
 	}
-
-	// Extract files
-	absSrc, _ := filepath.Abs(srcdir)
-	for _, f := range zr.File {
-		// Protect against ZipSlip
-		destPath := filepath.Join(srcdir, f.Name)
-		destPathClean, err := filepath.Abs(filepath.Clean(destPath))
-		if err != nil {
-			return nil, fmt.Errorf("failed to get abs path: %w", err)
-		}
-		if destPathClean != absSrc && !strings.HasPrefix(destPathClean, absSrc+string(os.PathSeparator)) {
-			return nil, fmt.Errorf("illegal file path in zip: %s", f.Name)
-		}
-
-		if f.FileInfo().IsDir() || strings.HasSuffix(f.Name, "/") {
-			if err := os.MkdirAll(destPathClean, 0755); err != nil {
-				return nil, fmt.Errorf("makedir: %w", err)
-			}
-			continue
-		}
-
-		if err := os.MkdirAll(filepath.Dir(destPathClean), 0755); err != nil {
-			return nil, fmt.Errorf("mkdir for file: %w", err)
+	f.setState(stateStarting)
+	defer func() {
+		if f.getState() == stateStarting {
+			f.setState(stateCrashed)
 		}
+	}()
+	cfg := f.Config()
 
-		rc, err := f.Open()
+	// Prepare command with env vars, the ones set by gofunc come last
+	// so they cannot be overridden
+	env := os.Environ()
+	for _, k := range slices.Sorted(maps.Keys(cfg.Env)) {
+		env = append(env, k+"="+cfg.Env[k])
+	}
+	f.cfgLock.Lock()
+	env = append(env, f.hostEnv...)
+	f.cfgLock.Unlock()
+	var portStr string
+	var ports portMap
+	if !cfg.IsWorker() {
+		// Find a free random port by listening on :0
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
 		if err != nil {
-			return nil, fmt.Errorf("open zipped file: %w", err)
+			return fmt.Errorf("listen: %w", err)
 		}
-		outFile, err := os.OpenFile(destPathClean, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		addr := ln.Addr().String()
+		// extract port
+		_, portStr, err = net.SplitHostPort(addr)
 		if err != nil {
-			rc.Close()
-			return nil, fmt.Errorf("create file: %w", err)
-		}
-		if _, err := io.Copy(outFile, rc); err != nil {
-			outFile.Close()
-			rc.Close()
-			return nil, fmt.Errorf("copy file contents: %w", err)
+			ln.Close()
+			return fmt.Errorf("split host port: %w", err)
 		}
-		outFile.Close()
-		rc.Close()
-	}
-
-	// Ensure bindir exists
-	if err := os.MkdirAll(bindir, 0755); err != nil {
-		return nil, fmt.Errorf("create bindir: %w", err)
-	}
-
-	// Run go build from srcdir
-	outPath := filepath.Join(bindir, funcname+".out")
-	cmd := exec.Command("go", "build", "-o", outPath, ".")
-	cmd.Dir = srcdir
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("go build failed: %w: %s", err, string(out))
-	}
-
-	// Return an empty Func; the caller can set up runtime/proxy as needed
-	return &Func{
-		binfile: outPath,
-	}, nil
-}
+		// close the listener to free the port for the process
+		ln.Close()
 
-func (f *Func) Run(ctx context.Context) error {
-	if f.binfile == "" {
-		return errors.New("no binary to run")
+		var portEnv []string
+		ports, portEnv, err = allocatePorts(cfg.Ports)
+		if err != nil {
+			return err
+		}
+		env = append(env, "BIND_ADDR=127.0.0.1")
+		env = append(env, fmt.Sprintf("BIND_PORT=%s", portStr))
+		env = append(env, portEnv...)
 	}
 
-	// Find a free random port by listening on :0
-	ln, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		return fmt.Errorf("listen: %w", err)
-	}
-	addr := ln.Addr().String()
-	// extract port
-	_, portStr, err := net.SplitHostPort(addr)
+	// the process is stopped by us once in-flight requests are drained, its
+	// limits are set before it starts so everything it runs is covered
+	cmd, err := cfg.Resources.command(context.Background(), env, f.binfile)
 	if err != nil {
-		ln.Close()
-		return fmt.Errorf("split host port: %w", err)
+		return err
 	}
-	// close the listener to free the port for the process
-	ln.Close()
-
-	// Prepare command with env vars
-	env := os.Environ()
-	env = append(env, "BIND_ADDR=127.0.0.1")
-	env = append(env, fmt.Sprintf("BIND_PORT=%s", portStr))
-
-	cmd := exec.CommandContext(ctx, f.binfile)
-	cmd.Env = env
-	// redirect stdout/stderr to parent for debugging (optional)
-	cmd.Stdout = os.Stdout
-	buf := bytes.Buffer{}
-	cmd.Stderr = &buf
+	stdout, stderr := newLogWriter(f.Name(), "stdout"), newLogWriter(f.Name(), "stderr")
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// children of the function might keep the output open after it exits
+	cmd.WaitDelay = time.Second
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("start process: %w", err)
@@ -152,24 +125,35 @@ func (f *Func) Run(ctx context.Context) error {
 	// Reap process in background and capture exit
 	done := make(chan error, 1)
 	go func() {
-		done <- cmd.Wait()
+		err := cmd.Wait()
+		stdout.flush()
+		stderr.flush()
 		f.proc = nil
+		done <- err
 	}()
 
+	if cfg.IsWorker() {
+		// workers have nothing to wait for, they are ready once started
+		f.setState(stateReady)
+		return f.supervise(ctx, cmd, done, nil, cfg)
+	}
+
 	// Wait for the process to start accepting connections
 	targetHost := fmt.Sprintf("127.0.0.1:%s", portStr)
 	var lastErr error
 	deadline := time.Now().Add(5 * time.Second)
+	// non-HTTP functions are ready once any of their TCP ports accepts connections
+	readyTargets := append([]string{targetHost}, ports.tcp()...)
 	for {
-		conn, err := net.DialTimeout("tcp", targetHost, 200*time.Millisecond)
+		err := dialAny(readyTargets, 200*time.Millisecond)
 		if err == nil {
-			conn.Close()
 			break
 		}
 		lastErr = err
 		select {
 		case <-ctx.Done():
 			_ = cmd.Process.Kill()
+			f.setState(stateStopped)
 			return fmt.Errorf("context canceled while waiting for backend: %w", ctx.Err())
 		case err, closed := <-done:
 			if !closed || err != nil {
@@ -183,28 +167,60 @@ func (f *Func) Run(ctx context.Context) error {
 		}
 		time.Sleep(50 * time.Millisecond)
 	}
-
-	// Build proxy to the running process
-	target := &url.URL{Scheme: "http", Host: targetHost}
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	// Ensure the director preserves the original request path and query
-	origDirector := proxy.Director
-	proxy.Director = func(r *http.Request) {
-		origDirector(r)
-		// keep Host header of target
-		r.Host = target.Host
+	if cfg.Protocol == ProtocolGRPC {
+		if err := waitGRPCHealth(ctx, targetHost, deadline, done); err != nil {
+			_ = cmd.Process.Kill()
+			return err
+		}
 	}
 
-	f.proxy = proxy
+	// Build proxy to the running process
+	b := f.newBackend(targetHost)
+	b.ports = ports
+	f.ready(b)
+	return f.supervise(ctx, cmd, done, b, cfg)
+}
 
+// supervise waits for the process to exit or ctx to be done, in which case the
+// backend (if any) is drained before the process is asked to stop
+func (f *Func) supervise(ctx context.Context, cmd *exec.Cmd, done chan error, b *backend, cfg Config) error {
 	select {
 	case <-ctx.Done():
+		f.stop(stateStopped)
+		if b != nil {
+			f.drain(b)
+		}
+		terminate(cmd, done, cfg.stopTimeout())
 		return ctx.Err()
 	case err := <-done:
+		f.stop(stateCrashed)
+		if b != nil {
+			b.cancel()
+		}
+		if err == nil {
+			err = errors.New("process exited")
+		}
 		return err
 	}
 }
 
-func (f *Func) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	f.proxy.ServeHTTP(w, r)
+// terminate sends SIGTERM to the process and kills it if it does not exit
+// within timeout
+func terminate(cmd *exec.Cmd, done chan error, timeout time.Duration) {
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		_ = cmd.Process.Kill()
+		<-done
+	}
+}
+
+func (c Config) stopTimeout() time.Duration {
+	if c.StopTimeout <= 0 {
+		return DefaultStopTimeout
+	}
+	return c.StopTimeout.D()
 }
@@ -0,0 +1,237 @@
+package funcs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+)
+
+type (
+	// Config holds the per-function settings managed through the admin API,
+	// it is stored next to the function binary
+	Config struct {
+		// Kind selects between a service (the default) and a background worker,
+		// changes take effect the next time the function starts
+		Kind Kind `json:"kind,omitempty"`
+		// Env holds additional environment variables for the process
+		Env       map[string]string `json:"env,omitempty"`
+		Resources Resources         `json:"resources"`
+		// StopTimeout is how long the process has to exit after SIGTERM,
+		// before it is killed
+		StopTimeout Duration `json:"stopTimeout,omitempty"`
+
+		// Protocol used to talk to the function, changes take effect
+		// the next time the function starts
+		Protocol  Protocol     `json:"protocol,omitempty"`
+		Limits    Limits       `json:"limits"`
+		HTTP      HTTPSettings `json:"http"`
+		ColdStart ColdStart    `json:"coldStart"`
+		Streaming Streaming    `json:"streaming"`
+		// Ports are additional TCP/UDP ports forwarded to the function
+		Ports []Port `json:"ports,omitempty"`
+		// Schedules invoke the function periodically
+		Schedules []Schedule `json:"schedules,omitempty"`
+		Async     Async      `json:"async"`
+		// Subscriptions deliver the events published to a topic
+		Subscriptions []Subscription `json:"subscriptions,omitempty"`
+		// DropFolders invoke the function with the files written to a directory
+		DropFolders []DropFolder `json:"dropFolders,omitempty"`
+		// Build holds the settings used to build the current binary
+		Build BuildSettings `json:"build"`
+	}
+
+	// Limits protect the host from traffic spikes on a single function.
+	// Zero values disable the respective limit.
+	Limits struct {
+		// Rate and Burst configure a token bucket shared by all clients
+		Rate  float64 `json:"rate,omitempty"`
+		Burst int     `json:"burst,omitempty"`
+
+		// ClientRate and ClientBurst configure a token bucket per client,
+		// clients are identified by their IP address
+		ClientRate  float64 `json:"clientRate,omitempty"`
+		ClientBurst int     `json:"clientBurst,omitempty"`
+		// ClientKeyHeader identifies clients by the value of a header
+		// (eg.: X-API-Key) instead of their IP address. The header is trusted
+		// as is, so it must be set or checked by a proxy in front of gofunc.
+		// Requests without it fall back to the IP address.
+		ClientKeyHeader string `json:"clientKeyHeader,omitempty"`
+
+		// MaxConcurrency is the number of requests served at the same time,
+		// up to MaxQueue requests wait QueueTimeout for a free slot
+		MaxConcurrency int      `json:"maxConcurrency,omitempty"`
+		MaxQueue       int      `json:"maxQueue,omitempty"`
+		QueueTimeout   Duration `json:"queueTimeout,omitempty"`
+	}
+
+	// Duration is a time.Duration encoded as a string (eg.: "1m30s")
+	Duration time.Duration
+)
+
+// validHeader matches the header names accepted by limits
+var validHeader = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+func (d Duration) D() time.Duration { return time.Duration(d) }
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(txt []byte) error {
+	v, err := time.ParseDuration(string(txt))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Validate returns an error if the config contains invalid values
+func (c Config) Validate() error {
+	if err := c.Kind.validate(); err != nil {
+		return err
+	}
+	if c.IsWorker() && len(c.Ports) > 0 {
+		return errors.New("ports: workers cannot expose ports")
+	}
+	if err := validateEnv(c.Env); err != nil {
+		return err
+	}
+	if err := c.Resources.validate(); err != nil {
+		return err
+	}
+	if c.StopTimeout < 0 {
+		return errors.New("stopTimeout: cannot be negative")
+	}
+	l := c.Limits
+	if l.Rate < 0 || l.ClientRate < 0 || l.Burst < 0 || l.ClientBurst < 0 {
+		return errors.New("limits: rate and burst cannot be negative")
+	}
+	if l.MaxConcurrency < 0 || l.MaxQueue < 0 || l.QueueTimeout < 0 {
+		return errors.New("limits: concurrency, queue and timeout cannot be negative")
+	}
+	if l.ClientKeyHeader != "" && !validHeader.MatchString(l.ClientKeyHeader) {
+		return fmt.Errorf("limits: invalid clientKeyHeader %q", l.ClientKeyHeader)
+	}
+	switch c.Protocol {
+	case ProtocolHTTP1, ProtocolH2C, ProtocolGRPC:
+	default:
+		return fmt.Errorf("protocol: unknown protocol %q", c.Protocol)
+	}
+	h := c.HTTP
+	if h.RequestTimeout < 0 || h.MaxRequestBody < 0 || h.MaxResponseHeader < 0 {
+		return errors.New("http: timeouts and sizes cannot be negative")
+	}
+	if c.ColdStart.Timeout < 0 || c.ColdStart.MaxPending < 0 {
+		return errors.New("coldStart: timeout and maxPending cannot be negative")
+	}
+	if c.Streaming.IdleTimeout < 0 || c.Streaming.MaxConnections < 0 || c.Streaming.DrainTimeout < 0 {
+		return errors.New("streaming: timeouts and maxConnections cannot be negative")
+	}
+	names := map[string]bool{}
+	for _, p := range c.Ports {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		if names[p.Name] {
+			return fmt.Errorf("ports: duplicated name %q", p.Name)
+		}
+		names[p.Name] = true
+	}
+	if err := c.Async.validate(); err != nil {
+		return err
+	}
+	if err := validateSubscriptions(c); err != nil {
+		return err
+	}
+	if err := validateDropFolders(c); err != nil {
+		return err
+	}
+	if err := c.Build.validate(); err != nil {
+		return err
+	}
+	return validateSchedules(c)
+}
+
+// NeedsRestart returns true if changing from old to c only takes
+// effect after the process is restarted
+func (c Config) NeedsRestart(old Config) bool {
+	return c.Kind != old.Kind ||
+		!maps.Equal(c.Env, old.Env) ||
+		c.Resources != old.Resources ||
+		c.Protocol != old.Protocol ||
+		!slices.Equal(c.Ports, old.Ports) ||
+		c.HTTP.MaxResponseHeader != old.HTTP.MaxResponseHeader ||
+		c.Streaming.IdleTimeout != old.Streaming.IdleTimeout
+}
+
+// ConfigFile returns the path where the function config is stored
+func (f *Func) ConfigFile() string {
+	return filepath.Join(filepath.Dir(f.binfile), f.Name()+".json")
+}
+
+// Config returns a copy of the current function config
+func (f *Func) Config() Config {
+	f.cfgLock.Lock()
+	defer f.cfgLock.Unlock()
+	return f.cfg
+}
+
+// UpdateConfig validates and persists c as the new function config
+func (f *Func) UpdateConfig(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	f.cfgLock.Lock()
+	defer f.cfgLock.Unlock()
+	if err := c.Save(f.ConfigFile()); err != nil {
+		return err
+	}
+	f.cfg = c
+	return nil
+}
+
+func (f *Func) loadConfig() error {
+	c, err := LoadConfig(f.ConfigFile())
+	if err != nil {
+		return err
+	}
+	f.cfgLock.Lock()
+	f.cfg = c
+	f.cfgLock.Unlock()
+	return nil
+}
+
+// LoadConfig reads the config from file, a missing file results in the zero Config
+func LoadConfig(file string) (Config, error) {
+	var c Config
+	buf, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return c, fmt.Errorf("read config: %w", err)
+	}
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return c, fmt.Errorf("decode config %q: %w", file, err)
+	}
+	return c, nil
+}
+
+// Save writes the config to file
+func (c Config) Save(file string) error {
+	buf, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,78 @@
+package funcs
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Diagnostic is an error reported by the compiler, File is relative to
+	// the root of the upload when the file is part of it
+	Diagnostic struct {
+		File    string `json:"file"`
+		Line    int    `json:"line"`
+		Column  int    `json:"column,omitempty"`
+		Message string `json:"message"`
+		Package string `json:"package,omitempty"`
+	}
+
+	// BuildError is returned when a go command fails, Output holds what
+	// it printed and Diagnostics the errors parsed from it
+	BuildError struct {
+		Command     string       `json:"command"`
+		Err         string       `json:"error"`
+		Diagnostics []Diagnostic `json:"diagnostics"`
+		Output      string       `json:"output"`
+	}
+)
+
+// diagnosticLine matches file:line[:column]: message
+var diagnosticLine = regexp.MustCompile(`^(\S+\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("%v failed: %v: %v", e.Command, e.Err, e.Output)
+}
+
+// parseDiagnostics extracts the compiler errors from the output of a go
+// command run in srcdir. Lines starting with a tab continue the previous
+// message and "# pkg" lines name the package of the errors below them.
+func parseDiagnostics(srcdir, output string) []Diagnostic {
+	diags := []Diagnostic{}
+	pkg := ""
+	absSrc, _ := filepath.Abs(srcdir)
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			pkg = strings.TrimPrefix(line, "# ")
+			continue
+		case strings.HasPrefix(line, "\t") && len(diags) > 0:
+			diags[len(diags)-1].Message += "\n" + strings.TrimPrefix(line, "\t")
+			continue
+		}
+		m := diagnosticLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{File: relativeFile(absSrc, m[1]), Message: m[4], Package: pkg}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// relativeFile returns file relative to absSrc, files outside of it (eg.:
+// in the module cache) are returned as they are
+func relativeFile(absSrc, file string) string {
+	if !filepath.IsAbs(file) {
+		return filepath.ToSlash(filepath.Clean(file))
+	}
+	rel, err := filepath.Rel(absSrc, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return file
+	}
+	return filepath.ToSlash(rel)
+}
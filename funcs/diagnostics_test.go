@@ -0,0 +1,51 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	src := t.TempDir()
+	output := "# x/cmd/app\n" +
+		"cmd/app/main.go:2:14: declared and not used: x\n" +
+		"./util.go:7: missing return\n" +
+		src + "/cmd/app/run.go:9:2: too many return values\n" +
+		"\thave (number)\n" +
+		"\twant ()\n" +
+		"/go/pkg/mod/example.com/dep@v1.0.0/dep.go:3:1: syntax error\n" +
+		"too many errors\n"
+	expected := []Diagnostic{
+		{File: "cmd/app/main.go", Line: 2, Column: 14, Message: "declared and not used: x", Package: "x/cmd/app"},
+		{File: "util.go", Line: 7, Message: "missing return", Package: "x/cmd/app"},
+		{File: "cmd/app/run.go", Line: 9, Column: 2, Message: "too many return values\nhave (number)\nwant ()", Package: "x/cmd/app"},
+		{File: "/go/pkg/mod/example.com/dep@v1.0.0/dep.go", Line: 3, Column: 1, Message: "syntax error", Package: "x/cmd/app"},
+	}
+	if diags := parseDiagnostics(src, output); !reflect.DeepEqual(diags, expected) {
+		t.Fatalf("unexpected diagnostics:\n%+v\nexpected\n%+v", diags, expected)
+	}
+}
+
+func TestBuilder_Diagnostics(t *testing.T) {
+	tmp := t.TempDir()
+	zipPath := filepath.Join(tmp, "src.zip")
+	writeZip(t, zipPath, map[string]string{
+		"go.mod":  "module broken\n\ngo 1.24\n",
+		"main.go": "package main\n\nfunc main() {\n\tx := 1\n}\n",
+	})
+	_, err := (&Builder{}).CompileWith(context.Background(), zipPath, filepath.Join(tmp, "src"), filepath.Join(tmp, "bin"), "broken", BuildSettings{}, Origin{})
+	var buildErr *BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("expected a build error, got %v", err)
+	}
+	if len(buildErr.Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %+v", buildErr)
+	}
+	d := buildErr.Diagnostics[0]
+	if d.File != "main.go" || d.Line != 4 || d.Column != 2 || d.Message != "declared and not used: x" {
+		t.Fatalf("unexpected diagnostic %+v", d)
+	}
+}
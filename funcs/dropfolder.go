@@ -0,0 +1,115 @@
+package funcs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// DropFolder invokes the function with each file written to Dir, the file
+	// is sent as the body of a POST to Path (relative to /{func_name}) and
+	// then moved to Processed (2xx responses) or Failed (anything else).
+	DropFolder struct {
+		ID  string `json:"id"`
+		Dir string `json:"dir"`
+		// Pattern is matched against the file name (eg.: *.csv), files
+		// starting with a dot are always ignored
+		Pattern string `json:"pattern,omitempty"`
+		Path    string `json:"path,omitempty"`
+		// Processed and Failed default to subdirectories of Dir
+		Processed string `json:"processed,omitempty"`
+		Failed    string `json:"failed,omitempty"`
+		// Poll is the interval between directory scans, files are picked
+		// once their size and modification time are stable for a full
+		// interval. On Linux, inotify picks closed and renamed files right away.
+		Poll Duration `json:"poll,omitempty"`
+		// Timeout cancels invocations that take longer than its value
+		Timeout Duration `json:"timeout,omitempty"`
+	}
+)
+
+const (
+	// DefaultDropFolderPoll is used when DropFolder.Poll is not set
+	DefaultDropFolderPoll = 5 * time.Second
+)
+
+// Dirs returns the directories where files are moved after being processed
+func (d DropFolder) Dirs() (processed, failed string) {
+	processed, failed = d.Processed, d.Failed
+	if processed == "" {
+		processed = filepath.Join(d.Dir, "processed")
+	}
+	if failed == "" {
+		failed = filepath.Join(d.Dir, "failed")
+	}
+	return processed, failed
+}
+
+// PollInterval returns the interval between directory scans
+func (d DropFolder) PollInterval() time.Duration {
+	if d.Poll <= 0 {
+		return DefaultDropFolderPoll
+	}
+	return d.Poll.D()
+}
+
+// DeliveryPath returns the path, relative to the function, files are posted to
+func (d DropFolder) DeliveryPath() string {
+	if d.Path == "" {
+		return "/"
+	}
+	return d.Path
+}
+
+// Accepts returns true if name should be sent to the function
+func (d DropFolder) Accepts(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	if d.Pattern == "" {
+		return true
+	}
+	ok, _ := filepath.Match(d.Pattern, name)
+	return ok
+}
+
+func validateDropFolders(c Config) error {
+	if c.IsWorker() && len(c.DropFolders) > 0 {
+		return errors.New("dropFolders: workers cannot be invoked")
+	}
+	ids := map[string]bool{}
+	for _, d := range c.DropFolders {
+		if !scheduleID.MatchString(d.ID) {
+			return fmt.Errorf("dropFolders: invalid id %q, use lowercase letters, digits, - and _", d.ID)
+		}
+		if ids[d.ID] {
+			return fmt.Errorf("dropFolders: duplicated id %q", d.ID)
+		}
+		ids[d.ID] = true
+		if !filepath.IsAbs(d.Dir) {
+			return fmt.Errorf("dropFolders: %v: dir must be an absolute path", d.ID)
+		}
+		processed, failed := d.Dirs()
+		for _, dir := range []string{processed, failed} {
+			if !filepath.IsAbs(dir) {
+				return fmt.Errorf("dropFolders: %v: %v must be an absolute path", d.ID, dir)
+			}
+			if filepath.Clean(dir) == filepath.Clean(d.Dir) {
+				return fmt.Errorf("dropFolders: %v: processed and failed must be different from dir", d.ID)
+			}
+		}
+		if _, err := filepath.Match(d.Pattern, ""); err != nil {
+			return fmt.Errorf("dropFolders: %v: invalid pattern %q", d.ID, d.Pattern)
+		}
+		if d.Path != "" && !strings.HasPrefix(d.Path, "/") {
+			return fmt.Errorf("dropFolders: %v: path must start with /", d.ID)
+		}
+		if d.Poll < 0 || d.Timeout < 0 {
+			return fmt.Errorf("dropFolders: %v: poll and timeout cannot be negative", d.ID)
+		}
+	}
+	return nil
+}
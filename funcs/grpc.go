@@ -0,0 +1,153 @@
+package funcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// Protocol spoken by the function process
+	Protocol string
+)
+
+const (
+	// ProtocolHTTP1 is the default, HTTP/1.1 without TLS
+	ProtocolHTTP1 = Protocol("")
+	// ProtocolH2C is HTTP/2 without TLS (prior knowledge)
+	ProtocolH2C = Protocol("h2c")
+	// ProtocolGRPC is a gRPC server without TLS, readiness is checked with
+	// the standard grpc.health.v1.Health service
+	ProtocolGRPC = Protocol("grpc")
+)
+
+const (
+	grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+	grpcStatusOK            = "0"
+	grpcStatusUnimplemented = "12"
+	// grpcServing is HealthCheckResponse.ServingStatus.SERVING
+	grpcServing = 1
+)
+
+func (p Protocol) h2c() bool {
+	return p == ProtocolH2C || p == ProtocolGRPC
+}
+
+// IsGRPC returns true if r is a gRPC request
+func IsGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// waitGRPCHealth polls the health service of the function until it reports
+// SERVING. Functions that do not implement the health service are considered
+// healthy as soon as they answer.
+func waitGRPCHealth(ctx context.Context, targetHost string, deadline time.Time, exited <-chan error) error {
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: time.Second}
+
+	var lastErr error
+	for {
+		lastErr = grpcHealthCheck(ctx, client, targetHost)
+		if lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("context canceled while waiting for grpc health: %w", ctx.Err())
+		case err := <-exited:
+			return fmt.Errorf("process exited before reporting healthy: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("grpc health check did not pass in time: %w", lastErr)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func grpcHealthCheck(ctx context.Context, client *http.Client, targetHost string) error {
+	// an empty HealthCheckRequest: uncompressed frame with zero length
+	frame := []byte{0, 0, 0, 0, 0}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+targetHost+grpcHealthPath, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// trailers-only responses carry the status in the headers
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	switch status {
+	case grpcStatusUnimplemented:
+		return nil
+	case grpcStatusOK:
+	default:
+		return fmt.Errorf("grpc health: status %q: %v", status, resp.Trailer.Get("Grpc-Message"))
+	}
+	serving, err := decodeServingStatus(body)
+	if err != nil {
+		return err
+	}
+	if serving != grpcServing {
+		return fmt.Errorf("grpc health: serving status %v", serving)
+	}
+	return nil
+}
+
+// decodeServingStatus extracts field 1 (status) from a framed HealthCheckResponse
+func decodeServingStatus(body []byte) (uint64, error) {
+	if len(body) < 5 {
+		return 0, errors.New("grpc health: short response")
+	}
+	if body[0] != 0 {
+		return 0, errors.New("grpc health: compressed responses are not supported")
+	}
+	size := binary.BigEndian.Uint32(body[1:5])
+	msg := body[5:]
+	if uint32(len(msg)) < size {
+		return 0, errors.New("grpc health: truncated response")
+	}
+	msg = msg[:size]
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, errors.New("grpc health: invalid message")
+		}
+		msg = msg[n:]
+		field, wireType := key>>3, key&7
+		if wireType != 0 {
+			return 0, fmt.Errorf("grpc health: unexpected wire type %v", wireType)
+		}
+		v, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, errors.New("grpc health: invalid varint")
+		}
+		msg = msg[n:]
+		if field == 1 {
+			return v, nil
+		}
+	}
+	// proto3 omits default values, status 0 is UNKNOWN
+	return 0, nil
+}
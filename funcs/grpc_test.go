@@ -0,0 +1,90 @@
+package funcs
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeGRPC implements the health service and an echo method using plain net/http
+func fakeGRPC(t *testing.T, serving byte) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("expected HTTP/2 request, got %v", r.Proto)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		switch r.URL.Path {
+		case grpcHealthPath:
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte{0, 0, 0, 0, 2, 0x08, serving})
+		case "/test.Echo/Echo":
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		default:
+			w.Header().Set("Grpc-Status", grpcStatusUnimplemented)
+			return
+		}
+		w.Header().Set("Grpc-Status", grpcStatusOK)
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	return ts
+}
+
+func TestGRPCHealth(t *testing.T) {
+	serving := fakeGRPC(t, grpcServing)
+	defer serving.Close()
+	notServing := fakeGRPC(t, 2)
+	defer notServing.Close()
+
+	ctx := context.Background()
+	u, _ := url.Parse(serving.URL)
+	if err := waitGRPCHealth(ctx, u.Host, time.Now().Add(time.Second), nil); err != nil {
+		t.Fatalf("serving backend should be healthy: %v", err)
+	}
+	u, _ = url.Parse(notServing.URL)
+	if err := waitGRPCHealth(ctx, u.Host, time.Now().Add(200*time.Millisecond), nil); err == nil {
+		t.Fatalf("not serving backend should not be healthy")
+	}
+}
+
+func TestServeHTTP_GRPCTrailers(t *testing.T) {
+	backend := fakeGRPC(t, grpcServing)
+	defer backend.Close()
+	f := &Func{binfile: filepath.Join(t.TempDir(), "grpcfunc.out"), cfg: Config{Protocol: ProtocolGRPC}}
+	u, _ := url.Parse(backend.URL)
+	f.ready(f.newBackend(u.Host))
+
+	ts := httptest.NewUnstartedServer(f)
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: transport}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/test.Echo/Echo", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2 response, got %v", resp.Proto)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != grpcStatusOK {
+		t.Fatalf("trailers were not preserved: %v", resp.Trailer)
+	}
+}
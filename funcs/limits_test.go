@@ -0,0 +1,120 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuilder_Limits(t *testing.T) {
+	tmp := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/limits\n\ngo 1.24\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	}
+	zipPath := filepath.Join(tmp, "src.zip")
+	writeZip(t, zipPath, files)
+	srcDir, binDir := filepath.Join(tmp, "src"), filepath.Join(tmp, "bin")
+	compile := func(ctx context.Context, b *Builder) error {
+		_, err := b.CompileWith(ctx, zipPath, srcDir, binDir, "limits", BuildSettings{}, Origin{})
+		return err
+	}
+
+	err := compile(context.Background(), &Builder{Timeout: time.Nanosecond})
+	if !errors.Is(err, ErrBuildLimit) || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = compile(ctx, &Builder{})
+	if errors.Is(err, ErrBuildLimit) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the build to be canceled, got %v", err)
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	err = compile(context.Background(), &Builder{Limits: Resources{MaxMemory: 700 << 20}, Parallel: 1})
+	if !errors.Is(err, ErrBuildLimit) || !strings.Contains(err.Error(), "out of memory") {
+		t.Fatalf("expected the memory limit to be hit, got %v", err)
+	}
+	if err := compile(context.Background(), &Builder{Parallel: 1}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResources_SetBeforeStart(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on linux")
+	}
+	r := Resources{MaxCPUTime: Duration(7 * time.Second), MaxOpenFiles: 64}
+	cmd, err := r.command(context.Background(), os.Environ(), "sh", "-c", "ulimit -t; ulimit -n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil || string(out) != "7\n64\n" {
+		t.Fatalf("the limits should be set when the command starts, got %q: %v", out, err)
+	}
+}
+
+func TestFunc_ResourcesSetBeforeStart(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on linux")
+	}
+	tmp := t.TempDir()
+	out := filepath.Join(tmp, "limits.txt")
+	bin := filepath.Join(tmp, "limited")
+	script := "#!/bin/sh\nulimit -n > \"$OUT.tmp\" && mv \"$OUT.tmp\" \"$OUT\"\nexec sleep 60\n"
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.UpdateConfig(Config{Kind: KindWorker, Env: map[string]string{"OUT": out}, Resources: Resources{MaxOpenFiles: 64}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if limits, err := os.ReadFile(out); err == nil {
+			if string(limits) != "64\n" {
+				t.Fatalf("the function should start with its limits, got %q", limits)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the function did not start")
+}
+
+func TestBuilder_Slots(t *testing.T) {
+	b := &Builder{Parallel: 1}
+	release, err := b.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("a second build should wait for the first one, got %v", err)
+	}
+	release()
+	if release, err = b.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -2,6 +2,7 @@ package funcs
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -43,10 +44,24 @@ func LoadFuncs(bindir string) ([]*Func, error) {
 		if info.Mode().Perm()&execMode == 0 {
 			continue
 		}
-		fun := &Func{
-			binfile: f,
+		fun, err := Open(f)
+		if err != nil {
+			// a broken config only keeps its own function from loading
+			slog.Error("Unable to load function", "binfile", f, "error", err)
+			continue
 		}
 		funcs = append(funcs, fun)
 	}
 	return funcs, nil
 }
+
+// Open returns the function stored at binfile, along with its config
+func Open(binfile string) (*Func, error) {
+	fun := &Func{
+		binfile: binfile,
+	}
+	if err := fun.loadConfig(); err != nil {
+		return nil, err
+	}
+	return fun, nil
+}
@@ -148,3 +148,21 @@ func TestLoadFuncs_ExecutableOut(t *testing.T) {
 		t.Errorf("expected binfile %q, got %q", f, funcs[0].binfile)
 	}
 }
+
+func TestLoadFuncs_SkipsBrokenConfig(t *testing.T) {
+	tmp := t.TempDir()
+	for _, name := range []string{"good", "broken"} {
+		os.MkdirAll(filepath.Join(tmp, name), 0755)
+		if err := os.WriteFile(filepath.Join(tmp, name, name+".out"), []byte("data"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(tmp, "broken", "broken.json"), []byte("{not json"), 0644)
+	funcs, err := LoadFuncs(tmp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(funcs) != 1 || funcs[0].Name() != "good" {
+		t.Fatalf("expected only the good function, got %v", funcs)
+	}
+}
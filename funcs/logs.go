@@ -0,0 +1,61 @@
+package funcs
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+)
+
+type (
+	// logWriter sends each line written by the function process to slog
+	logWriter struct {
+		name   string
+		stream string
+
+		lock sync.Mutex
+		buf  []byte
+	}
+)
+
+// maxLogLine is the size after which a line without a newline is logged anyway,
+// to keep a misbehaving process from growing the buffer forever
+const maxLogLine = 64 * 1024
+
+func newLogWriter(name, stream string) *logWriter {
+	return &logWriter{name: name, stream: stream}
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		idx := bytes.IndexByte(l.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		l.emit(l.buf[:idx])
+		l.buf = l.buf[idx+1:]
+	}
+	if len(l.buf) >= maxLogLine {
+		l.emit(l.buf)
+		l.buf = nil
+	}
+	// avoid holding on to the backing array of long outputs
+	l.buf = append([]byte(nil), l.buf...)
+	return len(p), nil
+}
+
+// flush logs the last line when the process exits without a trailing newline
+func (l *logWriter) flush() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.buf) > 0 {
+		l.emit(l.buf)
+		l.buf = nil
+	}
+}
+
+func (l *logWriter) emit(line []byte) {
+	slog.Info("Function output", "name", l.name, "stream", l.stream, "line", string(bytes.TrimSuffix(line, []byte("\r"))))
+}
@@ -0,0 +1,147 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type (
+	// Port is an additional TCP or UDP port served by the function.
+	//
+	// The process receives the local port to bind in PORT_<NAME>, while gofunc
+	// listens on Listen and forwards traffic to it. Changing the ports of a
+	// function restarts it.
+	Port struct {
+		Name     string `json:"name"`
+		Protocol string `json:"protocol"`
+		Listen   string `json:"listen"`
+	}
+
+	// portMap maps port names to the local address used by the process
+	portMap map[string]localPort
+
+	localPort struct {
+		protocol string
+		addr     string
+	}
+
+	// PortLease gives access to a port of a running function
+	PortLease struct {
+		// Addr is the local address of the function process
+		Addr string
+
+		ctx     context.Context
+		release func()
+	}
+)
+
+var portName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ErrUnknownPort is returned when the running process does not expose the requested port
+var ErrUnknownPort = errors.New("port not available in the running function")
+
+func (p Port) validate() error {
+	if !portName.MatchString(p.Name) {
+		return fmt.Errorf("ports: invalid name %q, use lowercase letters, digits and _", p.Name)
+	}
+	if p.Protocol != "tcp" && p.Protocol != "udp" {
+		return fmt.Errorf("ports: %v: protocol must be tcp or udp", p.Name)
+	}
+	if _, _, err := net.SplitHostPort(p.Listen); err != nil {
+		return fmt.Errorf("ports: %v: invalid listen address: %w", p.Name, err)
+	}
+	return nil
+}
+
+// EnvName returns the environment variable holding the local port
+func (p Port) EnvName() string {
+	return "PORT_" + strings.ToUpper(p.Name)
+}
+
+func allocatePorts(ports []Port) (portMap, []string, error) {
+	pm := portMap{}
+	var env []string
+	for _, p := range ports {
+		var addr string
+		switch p.Protocol {
+		case "udp":
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				return nil, nil, fmt.Errorf("allocate port %v: %w", p.Name, err)
+			}
+			addr = pc.LocalAddr().String()
+			pc.Close()
+		default:
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				return nil, nil, fmt.Errorf("allocate port %v: %w", p.Name, err)
+			}
+			addr = ln.Addr().String()
+			ln.Close()
+		}
+		_, port, _ := net.SplitHostPort(addr)
+		pm[p.Name] = localPort{protocol: p.Protocol, addr: addr}
+		env = append(env, fmt.Sprintf("%v=%v", p.EnvName(), port))
+	}
+	return pm, env, nil
+}
+
+func (pm portMap) tcp() []string {
+	var out []string
+	for _, p := range pm {
+		if p.protocol == "tcp" {
+			out = append(out, p.addr)
+		}
+	}
+	return out
+}
+
+func dialAny(targets []string, timeout time.Duration) error {
+	var lastErr error
+	for _, t := range targets {
+		conn, err := net.DialTimeout("tcp", t, timeout)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// AcquirePort waits for the function to be ready and returns the local address
+// of the given port. The lease must be released once the connection is closed,
+// so the function can drain connections before stopping.
+func (f *Func) AcquirePort(ctx context.Context, name string) (*PortLease, error) {
+	b, err := f.waitBackend(ctx, f.Config().ColdStart)
+	if err != nil {
+		return nil, err
+	}
+	lp, ok := b.ports[name]
+	if !ok {
+		return nil, ErrUnknownPort
+	}
+	if !b.active.add() {
+		return nil, errors.New("function is stopping")
+	}
+	return &PortLease{Addr: lp.addr, ctx: b.ctx, release: b.active.done}, nil
+}
+
+// Context is canceled when the connection must be terminated,
+// eg.: the function was stopped and the drain timeout expired
+func (l *PortLease) Context() context.Context {
+	return l.ctx
+}
+
+// Release notifies the function that the connection was closed
+func (l *PortLease) Release() {
+	if l.release != nil {
+		l.release()
+		l.release = nil
+	}
+}
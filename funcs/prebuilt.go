@@ -0,0 +1,137 @@
+package funcs
+
+import (
+	"context"
+	"debug/buildinfo"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"go/version"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type (
+	// BinaryInfo describes a prebuilt binary accepted by Install
+	BinaryInfo struct {
+		GoVersion string `json:"goVersion"`
+		GOOS      string `json:"goos"`
+		GOARCH    string `json:"goarch"`
+		Path      string `json:"path,omitempty"`
+	}
+)
+
+// MinGoVersion is the oldest Go release accepted for prebuilt binaries
+const MinGoVersion = "go1.21"
+
+// ErrInvalidBinary is wrapped by the errors of binaries that cannot run on this server
+var ErrInvalidBinary = errors.New("invalid binary")
+
+// elfMachines maps GOARCH to the ELF machine of its binaries
+var elfMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"loong64": elf.EM_LOONGARCH,
+	"mips64":  elf.EM_MIPS,
+	"ppc64le": elf.EM_PPC64,
+	"riscv64": elf.EM_RISCV,
+	"s390x":   elf.EM_S390,
+}
+
+// InspectBinary checks that file is a static Go binary for the platform of
+// this server, built with a supported Go release
+func InspectBinary(file string) (BinaryInfo, error) {
+	if runtime.GOOS != "linux" {
+		return BinaryInfo{}, fmt.Errorf("%w: prebuilt binaries are only supported on linux servers", ErrInvalidBinary)
+	}
+	ef, err := elf.Open(file)
+	if err != nil {
+		return BinaryInfo{}, fmt.Errorf("%w: not an ELF executable: %v", ErrInvalidBinary, err)
+	}
+	defer ef.Close()
+	if ef.Type != elf.ET_EXEC && ef.Type != elf.ET_DYN {
+		return BinaryInfo{}, fmt.Errorf("%w: ELF type %v is not an executable", ErrInvalidBinary, ef.Type)
+	}
+	if m, ok := elfMachines[runtime.GOARCH]; !ok || ef.Machine != m {
+		return BinaryInfo{}, fmt.Errorf("%w: built for %v, the server runs on %v", ErrInvalidBinary, ef.Machine, runtime.GOARCH)
+	}
+	for _, p := range ef.Progs {
+		if p.Type == elf.PT_INTERP {
+			return BinaryInfo{}, fmt.Errorf("%w: dynamically linked, build with CGO_ENABLED=0", ErrInvalidBinary)
+		}
+	}
+
+	bi, err := buildinfo.ReadFile(file)
+	if err != nil {
+		return BinaryInfo{}, fmt.Errorf("%w: not a Go binary: %v", ErrInvalidBinary, err)
+	}
+	info := binaryInfo(bi)
+	if info.GOOS != "linux" || info.GOARCH != runtime.GOARCH {
+		return info, fmt.Errorf("%w: built for %v/%v, the server runs on linux/%v", ErrInvalidBinary, info.GOOS, info.GOARCH, runtime.GOARCH)
+	}
+	// experiments are appended to the version (eg.: go1.24.0 X:boringcrypto)
+	goVersion, _, _ := strings.Cut(bi.GoVersion, " ")
+	if !version.IsValid(goVersion) || version.Compare(goVersion, MinGoVersion) < 0 {
+		return info, fmt.Errorf("%w: built with %v, the oldest release accepted is %v", ErrInvalidBinary, bi.GoVersion, MinGoVersion)
+	}
+	return info, nil
+}
+
+func binaryInfo(bi *buildinfo.BuildInfo) BinaryInfo {
+	info := BinaryInfo{GoVersion: bi.GoVersion, Path: bi.Path}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "GOOS":
+			info.GOOS = s.Value
+		case "GOARCH":
+			info.GOARCH = s.Value
+		}
+	}
+	return info
+}
+
+// Install deploys a prebuilt binary to bindir, after checking it with
+// InspectBinary. The function keeps its config, without build settings,
+// and origin is recorded in its provenance. It waits for the builds of
+// funcname, so the binary and its config are not mixed with theirs.
+func (b *Builder) Install(ctx context.Context, file string, bindir string, funcname string, origin Origin) (*Func, BinaryInfo, error) {
+	if err := origin.Validate(); err != nil {
+		return nil, BinaryInfo{}, err
+	}
+	unlock, err := b.lock(ctx, funcname)
+	if err != nil {
+		return nil, BinaryInfo{}, fmt.Errorf("install canceled: %w", err)
+	}
+	defer unlock()
+	info, err := InspectBinary(file)
+	if err != nil {
+		return nil, info, err
+	}
+	if err := os.MkdirAll(bindir, 0755); err != nil {
+		return nil, info, fmt.Errorf("create bindir: %w", err)
+	}
+	outPath := filepath.Join(bindir, funcname+".out")
+	if err := os.Chmod(file, 0755); err != nil {
+		return nil, info, err
+	}
+	if err := replaceFile(file, outPath); err != nil {
+		return nil, info, fmt.Errorf("install binary: %w", err)
+	}
+	fn := &Func{binfile: outPath}
+	if err := fn.loadConfig(); err != nil {
+		return nil, info, err
+	}
+	cfg := fn.Config()
+	cfg.Build = BuildSettings{}
+	if err := fn.UpdateConfig(cfg); err != nil {
+		return nil, info, err
+	}
+	if err := fn.recordProvenance(Provenance{Git: origin.Git, Prebuilt: true, Deployer: origin.Deployer}); err != nil {
+		return nil, info, err
+	}
+	return fn, info, nil
+}
@@ -0,0 +1,70 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstallPrebuilt(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("prebuilt binaries are only supported on linux")
+	}
+	tmp := t.TempDir()
+	os.WriteFile(filepath.Join(tmp, "go.mod"), []byte("module example.com/prebuilt\n\ngo 1.24\n"), 0644)
+	os.WriteFile(filepath.Join(tmp, "main.go"), []byte("package main\n\nfunc main() { print(\"prebuilt\") }\n"), 0644)
+	build := func(goarch string) string {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "app")
+		cmd := exec.Command("go", "build", "-o", out, ".")
+		cmd.Dir = tmp
+		cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", "GOARCH="+goarch)
+		if buf, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("build: %v: %s", err, buf)
+		}
+		return out
+	}
+
+	binDir := filepath.Join(tmp, "bin")
+	b := &Builder{}
+	// installs wait for the builds of the same function
+	unlock, _ := b.lock(context.Background(), "prebuilt")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := b.Install(ctx, build(runtime.GOARCH), binDir, "prebuilt", Origin{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("install should wait for the build in progress, got %v", err)
+	}
+	unlock()
+	fn, info, err := b.Install(context.Background(), build(runtime.GOARCH), binDir, "prebuilt", Origin{Git: GitInfo{Commit: "abcdef12"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.GOOS != "linux" || info.GOARCH != runtime.GOARCH || info.Path != "example.com/prebuilt" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if out, err := exec.Command(fn.Bin()).CombinedOutput(); err != nil || string(out) != "prebuilt" {
+		t.Fatalf("unexpected output %q: %v", out, err)
+	}
+	if p, err := fn.Provenance(); err != nil || !p.Prebuilt || p.SourceDigest != "" || p.Git.Commit != "abcdef12" || p.Path != info.Path {
+		t.Fatalf("unexpected provenance %+v: %v", p, err)
+	}
+
+	other := "arm64"
+	if runtime.GOARCH == "arm64" {
+		other = "amd64"
+	}
+	if _, err := InspectBinary(build(other)); !errors.Is(err, ErrInvalidBinary) || !strings.Contains(err.Error(), "the server runs on") {
+		t.Fatalf("binaries for other architectures should be rejected, got %v", err)
+	}
+	script := filepath.Join(tmp, "script.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\necho hi\n"), 0755)
+	if _, err := InspectBinary(script); !errors.Is(err, ErrInvalidBinary) {
+		t.Fatalf("scripts should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package funcs
+
+import (
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type (
+	// Provenance records how the binary of a function was built and who
+	// deployed it, it is stored next to the binary on every deploy
+	Provenance struct {
+		// SourceDigest is the sha256 of the uploaded source tree (without
+		// the manifest), it is empty for prebuilt binaries
+		SourceDigest string  `json:"sourceDigest,omitempty"`
+		BinaryDigest string  `json:"binaryDigest"`
+		Git          GitInfo `json:"git"`
+		// BinaryInfo, Main and Modules are read from the build info
+		// embedded in the binary
+		BinaryInfo
+		Main     Module   `json:"main"`
+		Modules  []Module `json:"modules,omitempty"`
+		Prebuilt bool     `json:"prebuilt,omitempty"`
+		// Cached is true when the binary was reused from the build cache
+		Cached        bool      `json:"cached,omitempty"`
+		BuildDuration Duration  `json:"buildDuration,omitempty"`
+		Deployer      Deployer  `json:"deployer"`
+		DeployedAt    time.Time `json:"deployedAt"`
+	}
+
+	// Origin describes where an upload comes from, it is recorded in the
+	// provenance of the build
+	Origin struct {
+		Git      GitInfo
+		Deployer Deployer
+	}
+
+	// GitInfo is the state of the repository an upload was made from, as
+	// reported by the uploader
+	GitInfo struct {
+		Commit string `json:"commit,omitempty"`
+		Branch string `json:"branch,omitempty"`
+		Dirty  bool   `json:"dirty,omitempty"`
+	}
+
+	// Deployer identifies who deployed a function
+	Deployer struct {
+		// Certificate is the subject of the verified client certificate
+		Certificate string `json:"certificate,omitempty"`
+		// User is reported by the client, it is not verified
+		User string `json:"user,omitempty"`
+		Addr string `json:"addr,omitempty"`
+	}
+
+	// Module is a module linked into a binary
+	Module struct {
+		Path    string  `json:"path"`
+		Version string  `json:"version,omitempty"`
+		Sum     string  `json:"sum,omitempty"`
+		Replace *Module `json:"replace,omitempty"`
+	}
+)
+
+// Variables of the main package set with -ldflags -X on every build,
+// functions declare the ones they want to read as string variables
+// (eg.: var gofuncCommit string)
+const (
+	stampSourceDigest = "main.gofuncSourceDigest"
+	stampCommit       = "main.gofuncCommit"
+	stampBranch       = "main.gofuncBranch"
+	stampDirty        = "main.gofuncDirty"
+)
+
+var validCommit = regexp.MustCompile(`^[0-9a-f]{4,64}$`)
+
+// Validate returns an error if o cannot be recorded or stamped into a binary
+func (o Origin) Validate() error {
+	if o.Git.Commit != "" && !validCommit.MatchString(o.Git.Commit) {
+		return fmt.Errorf("git: invalid commit %q", o.Git.Commit)
+	}
+	// values are given to -ldflags unquoted
+	if len(o.Git.Branch) > 255 || strings.IndexFunc(o.Git.Branch, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == '\'' || r == '"' || r == '`'
+	}) >= 0 {
+		return fmt.Errorf("git: invalid branch %q", o.Git.Branch)
+	}
+	if len(o.Deployer.User) > 255 {
+		return fmt.Errorf("deployer: user is too long")
+	}
+	return nil
+}
+
+// StampFlags returns the -ldflags arguments that set the provenance
+// variables, sourceDigest is left out when empty
+func StampFlags(sourceDigest string, git GitInfo) string {
+	var flags []string
+	for _, v := range [][2]string{
+		{stampSourceDigest, sourceDigest},
+		{stampCommit, git.Commit},
+		{stampBranch, git.Branch},
+	} {
+		if v[1] != "" {
+			flags = append(flags, "-X", v[0]+"="+v[1])
+		}
+	}
+	if git.Dirty {
+		flags = append(flags, "-X", stampDirty+"=true")
+	}
+	return strings.Join(flags, " ")
+}
+
+// ProvenanceFile returns the path where the provenance of the binary is stored
+func (f *Func) ProvenanceFile() string {
+	return filepath.Join(filepath.Dir(f.binfile), f.Name()+".info.json")
+}
+
+// Provenance returns how the current binary was built, the error wraps
+// os.ErrNotExist when nothing was recorded (eg.: deployed by an older version)
+func (f *Func) Provenance() (Provenance, error) {
+	var p Provenance
+	buf, err := os.ReadFile(f.ProvenanceFile())
+	if err != nil {
+		return p, fmt.Errorf("read provenance: %w", err)
+	}
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return p, fmt.Errorf("decode provenance: %w", err)
+	}
+	return p, nil
+}
+
+// recordProvenance completes p with the build info of the binary and
+// stores it next to it
+func (f *Func) recordProvenance(p Provenance) error {
+	digest, err := fileDigest(f.binfile)
+	if err != nil {
+		return fmt.Errorf("digest binary: %w", err)
+	}
+	p.BinaryDigest = digest
+	bi, err := buildinfo.ReadFile(f.binfile)
+	if err != nil {
+		return fmt.Errorf("read build info: %w", err)
+	}
+	p.BinaryInfo = binaryInfo(bi)
+	p.Main = module(&bi.Main)
+	p.Modules = nil
+	for _, d := range bi.Deps {
+		p.Modules = append(p.Modules, module(d))
+	}
+	p.DeployedAt = time.Now().UTC()
+
+	buf, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode provenance: %w", err)
+	}
+	file := f.ProvenanceFile()
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return fmt.Errorf("write provenance: %w", err)
+	}
+	return os.Rename(tmp, file)
+}
+
+func module(m *debug.Module) Module {
+	mod := Module{Path: m.Path, Version: m.Version, Sum: m.Sum}
+	if m.Replace != nil {
+		r := module(m.Replace)
+		mod.Replace = &r
+	}
+	return mod
+}
+
+// sourceDigest hashes the files under srcdir. Like the build digest it
+// leaves the manifest out, so changing the config of a function does not
+// change its binary.
+func sourceDigest(srcdir string) (string, error) {
+	h := sha256.New()
+	if err := hashTree(h, srcdir, ManifestFile); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileDigest(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
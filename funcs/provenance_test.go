@@ -0,0 +1,77 @@
+package funcs
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCompile_Provenance(t *testing.T) {
+	tmp := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/provenance\n\ngo 1.24\n",
+		"main.go": "package main\n\nvar gofuncSourceDigest, gofuncCommit, gofuncBranch, gofuncDirty, version string\n\nfunc main() { print(gofuncSourceDigest, \" \", gofuncCommit, \" \", gofuncBranch, \" \", gofuncDirty, \" \", version) }\n",
+	}
+	zipPath := filepath.Join(tmp, "src.zip")
+	writeZip(t, zipPath, files)
+	srcDir, binDir := filepath.Join(tmp, "src"), filepath.Join(tmp, "bin")
+	b := &Builder{CacheDir: filepath.Join(tmp, "cache")}
+	origin := Origin{
+		Git:      GitInfo{Commit: "0123abcd", Branch: "feature/info", Dirty: true},
+		Deployer: Deployer{User: "dev@laptop", Addr: "127.0.0.1:1234"},
+	}
+
+	compile := func() Provenance {
+		t.Helper()
+		fn, err := b.CompileWith(context.Background(), zipPath, srcDir, binDir, "provenance", BuildSettings{LDFlags: "-X main.version=v1"}, origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := fn.Provenance()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(fn.Bin()).CombinedOutput()
+		if err != nil {
+			t.Fatalf("run: %v: %s", err, out)
+		}
+		if expected := p.SourceDigest + " " + origin.Git.Commit + " feature/info true v1"; string(out) != expected {
+			t.Fatalf("unexpected stamp %q, expected %q", out, expected)
+		}
+		if cfg := fn.Config().Build; cfg.LDFlags != "-X main.version=v1" {
+			t.Fatalf("the stamp should not be part of the build settings: %+v", cfg)
+		}
+		return p
+	}
+	p := compile()
+	if p.SourceDigest == "" || p.BinaryDigest == "" || p.Cached || p.BuildDuration <= 0 {
+		t.Fatalf("unexpected provenance %+v", p)
+	}
+	if p.GoVersion != runtime.Version() || p.Main.Path != "example.com/provenance" || p.Git != origin.Git || p.Deployer != origin.Deployer {
+		t.Fatalf("unexpected provenance %+v", p)
+	}
+	if again := compile(); !again.Cached || again.BinaryDigest != p.BinaryDigest || again.SourceDigest != p.SourceDigest {
+		t.Fatalf("the cached binary should be reused, got %+v", again)
+	}
+
+	// another commit of the same sources is linked again, with its own stamp
+	origin.Git.Commit = "4567cdef"
+	if again := compile(); again.Cached {
+		t.Fatalf("binaries stamped with another commit should not be reused, got %+v", again)
+	}
+	if bins, _ := filepath.Glob(filepath.Join(tmp, "cache", "*[^p]")); len(bins) != 1 {
+		t.Fatalf("the stamp should not be part of the cache key, got %v", bins)
+	}
+	if again := compile(); !again.Cached {
+		t.Fatalf("the relinked binary should be cached, got %+v", again)
+	}
+
+	origin.Git.Branch = "it's"
+	_, err := b.CompileWith(context.Background(), zipPath, srcDir, binDir, "provenance", BuildSettings{}, origin)
+	if err == nil || !strings.Contains(err.Error(), "invalid branch") {
+		t.Fatalf("branches with quotes should be rejected, got %v", err)
+	}
+}
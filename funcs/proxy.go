@@ -0,0 +1,200 @@
+package funcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+type (
+	// HTTPSettings control how requests are proxied to the function.
+	// Zero values disable the respective limit.
+	HTTPSettings struct {
+		// RequestTimeout is the maximum time to wait for the function response
+		RequestTimeout Duration `json:"requestTimeout,omitempty"`
+		// MaxRequestBody is the maximum size of the request body, in bytes
+		MaxRequestBody int64 `json:"maxRequestBody,omitempty"`
+		// MaxResponseHeader is the maximum size of the response headers, in bytes.
+		// Changes take effect the next time the function starts.
+		MaxResponseHeader int64 `json:"maxResponseHeader,omitempty"`
+	}
+
+	state int32
+
+	// gatewayError is the body sent to clients when the function cannot answer
+	gatewayError struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+		Func  string `json:"func"`
+	}
+)
+
+const (
+	// stateStarting is the zero value, since new functions are expected
+	// to start soon after being registered
+	stateStarting = state(iota)
+	stateReady
+	stateCrashed
+	stateStopped
+)
+
+// setState updates the state and wakes up requests waiting for the function
+func (f *Func) setState(s state) {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+	f.state.Store(int32(s))
+	switch s {
+	case stateCrashed:
+		f.crashes.Add(1)
+	case stateReady:
+		f.crashes.Store(0)
+	}
+	if f.stateChanged != nil {
+		close(f.stateChanged)
+		f.stateChanged = nil
+	}
+}
+
+func (f *Func) getState() state { return state(f.state.Load()) }
+
+// stateChange returns a channel that is closed on the next call to setState
+func (f *Func) stateChange() <-chan struct{} {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+	if f.stateChanged == nil {
+		f.stateChanged = make(chan struct{})
+	}
+	return f.stateChanged
+}
+
+// stop removes the backend, new requests will receive an error based on s
+func (f *Func) stop(s state) {
+	f.proxy.Store(nil)
+	f.setState(s)
+}
+
+// ready publishes the backend of the running process
+func (f *Func) ready(b *backend) {
+	f.proxy.Store(b)
+	f.setState(stateReady)
+}
+
+func (f *Func) newBackend(targetHost string) *backend {
+	cfg := f.Config()
+	b := &backend{
+		http:   f.newProxy(targetHost, cfg, 0),
+		stream: f.newProxy(targetHost, cfg, cfg.Streaming.IdleTimeout.D()),
+	}
+	b.http.FlushInterval = cfg.Streaming.FlushInterval.D()
+	b.stream.FlushInterval = -1
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b
+}
+
+// newProxy returns a proxy to targetHost, if idleTimeout is positive connections
+// to the function are closed after being idle for that long
+func (f *Func) newProxy(targetHost string, cfg Config, idleTimeout time.Duration) *httputil.ReverseProxy {
+	target := &url.URL{Scheme: "http", Host: targetHost}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	// Ensure the director preserves the original request path and query
+	origDirector := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		origDirector(r)
+		// keep Host header of target
+		r.Host = target.Host
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxResponseHeaderBytes = cfg.HTTP.MaxResponseHeader
+	if cfg.Protocol.h2c() {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if idleTimeout > 0 {
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &idleConn{Conn: conn, timeout: idleTimeout}, nil
+		}
+		// long-lived connections are not reused
+		transport.DisableKeepAlives = true
+	}
+	proxy.Transport = transport
+	proxy.ErrorHandler = f.proxyError
+	return proxy
+}
+
+func (f *Func) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := f.Config()
+	if cfg.IsWorker() {
+		f.writeError(w, http.StatusNotFound, "worker", "function is a worker and cannot be invoked")
+		return
+	}
+	b := f.proxy.Load()
+	if b == nil {
+		b = f.waitProxy(w, r, cfg.ColdStart)
+		if b == nil {
+			return
+		}
+	}
+	if !b.active.add() {
+		// the backend started draining after we loaded it
+		f.proxyError(w, r, nil)
+		return
+	}
+	defer b.active.done()
+
+	settings := cfg.HTTP
+	if settings.MaxRequestBody > 0 {
+		if r.ContentLength > settings.MaxRequestBody {
+			f.writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, settings.MaxRequestBody)
+	}
+	if isStream(r) {
+		f.serveStream(w, r, b, cfg.Streaming)
+		return
+	}
+	// gRPC clients send their own deadlines
+	if settings.RequestTimeout > 0 && !IsGRPC(r) {
+		ctx, cancel := context.WithTimeout(r.Context(), settings.RequestTimeout.D())
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	b.http.ServeHTTP(w, r)
+}
+
+func (f *Func) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytes):
+		f.writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		f.writeError(w, http.StatusGatewayTimeout, "timeout", "function timed out")
+	case f.getState() == stateStarting:
+		f.writeError(w, http.StatusServiceUnavailable, "starting", "function starting")
+	case f.getState() == stateCrashed:
+		f.writeError(w, http.StatusBadGateway, "crashed", "function crashed")
+	case f.getState() == stateStopped:
+		f.writeError(w, http.StatusServiceUnavailable, "stopped", "function not running")
+	default:
+		f.writeError(w, http.StatusBadGateway, "bad_gateway", "function returned an invalid response")
+	}
+}
+
+func (f *Func) writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(gatewayError{Error: msg, Code: code, Func: f.Name()})
+}
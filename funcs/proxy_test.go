@@ -0,0 +1,146 @@
+package funcs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFunc returns a Func proxying to backend without starting a process
+func newTestFunc(t *testing.T, backend *httptest.Server, cfg Config) *Func {
+	t.Helper()
+	f := &Func{binfile: filepath.Join(t.TempDir(), "testfunc.out"), cfg: cfg}
+	if backend != nil {
+		u, _ := url.Parse(backend.URL)
+		f.ready(f.newBackend(u.Host))
+	}
+	return f
+}
+
+func decodeGatewayError(t *testing.T, rec *httptest.ResponseRecorder) gatewayError {
+	t.Helper()
+	var ge gatewayError
+	if err := json.Unmarshal(rec.Body.Bytes(), &ge); err != nil {
+		t.Fatalf("invalid json error %q: %v", rec.Body.String(), err)
+	}
+	return ge
+}
+
+func TestServeHTTP_Timeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backend.Close()
+	f := newTestFunc(t, backend, Config{HTTP: HTTPSettings{RequestTimeout: Duration(100 * time.Millisecond)}})
+
+	rec := httptest.NewRecorder()
+	f.ServeHTTP(rec, httptest.NewRequest("GET", "/testfunc/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rec.Code)
+	}
+	if ge := decodeGatewayError(t, rec); ge.Code != "timeout" || ge.Func != "testfunc" {
+		t.Fatalf("unexpected error body: %#v", ge)
+	}
+}
+
+func TestServeHTTP_MaxRequestBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer backend.Close()
+	f := newTestFunc(t, backend, Config{HTTP: HTTPSettings{MaxRequestBody: 4}})
+
+	rec := httptest.NewRecorder()
+	f.ServeHTTP(rec, httptest.NewRequest("POST", "/testfunc/", strings.NewReader("too large")))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	f.ServeHTTP(rec, httptest.NewRequest("POST", "/testfunc/", strings.NewReader("ok")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServeHTTP_NotRunning(t *testing.T) {
+	f := newTestFunc(t, nil, Config{ColdStart: ColdStart{Timeout: Duration(10 * time.Millisecond)}})
+	for st, expected := range map[state]int{
+		stateStarting: http.StatusServiceUnavailable,
+		stateCrashed:  http.StatusServiceUnavailable,
+		stateStopped:  http.StatusServiceUnavailable,
+	} {
+		f.setState(st)
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, httptest.NewRequest("GET", "/testfunc/", nil))
+		if rec.Code != expected {
+			t.Errorf("state %v: expected %d got %d", st, expected, rec.Code)
+		}
+		decodeGatewayError(t, rec)
+	}
+}
+
+func TestServeHTTP_CrashLoop(t *testing.T) {
+	f := newTestFunc(t, nil, Config{ColdStart: ColdStart{Timeout: Duration(time.Minute)}})
+	for range maxCrashWait {
+		f.setState(stateStarting)
+		f.setState(stateCrashed)
+	}
+	for _, st := range []state{stateCrashed, stateStarting} {
+		f.setState(st)
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, httptest.NewRequest("GET", "/testfunc/", nil))
+		if rec.Code != http.StatusBadGateway || time.Since(start) > time.Second {
+			t.Fatalf("state %v: crash looping functions should fail right away, got %d after %v", st, rec.Code, time.Since(start))
+		}
+		if ge := decodeGatewayError(t, rec); ge.Code != "crashed" {
+			t.Fatalf("unexpected error: %+v", ge)
+		}
+	}
+}
+
+func TestServeHTTP_WaitsForColdStart(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ready"))
+	}))
+	defer backend.Close()
+	f := newTestFunc(t, nil, Config{ColdStart: ColdStart{Timeout: Duration(5 * time.Second)}})
+
+	served := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, httptest.NewRequest("GET", "/testfunc/", nil))
+		served <- rec
+	}()
+
+	for f.pending.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	u, _ := url.Parse(backend.URL)
+	f.ready(f.newBackend(u.Host))
+
+	rec := <-served
+	if rec.Code != http.StatusOK || rec.Body.String() != "ready" {
+		t.Fatalf("parked request should be served once ready, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServeHTTP_ColdStartQueueFull(t *testing.T) {
+	f := newTestFunc(t, nil, Config{ColdStart: ColdStart{Timeout: Duration(time.Second), MaxPending: 1}})
+	f.pending.Store(1)
+	rec := httptest.NewRecorder()
+	f.ServeHTTP(rec, httptest.NewRequest("GET", "/testfunc/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when the queue is full, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,30 @@
+package funcs
+
+import "errors"
+
+type (
+	// Resources are OS limits applied to the function process right after it starts.
+	// Zero values disable the respective limit.
+	Resources struct {
+		// MaxMemory is the size of the address space, in bytes
+		MaxMemory int64 `json:"maxMemory,omitempty"`
+		// MaxCPUTime is the cpu time after which the process is killed
+		MaxCPUTime Duration `json:"maxCPUTime,omitempty"`
+		// MaxOpenFiles is the number of file descriptors the process may open
+		MaxOpenFiles int `json:"maxOpenFiles,omitempty"`
+		// MaxProcesses limits the number of threads and processes, note the
+		// OS counts them for the whole user running gofunc
+		MaxProcesses int `json:"maxProcesses,omitempty"`
+	}
+)
+
+func (r Resources) validate() error {
+	if r.MaxMemory < 0 || r.MaxCPUTime < 0 || r.MaxOpenFiles < 0 || r.MaxProcesses < 0 {
+		return errors.New("resources: limits cannot be negative")
+	}
+	return nil
+}
+
+func (r Resources) isZero() bool {
+	return r == Resources{}
+}
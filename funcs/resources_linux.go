@@ -0,0 +1,90 @@
+package funcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// limitsEnv holds the limits of a command started by Resources.command,
+// the executable of this process applies them before starting the command
+const limitsEnv = "GOFUNC_RESOURCE_LIMITS"
+
+func init() {
+	if limits, ok := os.LookupEnv(limitsEnv); ok && len(os.Args) > 1 {
+		execLimited(limits, os.Args[1:])
+	}
+}
+
+// apply sets the limits of the process identified by pid, 0 is the calling process
+func (r Resources) apply(pid int) error {
+	limits := []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"maxMemory", unix.RLIMIT_AS, uint64(r.MaxMemory)},
+		// RLIMIT_CPU is set in seconds, rounded up
+		{"maxCPUTime", unix.RLIMIT_CPU, uint64(math.Ceil(r.MaxCPUTime.D().Seconds()))},
+		{"maxOpenFiles", unix.RLIMIT_NOFILE, uint64(r.MaxOpenFiles)},
+		{"maxProcesses", unix.RLIMIT_NPROC, uint64(r.MaxProcesses)},
+	}
+	for _, l := range limits {
+		if l.value == 0 {
+			continue
+		}
+		rlim := unix.Rlimit{Cur: l.value, Max: l.value}
+		if err := unix.Prlimit(pid, l.resource, &rlim, nil); err != nil {
+			return fmt.Errorf("resources: set %v: %w", l.name, err)
+		}
+	}
+	return nil
+}
+
+// command returns a command running name under the limits of r. The
+// limits are set before name starts, by running it through the executable
+// of this process, so every process it starts inherits them.
+func (r Resources) command(ctx context.Context, env []string, name string, args ...string) (*exec.Cmd, error) {
+	if r.isZero() {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Env = env
+		return cmd, nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("resources: %w", err)
+	}
+	limits, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("resources: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, self, append([]string{name}, args...)...)
+	cmd.Env = append(env[:len(env):len(env)], limitsEnv+"="+string(limits))
+	return cmd, nil
+}
+
+// execLimited replaces this process with args, after setting limits
+func execLimited(limits string, args []string) {
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "gofunc: unable to start %v: %v\n", args[0], err)
+		os.Exit(126)
+	}
+	var r Resources
+	if err := json.Unmarshal([]byte(limits), &r); err != nil {
+		fail(err)
+	}
+	os.Unsetenv(limitsEnv)
+	if err := r.apply(0); err != nil {
+		fail(err)
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		fail(err)
+	}
+	fail(unix.Exec(path, args, os.Environ()))
+}
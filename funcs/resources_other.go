@@ -0,0 +1,21 @@
+//go:build !linux
+
+package funcs
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+var errResourcesUnsupported = errors.New("resource limits are not supported on this platform")
+
+// command fails if any limit is set, since they cannot be enforced
+func (r Resources) command(ctx context.Context, env []string, name string, args ...string) (*exec.Cmd, error) {
+	if !r.isZero() {
+		return nil, errResourcesUnsupported
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = env
+	return cmd, nil
+}
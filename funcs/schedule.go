@@ -0,0 +1,127 @@
+package funcs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andrebq/gofunc/pkg/cron"
+)
+
+type (
+	// Schedule issues a request to the function at every tick of a cron expression
+	Schedule struct {
+		ID string `json:"id"`
+		// Cron is a 5 field cron expression (or a macro like @hourly)
+		Cron string `json:"cron"`
+		// TimeZone used to interpret Cron, defaults to UTC
+		TimeZone string `json:"timeZone,omitempty"`
+
+		// Method, Path, Headers and Body describe the request sent to the
+		// function, it defaults to POST /. Path is relative to /{func_name}.
+		Method  string            `json:"method,omitempty"`
+		Path    string            `json:"path,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    string            `json:"body,omitempty"`
+
+		// Jitter delays each tick by a random duration up to its value
+		Jitter Duration `json:"jitter,omitempty"`
+		// Overlap decides what happens when a tick arrives while the
+		// previous run is still going
+		Overlap Overlap `json:"overlap,omitempty"`
+		// Timeout cancels runs that take longer than its value
+		Timeout Duration `json:"timeout,omitempty"`
+		// Paused schedules keep their history but do not fire
+		Paused bool `json:"paused,omitempty"`
+	}
+
+	// Overlap is the policy applied to ticks that happen while a run is in progress
+	Overlap string
+)
+
+const (
+	// OverlapSkip drops the tick, it is the default
+	OverlapSkip = Overlap("skip")
+	// OverlapQueue runs the tick once the previous run finishes
+	OverlapQueue = Overlap("queue")
+	// OverlapAllow runs the tick concurrently with the previous run
+	OverlapAllow = Overlap("allow")
+)
+
+var scheduleID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Parse returns the parsed cron expression and the location used to evaluate it
+func (s Schedule) Parse() (*cron.Schedule, *time.Location, error) {
+	c, err := cron.Parse(s.Cron)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc := time.UTC
+	if s.TimeZone != "" {
+		if loc, err = time.LoadLocation(s.TimeZone); err != nil {
+			return nil, nil, fmt.Errorf("timeZone: %w", err)
+		}
+	}
+	return c, loc, nil
+}
+
+// Request returns the request sent to the function at each run
+func (s Schedule) Request() (method, path string) {
+	method, path = s.Method, s.Path
+	if method == "" {
+		method = http.MethodPost
+	}
+	if path == "" {
+		path = "/"
+	}
+	return method, path
+}
+
+// OverlapPolicy returns the overlap policy, applying the default
+func (s Schedule) OverlapPolicy() Overlap {
+	if s.Overlap == "" {
+		return OverlapSkip
+	}
+	return s.Overlap
+}
+
+func (s Schedule) validate() error {
+	if !scheduleID.MatchString(s.ID) {
+		return fmt.Errorf("schedules: invalid id %q, use lowercase letters, digits, - and _", s.ID)
+	}
+	if _, _, err := s.Parse(); err != nil {
+		return fmt.Errorf("schedules: %v: %w", s.ID, err)
+	}
+	switch s.Overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapAllow:
+	default:
+		return fmt.Errorf("schedules: %v: unknown overlap policy %q", s.ID, s.Overlap)
+	}
+	if s.Path != "" && !strings.HasPrefix(s.Path, "/") {
+		return fmt.Errorf("schedules: %v: path must start with /", s.ID)
+	}
+	if s.Jitter < 0 || s.Timeout < 0 {
+		return fmt.Errorf("schedules: %v: jitter and timeout cannot be negative", s.ID)
+	}
+	return nil
+}
+
+func validateSchedules(c Config) error {
+	if c.IsWorker() && len(c.Schedules) > 0 {
+		return errors.New("schedules: workers cannot be invoked")
+	}
+	ids := map[string]bool{}
+	for _, s := range c.Schedules {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		if ids[s.ID] {
+			return fmt.Errorf("schedules: duplicated id %q", s.ID)
+		}
+		ids[s.ID] = true
+	}
+	return nil
+}
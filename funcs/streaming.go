@@ -0,0 +1,169 @@
+package funcs
+
+import (
+	"context"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Streaming controls long-lived requests: WebSockets (and other upgrades)
+	// and Server-Sent Events. Those are not subject to HTTPSettings.RequestTimeout.
+	Streaming struct {
+		// FlushInterval is used for regular responses, a negative value flushes
+		// after every write. Event streams and responses without a known
+		// length are always flushed immediately.
+		FlushInterval Duration `json:"flushInterval,omitempty"`
+		// IdleTimeout closes long-lived connections without traffic.
+		// Changes take effect the next time the function starts.
+		IdleTimeout Duration `json:"idleTimeout,omitempty"`
+		// MaxConnections is the number of concurrent long-lived connections
+		MaxConnections int `json:"maxConnections,omitempty"`
+		// DrainTimeout is how long in-flight requests have to finish when the
+		// function is stopped or redeployed, defaults to DefaultDrainTimeout
+		DrainTimeout Duration `json:"drainTimeout,omitempty"`
+	}
+
+	// backend holds the proxies to a running process
+	backend struct {
+		http   *httputil.ReverseProxy
+		stream *httputil.ReverseProxy
+
+		// ctx is canceled to terminate long-lived connections
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		active  tracker
+		streams atomic.Int64
+
+		ports portMap
+	}
+
+	// tracker counts in-flight requests and allows waiting until all are done
+	tracker struct {
+		sync.Mutex
+		n        int
+		draining bool
+		idle     chan struct{}
+	}
+
+	idleConn struct {
+		net.Conn
+		timeout time.Duration
+	}
+)
+
+const DefaultDrainTimeout = 30 * time.Second
+
+func (s Streaming) drainTimeout() time.Duration {
+	if s.DrainTimeout == 0 {
+		return DefaultDrainTimeout
+	}
+	return s.DrainTimeout.D()
+}
+
+// isStream returns true for requests expected to hold the connection open
+func isStream(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, v := range strings.Split(accept, ",") {
+			if mt, _, _ := mime.ParseMediaType(strings.TrimSpace(v)); mt == "text/event-stream" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *Func) serveStream(w http.ResponseWriter, r *http.Request, b *backend, settings Streaming) {
+	if limit := int64(settings.MaxConnections); limit > 0 {
+		if b.streams.Add(1) > limit {
+			b.streams.Add(-1)
+			f.writeError(w, http.StatusServiceUnavailable, "too_many_streams", "too many long-lived connections")
+			return
+		}
+	} else {
+		b.streams.Add(1)
+	}
+	defer b.streams.Add(-1)
+
+	// the connection is terminated if the function is stopped before the client leaves
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(b.ctx, cancel)
+	defer stop()
+	b.stream.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// drain waits for in-flight requests to finish, long-lived connections
+// still open after the drain timeout are closed
+func (f *Func) drain(b *backend) {
+	timeout := f.Config().Streaming.drainTimeout()
+	if !b.active.wait(timeout) {
+		slog.Warn("Closing connections after drain timeout", "name", f.Name(), "streams", b.streams.Load(), "timeout", timeout)
+	}
+	b.cancel()
+}
+
+func (t *tracker) add() bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.draining {
+		return false
+	}
+	t.n++
+	return true
+}
+
+func (t *tracker) done() {
+	t.Lock()
+	defer t.Unlock()
+	t.n--
+	if t.n == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// wait rejects new requests and waits up to timeout for the active ones
+func (t *tracker) wait(timeout time.Duration) bool {
+	t.Lock()
+	t.draining = true
+	if t.n == 0 {
+		t.Unlock()
+		return true
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
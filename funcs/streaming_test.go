@@ -0,0 +1,148 @@
+package funcs
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeHTTP_EventStreamFlushes(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	f := newTestFunc(t, backend, Config{HTTP: HTTPSettings{RequestTimeout: Duration(50 * time.Millisecond)}})
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/testfunc/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the first event must arrive while the function is still writing,
+	// and streams must not be cut by the request timeout
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	if line != "data: first\n" {
+		t.Fatalf("unexpected event: %q", line)
+	}
+}
+
+// echoUpgrade is a minimal server that accepts an Upgrade and echoes lines back
+func echoUpgrade(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			rw.WriteString(line)
+			rw.Flush()
+		}
+	}))
+}
+
+func dialUpgrade(t *testing.T, ts *httptest.Server) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /testfunc/ws HTTP/1.1\r\nHost: test\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+func TestServeHTTP_UpgradeAndIdleTimeout(t *testing.T) {
+	backend := echoUpgrade(t)
+	defer backend.Close()
+	f := newTestFunc(t, backend, Config{Streaming: Streaming{IdleTimeout: Duration(200 * time.Millisecond)}})
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	conn, br, resp := dialUpgrade(t, ts)
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	io.WriteString(conn, "ping\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("unexpected echo %q: %v", line, err)
+	}
+
+	// without traffic the proxy closes the connection
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadString('\n'); err == nil {
+		t.Fatalf("connection should be closed after the idle timeout")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("idle timeout was not applied")
+	}
+}
+
+func TestServeHTTP_MaxStreamsAndDrain(t *testing.T) {
+	backend := echoUpgrade(t)
+	defer backend.Close()
+	f := newTestFunc(t, backend, Config{Streaming: Streaming{MaxConnections: 1, DrainTimeout: Duration(100 * time.Millisecond)}})
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	conn, br, resp := dialUpgrade(t, ts)
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+
+	second, _, resp := dialUpgrade(t, ts)
+	second.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("second stream should be rejected, got %d", resp.StatusCode)
+	}
+
+	// stopping the function closes the remaining stream after the drain timeout
+	b := f.proxy.Load()
+	f.stop(stateStopped)
+	drained := make(chan struct{})
+	go func() {
+		f.drain(b)
+		close(drained)
+	}()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadString('\n'); err == nil {
+		t.Fatalf("stream should be closed after draining")
+	}
+	<-drained
+}
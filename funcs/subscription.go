@@ -0,0 +1,102 @@
+package funcs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/andrebq/gofunc/pkg/cloudevents"
+)
+
+type (
+	// Subscription delivers the events published to Topic as a POST to
+	// Path (relative to /{func_name}).
+	//
+	// Subscriptions without a Topic receive the events sent to the CloudEvents
+	// ingress, they must filter on the event type.
+	Subscription struct {
+		ID    string `json:"id"`
+		Topic string `json:"topic,omitempty"`
+		Path  string `json:"path,omitempty"`
+		// Mode is the CloudEvents HTTP mode used for deliveries, structured by default
+		Mode cloudevents.Mode `json:"mode,omitempty"`
+		// Filter maps CloudEvents attributes (eg.: type, source, subject or
+		// an extension) to the value they must have, a trailing * matches
+		// any value with that prefix. All entries must match.
+		Filter map[string]string `json:"filter,omitempty"`
+	}
+)
+
+var topicName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// ValidTopic returns an error if name cannot be used as a topic
+func ValidTopic(name string) error {
+	if !topicName.MatchString(name) {
+		return fmt.Errorf("invalid topic %q, use letters, digits, ., - and _", name)
+	}
+	return nil
+}
+
+// Matches returns true if e passes the subscription filter
+func (s Subscription) Matches(e *cloudevents.Event) bool {
+	for attr, want := range s.Filter {
+		got, ok := e.Attribute(attr)
+		if !ok {
+			return false
+		}
+		if prefix, wildcard := strings.CutSuffix(want, "*"); wildcard {
+			if !strings.HasPrefix(got, prefix) {
+				return false
+			}
+		} else if got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// DeliveryPath returns the path, relative to the function, events are posted to
+func (s Subscription) DeliveryPath() string {
+	if s.Path == "" {
+		return "/"
+	}
+	return s.Path
+}
+
+func validateSubscriptions(c Config) error {
+	if c.IsWorker() && len(c.Subscriptions) > 0 {
+		return errors.New("subscriptions: workers cannot be invoked")
+	}
+	ids := map[string]bool{}
+	for _, s := range c.Subscriptions {
+		if !scheduleID.MatchString(s.ID) {
+			return fmt.Errorf("subscriptions: invalid id %q, use lowercase letters, digits, - and _", s.ID)
+		}
+		if ids[s.ID] {
+			return fmt.Errorf("subscriptions: duplicated id %q", s.ID)
+		}
+		ids[s.ID] = true
+		if s.Topic == "" {
+			if s.Filter["type"] == "" {
+				return fmt.Errorf("subscriptions: %v: a topic or a type filter is required", s.ID)
+			}
+		} else if err := ValidTopic(s.Topic); err != nil {
+			return fmt.Errorf("subscriptions: %v: %w", s.ID, err)
+		}
+		switch s.Mode {
+		case "", cloudevents.Structured, cloudevents.Binary:
+		default:
+			return fmt.Errorf("subscriptions: %v: unknown mode %q", s.ID, s.Mode)
+		}
+		if s.Path != "" && !strings.HasPrefix(s.Path, "/") {
+			return fmt.Errorf("subscriptions: %v: path must start with /", s.ID)
+		}
+		for attr := range s.Filter {
+			if attr == "" {
+				return fmt.Errorf("subscriptions: %v: empty filter attribute", s.ID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package funcs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// goModule is a module version as printed by go mod edit -json
+	goModule struct {
+		Path    string
+		Version string
+	}
+
+	// goModFile is the subset of go mod edit -json used to check vendor directories
+	goModFile struct {
+		Require []goModule
+		Replace []struct {
+			Old goModule
+			New goModule
+		}
+	}
+
+	// vendoredModule is a module listed in vendor/modules.txt
+	vendoredModule struct {
+		version  string
+		replace  string
+		explicit bool
+	}
+)
+
+// VendorManifest is the file go mod vendor writes with the vendored modules
+const VendorManifest = "vendor/modules.txt"
+
+// vendored returns true when srcdir carries its dependencies in vendor/, in
+// which case vendor/modules.txt must match the requirements in go.mod
+func vendored(srcdir string) (bool, error) {
+	f, err := os.Open(filepath.Join(srcdir, filepath.FromSlash(VendorManifest)))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	vendor, err := parseModulesTxt(f)
+	if err != nil {
+		return false, fmt.Errorf("read %v: %w", VendorManifest, err)
+	}
+
+	cmd := exec.Command("go", "mod", "edit", "-json")
+	cmd.Dir = srcdir
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("read go.mod: %w", err)
+	}
+	var gomod goModFile
+	if err := json.Unmarshal(out, &gomod); err != nil {
+		return false, fmt.Errorf("read go.mod: %w", err)
+	}
+	replaces := map[goModule]string{}
+	for _, r := range gomod.Replace {
+		replaces[r.Old] = strings.TrimSpace(r.New.Path + " " + r.New.Version)
+	}
+
+	var problems []string
+	required := map[string]bool{}
+	for _, req := range gomod.Require {
+		required[req.Path] = true
+		v, ok := vendor[req.Path]
+		switch {
+		case !ok || !v.explicit:
+			problems = append(problems, fmt.Sprintf("%v %v is required but not vendored", req.Path, req.Version))
+		case v.version != req.Version:
+			problems = append(problems, fmt.Sprintf("%v is required at %v but %v is vendored", req.Path, req.Version, v.version))
+		default:
+			replace, ok := replaces[req]
+			if !ok {
+				replace = replaces[goModule{Path: req.Path}]
+			}
+			if replace != v.replace {
+				problems = append(problems, fmt.Sprintf("%v is replaced by %q in go.mod but by %q in vendor", req.Path, replace, v.replace))
+			}
+		}
+	}
+	for path, v := range vendor {
+		if v.explicit && !required[path] {
+			problems = append(problems, fmt.Sprintf("%v %v is vendored but not required", path, v.version))
+		}
+	}
+	if len(problems) > 0 {
+		return false, fmt.Errorf("%v is inconsistent with go.mod (run go mod vendor): %v", VendorManifest, strings.Join(problems, "; "))
+	}
+	return true, nil
+}
+
+// parseModulesTxt reads the modules of a vendor/modules.txt file, they are
+// listed as "# path version [=> replacement]" followed by "## explicit" when
+// go.mod requires them
+func parseModulesTxt(r io.Reader) (map[string]*vendoredModule, error) {
+	mods := map[string]*vendoredModule{}
+	var last *vendoredModule
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "## "):
+			if last == nil {
+				continue
+			}
+			for _, field := range strings.Split(strings.TrimPrefix(line, "## "), ";") {
+				if strings.TrimSpace(field) == "explicit" {
+					last.explicit = true
+				}
+			}
+		case strings.HasPrefix(line, "# "):
+			mod, replace, _ := strings.Cut(strings.TrimPrefix(line, "# "), "=>")
+			fields := strings.Fields(mod)
+			if len(fields) != 2 {
+				// "# path => replacement" lines only repeat replace directives
+				last = nil
+				continue
+			}
+			last = &vendoredModule{version: fields[1], replace: strings.Join(strings.Fields(replace), " ")}
+			mods[fields[0]] = last
+		}
+	}
+	return mods, sc.Err()
+}
@@ -0,0 +1,35 @@
+package funcs
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompile_Vendored(t *testing.T) {
+	tmp := t.TempDir()
+	files := map[string]string{
+		"go.mod":                            "module example.com/vendored\n\ngo 1.24\n\nrequire example.com/greet v1.0.0\n",
+		"main.go":                           "package main\n\nimport \"example.com/greet\"\n\nfunc main() { println(greet.Hello()) }\n",
+		"vendor/modules.txt":                "# example.com/greet v1.0.0\n## explicit; go 1.24\nexample.com/greet\n",
+		"vendor/example.com/greet/greet.go": "package greet\n\nfunc Hello() string { return \"hello\" }\n",
+	}
+	zipPath := filepath.Join(tmp, "src.zip")
+	writeZip(t, zipPath, files)
+
+	// modules cannot be downloaded, and GOFLAGS asks for it, so the build
+	// only works with -mod=vendor
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOFLAGS", "-mod=mod")
+	srcDir, binDir := filepath.Join(tmp, "src"), filepath.Join(tmp, "bin")
+	if _, err := Compile(zipPath, srcDir, binDir, "vendored"); err != nil {
+		t.Fatal(err)
+	}
+
+	files["go.mod"] = strings.Replace(files["go.mod"], "v1.0.0", "v1.1.0", 1)
+	writeZip(t, zipPath, files)
+	_, err := Compile(zipPath, srcDir, binDir, "vendored")
+	if err == nil || !strings.Contains(err.Error(), "required at v1.1.0 but v1.0.0 is vendored") {
+		t.Fatalf("inconsistent vendor directory should be rejected, got %v", err)
+	}
+}
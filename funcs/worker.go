@@ -0,0 +1,83 @@
+package funcs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// Kind selects how gofunc runs the function process
+	Kind string
+)
+
+const (
+	// KindService functions serve HTTP requests on BIND_PORT, the empty
+	// Kind is handled as a service
+	KindService = Kind("service")
+	// KindWorker functions are supervised but do not listen on a port,
+	// they have no readiness check and cannot be invoked
+	KindWorker = Kind("worker")
+
+	// ManifestFile is read from the root of the uploaded source on every
+	// deploy, values present in it override the stored function config
+	ManifestFile = "gofunc.json"
+)
+
+// ParseKind returns the Kind named by s
+func ParseKind(s string) (Kind, error) {
+	k := Kind(s)
+	if err := k.validate(); err != nil {
+		return "", err
+	}
+	return k, nil
+}
+
+func (k Kind) validate() error {
+	switch k {
+	case "", KindService, KindWorker:
+		return nil
+	}
+	return fmt.Errorf("kind: unknown kind %q, use service or worker", k)
+}
+
+// IsWorker returns true if the function runs as a background worker
+func (c Config) IsWorker() bool {
+	return c.Kind == KindWorker
+}
+
+func validateEnv(env map[string]string) error {
+	for k := range env {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return fmt.Errorf("env: invalid variable name %q", k)
+		}
+		if k == "BIND_ADDR" || k == "BIND_PORT" || strings.HasPrefix(k, "PORT_") || strings.HasPrefix(k, "GOFUNC_") {
+			return fmt.Errorf("env: %v is managed by gofunc", k)
+		}
+	}
+	return nil
+}
+
+// applyManifest overlays the manifest found at the root of srcdir on top of
+// the current config, the result is validated and saved
+func (f *Func) applyManifest(srcdir string) error {
+	buf, err := os.ReadFile(filepath.Join(srcdir, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	cfg := f.Config()
+	dec := json.NewDecoder(strings.NewReader(string(buf)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return fmt.Errorf("decode %v: %w", ManifestFile, err)
+	}
+	if err := f.UpdateConfig(cfg); err != nil {
+		return fmt.Errorf("%v: %w", ManifestFile, err)
+	}
+	return nil
+}
@@ -4,7 +4,10 @@ go 1.24.0
 
 require (
 	github.com/andrebq/maestro v0.0.2
+	github.com/klauspost/compress v1.18.0
 	github.com/urfave/cli/v2 v2.27.7
+	golang.org/x/sys v0.36.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
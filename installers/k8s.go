@@ -4,11 +4,18 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"strconv"
 	"text/template"
 )
 
-func K8S(ctx context.Context, output io.Writer, yamlTemplateFile string, name string, namespace string, image string) error {
+// K8S renders the template at yamlTemplateFile.
+//
+// ControlPort/ControlAddr describe the public listener used to invoke functions,
+// while AdminPort/AdminAddr describe the listener serving AdminSubpath.
+// When adminPort is 0 both share the same listener.
+func K8S(ctx context.Context, output io.Writer, yamlTemplateFile string, name string, namespace string, image string, port uint, adminPort uint) error {
 	in, err := openTemplate(yamlTemplateFile)
 	if err != nil {
 		return fmt.Errorf("failed to open template file %q: %w", yamlTemplateFile, err)
@@ -24,19 +31,33 @@ func K8S(ctx context.Context, output io.Writer, yamlTemplateFile string, name st
 		return fmt.Errorf("failed to parse template file %q: %w", yamlTemplateFile, err)
 	}
 
+	controlPort := strconv.FormatUint(uint64(port), 10)
+	adminAddr := ""
+	adminPortStr := controlPort
+	if adminPort != 0 {
+		adminPortStr = strconv.FormatUint(uint64(adminPort), 10)
+		adminAddr = net.JoinHostPort("0.0.0.0", adminPortStr)
+	}
+
 	return tmpl.Execute(output, struct {
 		Name         string
 		Image        string
 		Namespace    string
 		ControlPort  string
 		ControlAddr  string
+		AdminPort    string
+		AdminAddr    string
+		SplitAdmin   bool
 		AdminSubpath string
 	}{
 		Name:         name,
 		Image:        image,
 		Namespace:    namespace,
-		ControlPort:  "9000",
+		ControlPort:  controlPort,
 		ControlAddr:  "0.0.0.0",
+		AdminPort:    adminPortStr,
+		AdminAddr:    adminAddr,
+		SplitAdmin:   adminPort != 0,
 		AdminSubpath: "/_admin/",
 	})
 }
@@ -0,0 +1,54 @@
+package installers
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestK8S(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "gofunc.yaml")
+	err := os.WriteFile(tmpl, []byte(`name: {{.Name}}
+namespace: {{.Namespace}}
+image: {{.Image}}
+listen: {{.ControlAddr}}:{{.ControlPort}}
+admin: {{.AdminSubpath}}
+{{- if .SplitAdmin}}
+adminListen: {{.AdminAddr}}
+adminPort: {{.AdminPort}}
+{{- end}}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name            string
+		port, adminPort uint
+		expected        string
+	}{
+		{
+			name:     "shared listener",
+			port:     9000,
+			expected: "name: gofunc\nnamespace: apps\nimage: gofunc:v1\nlisten: 0.0.0.0:9000\nadmin: /_admin/\n",
+		},
+		{
+			name:      "admin listener",
+			port:      8080,
+			adminPort: 8081,
+			expected:  "name: gofunc\nnamespace: apps\nimage: gofunc:v1\nlisten: 0.0.0.0:8080\nadmin: /_admin/\nadminListen: 0.0.0.0:8081\nadminPort: 8081\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := K8S(context.Background(), &out, tmpl, "gofunc", "apps", "gofunc:v1", tc.port, tc.adminPort); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tc.expected {
+				t.Fatalf("unexpected manifest:\n%s\nexpected:\n%s", out.String(), tc.expected)
+			}
+		})
+	}
+}
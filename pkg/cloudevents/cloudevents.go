@@ -0,0 +1,233 @@
+// Package cloudevents implements the parts of the CloudEvents 1.0 spec
+// (JSON event format and HTTP binding) used by gofunc.
+package cloudevents
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type (
+	// Event is a CloudEvent, Data holds JSON data while DataBase64 holds
+	// binary data, at most one of them is set
+	Event struct {
+		SpecVersion     string          `json:"specversion"`
+		ID              string          `json:"id"`
+		Source          string          `json:"source"`
+		Type            string          `json:"type"`
+		Subject         string          `json:"subject,omitempty"`
+		Time            time.Time       `json:"time,omitzero"`
+		DataContentType string          `json:"datacontenttype,omitempty"`
+		DataSchema      string          `json:"dataschema,omitempty"`
+		Data            json.RawMessage `json:"data,omitempty"`
+		DataBase64      []byte          `json:"data_base64,omitempty"`
+
+		// Extensions are the attributes not defined by the spec
+		Extensions map[string]any `json:"-"`
+	}
+
+	// event avoids the recursion on Event.MarshalJSON
+	event Event
+)
+
+const (
+	// SpecVersion is the version of the spec implemented by this package
+	SpecVersion = "1.0"
+	// ContentType is the media type of events in structured mode
+	ContentType = "application/cloudevents+json"
+)
+
+var (
+	extensionName = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+	knownAttributes = map[string]bool{
+		"specversion": true, "id": true, "source": true, "type": true, "subject": true, "time": true,
+		"datacontenttype": true, "dataschema": true, "data": true, "data_base64": true,
+	}
+
+	// ErrInvalid is wrapped by the errors returned from Validate
+	ErrInvalid = errors.New("invalid cloudevent")
+)
+
+// New returns an event with a random id and the current time
+func New(source, eventType string) *Event {
+	var id [16]byte
+	rand.Read(id[:])
+	return &Event{
+		SpecVersion: SpecVersion,
+		ID:          hex.EncodeToString(id[:]),
+		Source:      source,
+		Type:        eventType,
+		Time:        time.Now().UTC(),
+	}
+}
+
+// SetJSON encodes v as the event data
+func (e *Event) SetJSON(v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.SetData("application/json", buf)
+	return nil
+}
+
+// DecodeJSON decodes the event data into v
+func (e *Event) DecodeJSON(v any) error {
+	return json.Unmarshal(e.DataBytes(), v)
+}
+
+// Validate checks the required attributes and the names of the extensions
+func (e *Event) Validate() error {
+	switch {
+	case e.SpecVersion != SpecVersion:
+		return fmt.Errorf("%w: unsupported specversion %q", ErrInvalid, e.SpecVersion)
+	case e.ID == "":
+		return fmt.Errorf("%w: missing id", ErrInvalid)
+	case e.Source == "":
+		return fmt.Errorf("%w: missing source", ErrInvalid)
+	case e.Type == "":
+		return fmt.Errorf("%w: missing type", ErrInvalid)
+	case len(e.Data) > 0 && len(e.DataBase64) > 0:
+		return fmt.Errorf("%w: data and data_base64 are mutually exclusive", ErrInvalid)
+	}
+	for name := range e.Extensions {
+		if !extensionName.MatchString(name) || knownAttributes[name] {
+			return fmt.Errorf("%w: invalid extension name %q", ErrInvalid, name)
+		}
+	}
+	return nil
+}
+
+// Attribute returns the value of a context attribute (or extension) as a string
+func (e *Event) Attribute(name string) (string, bool) {
+	switch name {
+	case "specversion":
+		return e.SpecVersion, true
+	case "id":
+		return e.ID, true
+	case "source":
+		return e.Source, true
+	case "type":
+		return e.Type, true
+	case "subject":
+		return e.Subject, e.Subject != ""
+	case "time":
+		return e.Time.Format(time.RFC3339Nano), !e.Time.IsZero()
+	case "datacontenttype":
+		return e.DataContentType, e.DataContentType != ""
+	case "dataschema":
+		return e.DataSchema, e.DataSchema != ""
+	}
+	v, ok := e.Extensions[name]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+// SetData stores payload as the event data, JSON content is kept as is,
+// text is encoded as a JSON string and anything else goes to DataBase64
+func (e *Event) SetData(contentType string, payload []byte) {
+	e.DataContentType = contentType
+	e.Data, e.DataBase64 = nil, nil
+	if len(payload) == 0 {
+		return
+	}
+	switch {
+	case isJSON(contentType) && json.Valid(payload):
+		e.Data = append(json.RawMessage(nil), payload...)
+	case strings.HasPrefix(mediaType(contentType), "text/"):
+		e.Data, _ = json.Marshal(string(payload))
+	default:
+		e.DataBase64 = append([]byte(nil), payload...)
+	}
+}
+
+// DataBytes returns the payload as it would be sent in binary mode
+func (e *Event) DataBytes() []byte {
+	switch {
+	case len(e.DataBase64) > 0:
+		return e.DataBase64
+	case len(e.Data) == 0:
+		return nil
+	case !isJSON(e.DataContentType) && e.DataContentType != "":
+		// text payloads are stored as JSON strings
+		var s string
+		if json.Unmarshal(e.Data, &s) == nil {
+			return []byte(s)
+		}
+	}
+	return e.Data
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	buf, err := json.Marshal(event(e))
+	if err != nil || len(e.Extensions) == 0 {
+		return buf, err
+	}
+	ext, err := json.Marshal(e.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	// merge both objects: {...attributes,...extensions}
+	out := bytes.TrimSuffix(buf, []byte("}"))
+	out = append(out, ',')
+	return append(out, ext[1:]...), nil
+}
+
+func (e *Event) UnmarshalJSON(buf []byte) error {
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &attrs); err != nil {
+		return err
+	}
+	var ev event
+	if err := json.Unmarshal(buf, &ev); err != nil {
+		return err
+	}
+	for name, raw := range attrs {
+		if knownAttributes[name] {
+			continue
+		}
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if ev.Extensions == nil {
+			ev.Extensions = map[string]any{}
+		}
+		ev.Extensions[name] = v
+	}
+	*e = Event(ev)
+	return nil
+}
+
+// Clone returns a copy of e that does not share data with it
+func (e Event) Clone() Event {
+	e.Data = append(json.RawMessage(nil), e.Data...)
+	e.DataBase64 = append([]byte(nil), e.DataBase64...)
+	e.Extensions = maps.Clone(e.Extensions)
+	return e
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
+}
+
+func isJSON(contentType string) bool {
+	mt := mediaType(contentType)
+	return mt == "" || mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,108 @@
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRequest_Structured(t *testing.T) {
+	body := `{"specversion":"1.0","id":"1","source":"/orders","type":"order.created","data":{"total":10},"tenant":"acme"}`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+	e, err := ParseRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := e.Attribute("tenant"); v != "acme" {
+		t.Fatalf("extension not parsed: %+v", e)
+	}
+	if string(e.DataBytes()) != `{"total":10}` {
+		t.Fatalf("unexpected data %s", e.DataBytes())
+	}
+
+	// extensions are written back as top level attributes
+	buf, _ := json.Marshal(e)
+	var attrs map[string]any
+	json.Unmarshal(buf, &attrs)
+	if attrs["tenant"] != "acme" || attrs["type"] != "order.created" {
+		t.Fatalf("unexpected encoding %s", buf)
+	}
+}
+
+func TestParseRequest_Binary(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader("hello world"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Id", "abc")
+	req.Header.Set("Ce-Source", "/greeter")
+	req.Header.Set("Ce-Type", "greeting")
+	req.Header.Set("Ce-Subject", "caf%C3%A9")
+	req.Header.Set("Ce-Tenant", "acme")
+	e, err := ParseRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Subject != "café" || e.Extensions["tenant"] != "acme" {
+		t.Fatalf("unexpected attributes %+v", e)
+	}
+	if string(e.Data) != `"hello world"` || string(e.DataBytes()) != "hello world" {
+		t.Fatalf("unexpected data %s", e.Data)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Event{SpecVersion: SpecVersion, ID: "1", Source: "/s", Type: "t"}
+	for name, mut := range map[string]func(*Event){
+		"version":   func(e *Event) { e.SpecVersion = "0.3" },
+		"id":        func(e *Event) { e.ID = "" },
+		"source":    func(e *Event) { e.Source = "" },
+		"type":      func(e *Event) { e.Type = "" },
+		"extension": func(e *Event) { e.Extensions = map[string]any{"Bad-Name": 1} },
+		"data":      func(e *Event) { e.Data, e.DataBase64 = json.RawMessage(`1`), []byte{1} },
+	} {
+		e := valid.Clone()
+		mut(&e)
+		if err := e.Validate(); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
+
+func TestNewRequest_Binary(t *testing.T) {
+	e := New("/orders", "order.created")
+	e.Subject = "100% café"
+	e.Extensions = map[string]any{"tenant": "acme"}
+	if err := e.SetJSON(map[string]int{"total": 10}); err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewRequest(context.Background(), "http://localhost/", e, Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("Ce-Type") != "order.created" || req.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+	got, err := ParseRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := got.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != e.ID || got.Subject != e.Subject || !got.Time.Equal(e.Time) || got.Extensions["tenant"] != "acme" {
+		t.Fatalf("attributes were not preserved: %+v", got)
+	}
+	var data struct{ Total int }
+	if err := got.DecodeJSON(&data); err != nil || data.Total != 10 {
+		t.Fatalf("unexpected data %s: %v", got.Data, err)
+	}
+}
@@ -0,0 +1,207 @@
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// headerPrefix is used by context attributes in binary mode
+const headerPrefix = "Ce-"
+
+// ErrBatch is returned for batched events, which are not supported
+var ErrBatch = errors.New("batched cloudevents are not supported")
+
+// IsStructured returns true if the request carries a structured mode event
+func IsStructured(h http.Header) bool {
+	return mediaType(h.Get("Content-Type")) == ContentType
+}
+
+// ParseRequest reads an event sent in structured or binary mode, the
+// returned event is not validated
+func ParseRequest(r *http.Request) (*Event, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType(r.Header.Get("Content-Type")) == "application/cloudevents-batch+json" {
+		return nil, ErrBatch
+	}
+	if IsStructured(r.Header) {
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return &e, nil
+	}
+	return parseBinary(r.Header, body)
+}
+
+func parseBinary(h http.Header, body []byte) (*Event, error) {
+	e := &Event{}
+	for key, values := range h {
+		name, ok := strings.CutPrefix(http.CanonicalHeaderKey(key), headerPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		name = strings.ToLower(name)
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			value = values[0]
+		}
+		switch name {
+		case "specversion":
+			e.SpecVersion = value
+		case "id":
+			e.ID = value
+		case "source":
+			e.Source = value
+		case "type":
+			e.Type = value
+		case "subject":
+			e.Subject = value
+		case "dataschema":
+			e.DataSchema = value
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid time %q", ErrInvalid, value)
+			}
+			e.Time = t
+		default:
+			if e.Extensions == nil {
+				e.Extensions = map[string]any{}
+			}
+			e.Extensions[name] = value
+		}
+	}
+	e.SetData(h.Get("Content-Type"), body)
+	return e, nil
+}
+
+// Mode selects how an event is carried by an HTTP message
+type Mode string
+
+const (
+	// Structured sends the whole event as a JSON document
+	Structured = Mode("structured")
+	// Binary sends the attributes as Ce-* headers and the data as the body
+	Binary = Mode("binary")
+)
+
+// Encode returns the headers and body used to send e in the given mode
+func Encode(e *Event, mode Mode) (http.Header, []byte, error) {
+	h := http.Header{}
+	if mode != Binary {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.Set("Content-Type", ContentType)
+		return h, body, nil
+	}
+	set := func(name, value string) {
+		if value != "" {
+			h.Set(headerPrefix+name, encodeHeader(value))
+		}
+	}
+	set("Specversion", e.SpecVersion)
+	set("Id", e.ID)
+	set("Source", e.Source)
+	set("Type", e.Type)
+	set("Subject", e.Subject)
+	set("Dataschema", e.DataSchema)
+	if !e.Time.IsZero() {
+		set("Time", e.Time.Format(time.RFC3339Nano))
+	}
+	for name, v := range e.Extensions {
+		set(name, fmt.Sprint(v))
+	}
+	switch {
+	case e.DataContentType != "":
+		h.Set("Content-Type", e.DataContentType)
+	case len(e.Data) > 0:
+		h.Set("Content-Type", "application/json")
+	}
+	return h, e.DataBytes(), nil
+}
+
+// NewRequest returns a POST to target carrying e
+func NewRequest(ctx context.Context, target string, e *Event, mode Mode) (*http.Request, error) {
+	h, body, err := Encode(e, mode)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(req.Header, h)
+	return req, nil
+}
+
+// Publish sends e to a gofunc topic, it must be called from a function
+// since it relies on the GOFUNC_URL set by gofunc
+func Publish(ctx context.Context, topic string, e *Event) error {
+	base := os.Getenv("GOFUNC_URL")
+	if base == "" {
+		return errors.New("GOFUNC_URL is not set, Publish must be called by a gofunc function")
+	}
+	req, err := NewRequest(ctx, base+"/_events/"+url.PathEscape(topic), e, Structured)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("publish to %v: status %v: %s", topic, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Handler returns an http.Handler that parses and validates events before
+// calling fn. Invalid events receive 400, errors returned by fn receive 500
+// (so gofunc retries the delivery) and successful calls receive 204.
+func Handler(fn func(ctx context.Context, e *Event) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, err := ParseRequest(r)
+		if err == nil {
+			err = e.Validate()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := fn(r.Context(), e); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// encodeHeader percent-encodes the characters not allowed in header values
+func encodeHeader(v string) string {
+	var sb strings.Builder
+	for _, b := range []byte(v) {
+		if b < 0x20 || b > 0x7e || b == '"' || b == '%' {
+			fmt.Fprintf(&sb, "%%%02X", b)
+			continue
+		}
+		sb.WriteByte(b)
+	}
+	return sb.String()
+}
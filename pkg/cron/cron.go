@@ -0,0 +1,222 @@
+// Package cron parses the classic 5 field cron expressions
+//
+//	minute hour day-of-month month day-of-week
+//
+// Fields accept *, lists (1,2), ranges (1-5), steps (*/15, 1-30/5) and the
+// names of months and weekdays (jan, mon). The @yearly, @monthly, @weekly,
+// @daily and @hourly macros are also supported.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Schedule is a parsed cron expression
+	Schedule struct {
+		expr string
+
+		minute, hour, dom, month, dow uint64
+		// domStar and dowStar track unrestricted day fields, when both
+		// are restricted a day matches if either of them matches
+		domStar, dowStar bool
+	}
+
+	field struct {
+		name     string
+		min, max int
+		names    map[string]int
+	}
+)
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as sunday
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	macros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+
+	// maxSearch bounds Next for expressions that never match (eg.: 30 feb)
+	maxSearch = 5 * 366 * 24 * time.Hour
+
+	errNoMatch = errors.New("expression never matches")
+)
+
+// Parse returns the Schedule described by expr
+func Parse(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if m, ok := macros[strings.ToLower(spec)]; ok {
+		spec = m
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: %q: expected 5 fields, got %v", expr, len(fields))
+	}
+	s := &Schedule{expr: expr}
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	if s.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("cron: %q: %w", expr, errNoMatch)
+	}
+	return s, nil
+}
+
+// String returns the expression used to create s
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next returns the first time after t that matches the schedule, in the
+// location of t. The zero time is returned if there is no such time.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		if !s.match(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.match(s.hour, t.Hour()) {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) {
+				// the wall clock went back (DST), move forward in absolute time
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if !s.match(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) match(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.match(s.dom, t.Day())
+	dow := s.match(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (f field) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		b, err := f.parseRange(part)
+		if err != nil {
+			return 0, fmt.Errorf("cron: %v: %w", f.name, err)
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+func (f field) parseRange(spec string) (uint64, error) {
+	rng, stepStr, hasStep := strings.Cut(spec, "/")
+	step := 1
+	if hasStep {
+		var err error
+		step, err = strconv.Atoi(stepStr)
+		if err != nil || step <= 0 {
+			return 0, fmt.Errorf("invalid step %q", stepStr)
+		}
+	}
+	var lo, hi int
+	switch {
+	case rng == "*":
+		lo, hi = f.min, f.max
+	case strings.Contains(rng, "-"):
+		loStr, hiStr, _ := strings.Cut(rng, "-")
+		var err error
+		if lo, err = f.value(loStr); err != nil {
+			return 0, err
+		}
+		if hi, err = f.value(hiStr); err != nil {
+			return 0, err
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %q", rng)
+		}
+	default:
+		var err error
+		if lo, err = f.value(rng); err != nil {
+			return 0, err
+		}
+		hi = lo
+		if hasStep {
+			// 5/10 means from 5 to the end of the range, every 10
+			hi = f.max
+		}
+	}
+	var bits uint64
+	for v := lo; v <= hi; v += step {
+		bits |= 1 << uint(v)
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %v out of range [%v, %v]", v, f.min, f.max)
+	}
+	return v, nil
+}
@@ -0,0 +1,55 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	from := time.Date(2024, 1, 31, 10, 7, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2024, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", from, time.Date(2024, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"@daily", from, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", from, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"30 8 * * mon-fri", time.Date(2024, 2, 2, 9, 0, 0, 0, time.UTC), time.Date(2024, 2, 5, 8, 30, 0, 0, time.UTC)},
+		// both day fields restricted: either one matches
+		{"0 0 1 * sun", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 6 * * *", time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC).In(saoPaulo), time.Date(2024, 2, 1, 6, 0, 0, 0, saoPaulo)},
+	} {
+		s, err := Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.expr, err)
+		}
+		if got := s.Next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("%v: next after %v should be %v, got %v", tc.expr, tc.from, tc.want, got)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* * * * * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * 30 feb *",
+		"* * * foo *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%q should be rejected", expr)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package fswatch reports the files written to a directory, it uses inotify
+// when available and falls back to polling otherwise.
+package fswatch
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+type (
+	// Watcher reports the regular files in Dir once they are ready to be read
+	Watcher struct {
+		Dir string
+		// Interval between directory scans, a file found by a scan is ready
+		// once its size and modification time did not change for a full interval
+		Interval time.Duration
+		// Accept filters the files reported, all files are reported when nil
+		Accept func(name string) bool
+	}
+
+	fileState struct {
+		size    int64
+		modTime int64
+	}
+
+	scanner struct {
+		w      *Watcher
+		seen   map[string]fileState
+		hinted map[string]bool
+	}
+)
+
+// Run calls fn, one file at a time, until ctx is done. Files that are still
+// in Dir after fn returns are reported again later.
+func (w *Watcher) Run(ctx context.Context, fn func(name string)) error {
+	if _, err := os.ReadDir(w.Dir); err != nil {
+		return err
+	}
+	hints, stop, err := notify(w.Dir)
+	if err != nil {
+		slog.Warn("Unable to use inotify, polling directory", "dir", w.Dir, "error", err)
+	} else {
+		defer stop()
+	}
+	s := &scanner{w: w, seen: map[string]fileState{}, hinted: map[string]bool{}}
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		s.scan(ctx, fn)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case name, ok := <-hints:
+			if !ok {
+				hints = nil
+				continue
+			}
+			s.hinted[name] = true
+			// coalesce bursts of events into a single scan
+		drain:
+			for {
+				select {
+				case name, ok := <-hints:
+					if !ok {
+						hints = nil
+						break drain
+					}
+					s.hinted[name] = true
+				default:
+					break drain
+				}
+			}
+		}
+	}
+}
+
+func (s *scanner) scan(ctx context.Context, fn func(name string)) {
+	entries, err := os.ReadDir(s.w.Dir)
+	if err != nil {
+		slog.Warn("Unable to scan directory", "dir", s.w.Dir, "error", err)
+		return
+	}
+	current := map[string]fileState{}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || (s.w.Accept != nil && !s.w.Accept(name)) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		st := fileState{size: info.Size(), modTime: info.ModTime().UnixNano()}
+		if prev, ok := s.seen[name]; !s.hinted[name] && (!ok || prev != st) {
+			current[name] = st
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		// files left behind by fn start over as new files
+		fn(name)
+	}
+	s.seen = current
+	clear(s.hinted)
+}
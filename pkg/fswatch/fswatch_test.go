@@ -0,0 +1,68 @@
+package fswatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "existing.csv"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("b"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	found := make(chan string, 10)
+	w := &Watcher{Dir: dir, Interval: 50 * time.Millisecond, Accept: func(name string) bool { return strings.HasSuffix(name, ".csv") }}
+	go w.Run(ctx, func(name string) {
+		os.Remove(filepath.Join(dir, name))
+		found <- name
+	})
+
+	next := func() string {
+		t.Helper()
+		select {
+		case name := <-found:
+			return name
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for file")
+			return ""
+		}
+	}
+	if name := next(); name != "existing.csv" {
+		t.Fatalf("unexpected file %v", name)
+	}
+	os.WriteFile(filepath.Join(dir, "new.csv"), []byte("c"), 0644)
+	if name := next(); name != "new.csv" {
+		t.Fatalf("unexpected file %v", name)
+	}
+	select {
+	case name := <-found:
+		t.Fatalf("unexpected file %v", name)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestScanner_WaitsForStableFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	os.WriteFile(path, []byte("a"), 0644)
+	s := &scanner{w: &Watcher{Dir: dir}, seen: map[string]fileState{}, hinted: map[string]bool{}}
+	var found []string
+	fn := func(name string) { found = append(found, name) }
+
+	s.scan(context.Background(), fn)
+	os.WriteFile(path, []byte("ab"), 0644)
+	s.scan(context.Background(), fn)
+	if len(found) != 0 {
+		t.Fatalf("files being written should not be reported, got %v", found)
+	}
+	s.scan(context.Background(), fn)
+	if len(found) != 1 {
+		t.Fatalf("stable files should be reported, got %v", found)
+	}
+}
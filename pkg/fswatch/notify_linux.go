@@ -0,0 +1,58 @@
+package fswatch
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// notify reports the names of the files closed after writing or moved into dir
+func notify(dir string) (<-chan string, func(), error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO); err != nil {
+		unix.Close(fd)
+		return nil, nil, err
+	}
+	// non-blocking descriptors use the runtime poller, so Close interrupts Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	names := make(chan string, 64)
+	done := make(chan struct{})
+	go func() {
+		defer close(names)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+unix.SizeofInotifyEvent <= n; {
+				// struct inotify_event: wd, mask, cookie, len and the name
+				nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+				start := off + unix.SizeofInotifyEvent
+				off = start + nameLen
+				if off > n {
+					break
+				}
+				name := strings.TrimRight(string(buf[start:off]), "\x00")
+				if name == "" {
+					continue
+				}
+				select {
+				case names <- name:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	stop := func() {
+		close(done)
+		f.Close()
+	}
+	return names, stop, nil
+}
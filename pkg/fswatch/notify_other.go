@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fswatch
+
+// notify is not implemented outside Linux, the directory is polled
+func notify(dir string) (<-chan string, func(), error) {
+	return nil, func() {}, nil
+}
@@ -0,0 +1,147 @@
+// Package jsonstore keeps JSON documents as individual files in a directory.
+//
+// Writes are atomic (write to a temporary file and rename) which is enough to
+// survive crashes of the server, but the store does not coordinate multiple
+// processes writing to the same directory.
+package jsonstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type (
+	// Store is a directory of JSON documents, indexed by key
+	Store struct {
+		dir string
+	}
+)
+
+const ext = ".json"
+
+var (
+	// ErrNotFound is returned when a key is not present in the store
+	ErrNotFound = errors.New("jsonstore: not found")
+
+	validKey = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// Open returns the store located at dir, creating it if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("jsonstore: create %q: %w", dir, err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the directory holding the documents
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Put stores v under key, replacing any previous value
+func (s *Store) Put(key string, v any) error {
+	file, err := s.file(key)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("jsonstore: encode %q: %w", key, err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("jsonstore: write %q: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("jsonstore: write %q: %w", key, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("jsonstore: sync %q: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("jsonstore: write %q: %w", key, err)
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Get decodes the document stored under key into v
+func (s *Store) Get(key string, v any) error {
+	file, err := s.file(key)
+	if err != nil {
+		return err
+	}
+	buf, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("jsonstore: read %q: %w", key, err)
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("jsonstore: decode %q: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes key from the store, missing keys are ignored
+func (s *Store) Delete(key string) error {
+	file, err := s.file(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("jsonstore: delete %q: %w", key, err)
+	}
+	return nil
+}
+
+// Move transfers key to another store, both stores must be on the same filesystem
+func (s *Store) Move(key string, to *Store) error {
+	from, err := s.file(key)
+	if err != nil {
+		return err
+	}
+	dest, _ := to.file(key)
+	if err := os.Rename(from, dest); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("jsonstore: move %q: %w", key, err)
+	}
+	return nil
+}
+
+// Keys returns the keys in the store, sorted
+func (s *Store) Keys() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("jsonstore: list: %w", err)
+	}
+	var keys []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if key := strings.TrimSuffix(name, ext); validKey.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (s *Store) file(key string) (string, error) {
+	if !validKey.MatchString(key) {
+		return "", fmt.Errorf("jsonstore: invalid key %q", key)
+	}
+	return filepath.Join(s.dir, key+ext), nil
+}
@@ -0,0 +1,59 @@
+package jsonstore
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	type doc struct {
+		Name  string
+		Count int
+	}
+	s, err := Open(filepath.Join(t.TempDir(), "docs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Open(filepath.Join(s.Dir(), "..", "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d doc
+	if err := s.Get("missing", &d); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.Put("../escape", doc{}); err == nil {
+		t.Fatalf("keys with path separators must be rejected")
+	}
+
+	for _, k := range []string{"b", "a"} {
+		if err := s.Put(k, doc{Name: k, Count: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put("a", doc{Name: "a", Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get("a", &d); err != nil || d.Count != 2 {
+		t.Fatalf("unexpected doc %+v: %v", d, err)
+	}
+	if keys, _ := s.Keys(); !slices.Equal(keys, []string{"a", "b"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	if err := s.Move("b", other); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Get("b", &d); err != nil || d.Name != "b" {
+		t.Fatalf("moved doc not found: %v", err)
+	}
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := s.Keys(); len(keys) != 0 {
+		t.Fatalf("store should be empty, got %v", keys)
+	}
+}
@@ -0,0 +1,126 @@
+// Package metrics implements a tiny registry of counters and gauges
+// exposed using the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Kind of the metric, either "counter" or "gauge"
+	Kind string
+
+	// Registry holds all metrics exposed by the process
+	Registry struct {
+		sync.Mutex
+		families map[string]*family
+	}
+
+	family struct {
+		name, help string
+		kind       Kind
+		series     map[string]*Value
+	}
+
+	// Value is a single time series of a metric
+	Value struct {
+		labels string
+		v      atomic.Int64
+	}
+)
+
+const (
+	Counter = Kind("counter")
+	Gauge   = Kind("gauge")
+)
+
+// Default is the registry used by gofunc
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Counter returns the counter identified by name and the given label pairs
+// (key, value, key, value...), creating it if needed
+func (r *Registry) Counter(name, help string, labels ...string) *Value {
+	return r.get(Counter, name, help, labels)
+}
+
+// Gauge returns the gauge identified by name and the given label pairs
+// (key, value, key, value...), creating it if needed
+func (r *Registry) Gauge(name, help string, labels ...string) *Value {
+	return r.get(Gauge, name, help, labels)
+}
+
+func (r *Registry) get(kind Kind, name, help string, labels []string) *Value {
+	key := encodeLabels(labels)
+	r.Lock()
+	defer r.Unlock()
+	f := r.families[name]
+	if f == nil {
+		f = &family{name: name, help: help, kind: kind, series: map[string]*Value{}}
+		r.families[name] = f
+	}
+	v := f.series[key]
+	if v == nil {
+		v = &Value{labels: key}
+		f.series[key] = v
+	}
+	return v
+}
+
+// WriteText writes all metrics using the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.Lock()
+	names := make([]string, 0, len(r.families))
+	for n := range r.families {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	var sb strings.Builder
+	for _, n := range names {
+		f := r.families[n]
+		fmt.Fprintf(&sb, "# HELP %v %v\n", f.name, f.help)
+		fmt.Fprintf(&sb, "# TYPE %v %v\n", f.name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&sb, "%v%v %v\n", f.name, k, f.series[k].Get())
+		}
+	}
+	r.Unlock()
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (v *Value) Inc()           { v.v.Add(1) }
+func (v *Value) Dec()           { v.v.Add(-1) }
+func (v *Value) Add(n int64)    { v.v.Add(n) }
+func (v *Value) Set(n int64)    { v.v.Store(n) }
+func (v *Value) Get() int64     { return v.v.Load() }
+func (v *Value) Labels() string { return v.labels }
+
+func encodeLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "%v=%q", labels[i], labels[i+1])
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
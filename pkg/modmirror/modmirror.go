@@ -0,0 +1,337 @@
+// Package modmirror manages a directory laid out as a Go module proxy, which
+// go commands can use offline through GOPROXY=file:///path/to/dir.
+package modmirror
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Mirror is a module proxy directory
+	Mirror struct {
+		dir string
+		// lock serializes writes, which keeps the version lists consistent
+		lock sync.Mutex
+	}
+
+	// Module lists the versions of a module available in the mirror
+	Module struct {
+		Path     string   `json:"path"`
+		Versions []string `json:"versions"`
+	}
+
+	// versionInfo is the content of the .info files
+	versionInfo struct {
+		Version string
+		Time    time.Time
+	}
+)
+
+var (
+	// ErrInvalid is wrapped by the errors caused by invalid uploads
+	ErrInvalid = errors.New("invalid module")
+
+	validVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	validPath    = regexp.MustCompile(`^[A-Za-z0-9._~+-]+(/[A-Za-z0-9._~+-]+)*$`)
+)
+
+// Open returns the mirror located at dir, creating it if needed
+func Open(dir string) (*Mirror, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("modmirror: create %q: %w", abs, err)
+	}
+	return &Mirror{dir: abs}, nil
+}
+
+// Dir returns the directory holding the modules
+func (m *Mirror) Dir() string {
+	return m.dir
+}
+
+// URL returns the value used in GOPROXY to read from the mirror
+func (m *Mirror) URL() string {
+	return "file://" + filepath.ToSlash(m.dir)
+}
+
+// AddZip stores a module zip, as produced by go mod download, for the given
+// module path and version
+func (m *Mirror) AddZip(modPath, version string, zr *zip.Reader) error {
+	if err := checkModule(modPath, version); err != nil {
+		return err
+	}
+	prefix := modPath + "@" + version + "/"
+	var gomod []byte
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, prefix) {
+			return fmt.Errorf("%w: file %q is outside of %v", ErrInvalid, f.Name, prefix)
+		}
+		if f.Name == prefix+"go.mod" {
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalid, err)
+			}
+			gomod, err = io.ReadAll(io.LimitReader(rc, 16<<20))
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalid, err)
+			}
+		}
+	}
+	if gomod == nil {
+		// modules without go.mod get a synthesized one, like go does
+		gomod = []byte("module " + modPath + "\n")
+	}
+	info, _ := json.Marshal(versionInfo{Version: version, Time: time.Now().UTC()})
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	dir, err := m.versionDir(modPath)
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(dir, escape(version))
+	if err := writeFile(base+".zip", func(w io.Writer) error { return copyZip(w, zr) }); err != nil {
+		return err
+	}
+	if err := writeBytes(base+".mod", gomod); err != nil {
+		return err
+	}
+	if err := writeBytes(base+".info", info); err != nil {
+		return err
+	}
+	return m.updateList(dir)
+}
+
+// AddDownloadCache merges a zip of a module download cache (the
+// $GOMODCACHE/cache/download directory) into the mirror
+func (m *Mirror) AddDownloadCache(zr *zip.Reader) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	dirs := map[string]bool{}
+	files := 0
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(f.Name, "cache/download/")
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, "sumdb/") {
+			continue
+		}
+		escapedPath, file, ok := strings.Cut(name, "/@v/")
+		if !ok || strings.Contains(file, "/") {
+			continue
+		}
+		modPath, err := unescape(escapedPath)
+		if err != nil {
+			return files, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if file == "list" {
+			// lists are rebuilt from the versions present in the mirror
+			continue
+		}
+		ext := path.Ext(file)
+		if ext != ".zip" && ext != ".mod" && ext != ".info" {
+			continue
+		}
+		version, err := unescape(strings.TrimSuffix(file, ext))
+		if err != nil {
+			return files, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if err := checkModule(modPath, version); err != nil {
+			return files, err
+		}
+		dir, err := m.versionDir(modPath)
+		if err != nil {
+			return files, err
+		}
+		err = writeFile(filepath.Join(dir, escape(version)+ext), func(w io.Writer) error {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			_, err = io.Copy(w, rc)
+			return err
+		})
+		if err != nil {
+			return files, err
+		}
+		dirs[dir] = true
+		files++
+	}
+	for dir := range dirs {
+		if err := m.updateList(dir); err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
+
+// Modules returns the modules in the mirror with the versions that can be downloaded
+func (m *Mirror) Modules() ([]Module, error) {
+	var out []Module
+	err := filepath.WalkDir(m.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || d.Name() != "@v" {
+			return err
+		}
+		rel, err := filepath.Rel(m.dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		modPath, err := unescape(filepath.ToSlash(rel))
+		if err != nil {
+			return nil
+		}
+		versions, err := listVersions(p)
+		if err != nil {
+			return err
+		}
+		if len(versions) > 0 {
+			out = append(out, Module{Path: modPath, Versions: versions})
+		}
+		return fs.SkipDir
+	})
+	slices.SortFunc(out, func(a, b Module) int { return strings.Compare(a.Path, b.Path) })
+	return out, err
+}
+
+func (m *Mirror) versionDir(modPath string) (string, error) {
+	dir := filepath.Join(m.dir, filepath.FromSlash(escape(modPath)), "@v")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("modmirror: %w", err)
+	}
+	return dir, nil
+}
+
+// updateList rewrites the list of versions, only versions with a zip are listed
+func (m *Mirror) updateList(dir string) error {
+	versions, err := listVersions(dir)
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, v := range versions {
+		sb.WriteString(v + "\n")
+	}
+	return writeBytes(filepath.Join(dir, "list"), []byte(sb.String()))
+}
+
+func listVersions(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".zip")
+		if !ok {
+			continue
+		}
+		if v, err := unescape(name); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func checkModule(modPath, version string) error {
+	if !validPath.MatchString(modPath) || slices.Contains(strings.Split(modPath, "/"), "..") || strings.HasPrefix(modPath, ".") {
+		return fmt.Errorf("%w: invalid module path %q", ErrInvalid, modPath)
+	}
+	if !validVersion.MatchString(version) {
+		return fmt.Errorf("%w: invalid version %q", ErrInvalid, version)
+	}
+	return nil
+}
+
+// escape implements the case encoding used by module proxies: upper case
+// letters are replaced by ! and the lower case letter
+func escape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if 'A' <= r && r <= 'Z' {
+			sb.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func unescape(s string) (string, error) {
+	var sb strings.Builder
+	bang := false
+	for _, r := range s {
+		switch {
+		case bang:
+			if r < 'a' || r > 'z' {
+				return "", fmt.Errorf("invalid escaped path %q", s)
+			}
+			sb.WriteRune(r - ('a' - 'A'))
+			bang = false
+		case r == '!':
+			bang = true
+		case 'A' <= r && r <= 'Z':
+			return "", fmt.Errorf("invalid escaped path %q", s)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	if bang {
+		return "", fmt.Errorf("invalid escaped path %q", s)
+	}
+	return sb.String(), nil
+}
+
+func writeBytes(name string, buf []byte) error {
+	return writeFile(name, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// writeFile atomically replaces name with the content written by fill
+func writeFile(name string, fill func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("modmirror: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := fill(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("modmirror: write %v: %w", filepath.Base(name), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("modmirror: %w", err)
+	}
+	os.Chmod(tmp.Name(), 0644)
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("modmirror: %w", err)
+	}
+	return nil
+}
+
+// copyZip writes the files of zr to w as a new zip archive
+func copyZip(w io.Writer, zr *zip.Reader) error {
+	zw := zip.NewWriter(w)
+	for _, f := range zr.File {
+		if err := zw.Copy(f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
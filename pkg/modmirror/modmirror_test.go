@@ -0,0 +1,115 @@
+package modmirror
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func zipOf(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestAddZip(t *testing.T) {
+	m, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddZip("example.com/Greet", "v1.0.0", zipOf(t, map[string]string{
+		"example.com/Greet@v1.0.0/go.mod":   "module example.com/Greet\n\ngo 1.21\n",
+		"example.com/Greet@v1.0.0/greet.go": "package greet\n\nfunc Hello() string { return \"hello\" }\n",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := os.ReadFile(filepath.Join(m.Dir(), "example.com", "!greet", "@v", "list"))
+	if err != nil || string(list) != "v1.0.0\n" {
+		t.Fatalf("unexpected list %q: %v", list, err)
+	}
+
+	err = m.AddZip("example.com/Greet", "v1.1.0", zipOf(t, map[string]string{"other@v1.1.0/a.go": "package a"}))
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("file outside of the module should be rejected, got %v", err)
+	}
+	if err := m.AddZip("../escape", "v1.0.0", zipOf(t, nil)); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("invalid path should be rejected, got %v", err)
+	}
+	if err := m.AddZip("example.com/greet", "latest", zipOf(t, nil)); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("invalid version should be rejected, got %v", err)
+	}
+
+	n, err := m.AddDownloadCache(zipOf(t, map[string]string{
+		"cache/download/example.com/other/@v/v0.2.0.zip":  "zip",
+		"cache/download/example.com/other/@v/v0.2.0.mod":  "module example.com/other\n",
+		"cache/download/example.com/other/@v/v0.2.0.info": `{"Version":"v0.2.0"}`,
+		"cache/download/example.com/other/@v/list":        "v0.2.0\n",
+		"cache/download/example.com/other/@v/v0.2.0.lock": "",
+		"cache/download/sumdb/sum.golang.org/lookup/x":    "",
+	}))
+	if err != nil || n != 3 {
+		t.Fatalf("unexpected result %v, %v", n, err)
+	}
+	mods, err := m.Modules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Module{
+		{Path: "example.com/Greet", Versions: []string{"v1.0.0"}},
+		{Path: "example.com/other", Versions: []string{"v0.2.0"}},
+	}
+	if !reflect.DeepEqual(mods, expected) {
+		t.Fatalf("unexpected modules %+v", mods)
+	}
+}
+
+func TestBuildOffline(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a module")
+	}
+	m, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddZip("example.com/greet", "v1.0.0", zipOf(t, map[string]string{
+		"example.com/greet@v1.0.0/go.mod":   "module example.com/greet\n\ngo 1.21\n",
+		"example.com/greet@v1.0.0/greet.go": "package greet\n\nfunc Hello() string { return \"hello\" }\n",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "go.mod"), []byte("module app\n\ngo 1.21\n\nrequire example.com/greet v1.0.0\n"), 0644)
+	os.WriteFile(filepath.Join(src, "main.go"), []byte("package main\n\nimport \"example.com/greet\"\n\nfunc main() { println(greet.Hello()) }\n"), 0644)
+	cmd := exec.Command("go", "build", "-o", filepath.Join(t.TempDir(), "app"), ".")
+	cmd.Dir = src
+	cmd.Env = append(os.Environ(),
+		"GOPROXY="+m.URL(), "GONOPROXY=none", "GOSUMDB=off", "GOTOOLCHAIN=local",
+		"GOMODCACHE="+t.TempDir(), "GOFLAGS=-mod=mod -modcacherw")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build against the mirror failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+}
@@ -0,0 +1,68 @@
+package uploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	// BuildFailure is the body of uploads rejected because go build failed
+	BuildFailure struct {
+		Command     string       `json:"command"`
+		Error       string       `json:"error"`
+		Diagnostics []Diagnostic `json:"diagnostics"`
+		Output      string       `json:"output"`
+	}
+
+	// Diagnostic is a compiler error, File is relative to the uploaded directory
+	Diagnostic struct {
+		File    string `json:"file"`
+		Line    int    `json:"line"`
+		Column  int    `json:"column,omitempty"`
+		Message string `json:"message"`
+		Package string `json:"package,omitempty"`
+	}
+)
+
+// Render formats the diagnostics like the go command does, with paths
+// under srcdir so editors and terminals can open them. The raw output is
+// used when no diagnostic was found.
+func (b BuildFailure) Render(srcdir string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v failed: %v\n", b.Command, b.Error)
+	if len(b.Diagnostics) == 0 {
+		sb.WriteString(b.Output)
+		return strings.TrimRight(sb.String(), "\n")
+	}
+	pkg := ""
+	for _, d := range b.Diagnostics {
+		if d.Package != pkg && d.Package != "" {
+			fmt.Fprintf(&sb, "# %v\n", d.Package)
+		}
+		pkg = d.Package
+		file := d.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(srcdir, filepath.FromSlash(file))
+		}
+		pos := fmt.Sprintf("%v:%v", file, d.Line)
+		if d.Column > 0 {
+			pos += fmt.Sprintf(":%v", d.Column)
+		}
+		fmt.Fprintf(&sb, "%v: %v\n", pos, strings.ReplaceAll(d.Message, "\n", "\n\t"))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// uploadFailed returns the error reported to the user when the server
+// rejected an upload
+func uploadFailed(status int, body []byte, srcdir string) error {
+	var bf BuildFailure
+	if json.Unmarshal(body, &bf) == nil && bf.Command != "" {
+		return cli.Exit(bf.Render(srcdir), 1)
+	}
+	return cli.Exit(fmt.Sprintf("upload failed: status=%d body=%s", status, string(body)), 1)
+}
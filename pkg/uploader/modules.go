@@ -0,0 +1,81 @@
+package uploader
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PackModules downloads the modules needed by the go.mod in srcdir into an
+// empty module cache and zips its download directory, the zip can be merged
+// into the module mirror of a server (PUT /_admin/modules/cache)
+func PackModules(ctx context.Context, srcdir, zipPath string) error {
+	cache, err := os.MkdirTemp("", "gofunc-modcache-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(cache)
+
+	cmd := exec.CommandContext(ctx, "go", "mod", "download", "all")
+	cmd.Dir = srcdir
+	// -modcacherw allows the temporary cache to be removed
+	cmd.Env = append(os.Environ(), "GOMODCACHE="+cache, "GOFLAGS="+strings.TrimSpace(os.Getenv("GOFLAGS")+" -modcacherw"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go mod download: %w: %s", err, out)
+	}
+
+	f, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	root := filepath.Join(cache, "cache", "download")
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = toUnixPath(rel)
+		if d.IsDir() {
+			if rel == "sumdb" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		// only the files served by a module proxy are kept
+		switch path.Ext(rel) {
+		case ".zip", ".mod", ".info":
+		default:
+			return nil
+		}
+		if !strings.Contains(rel, "/@v/") {
+			return nil
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: rel, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, src)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("zip module cache: %w", err)
+	}
+	return zw.Close()
+}
@@ -0,0 +1,141 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/andrebq/gofunc/funcs"
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	// ServerVersion is returned by GET /_admin/version
+	ServerVersion struct {
+		Version      string `json:"version"`
+		GOOS         string `json:"goos"`
+		GOARCH       string `json:"goarch"`
+		Toolchain    string `json:"toolchain,omitempty"`
+		MinGoVersion string `json:"minGoVersion"`
+	}
+)
+
+// uploadPrebuilt cross-compiles srcdir for the platform of the server and
+// uploads the binary to PUT /_admin/{name}/binary
+func uploadPrebuilt(ctx context.Context, client *http.Client, baseURL, name, srcdir string, opts Options) error {
+	serverURL, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	versionURL := *serverURL
+	versionURL.Path = path.Join(serverURL.Path, "_admin", "version")
+	resp, err := send(ctx, client, http.MethodGet, versionURL.String(), nil, "")
+	if err != nil {
+		return err
+	}
+	var sv ServerVersion
+	err = json.NewDecoder(resp.Body).Decode(&sv)
+	resp.Body.Close()
+	if err != nil {
+		return cli.Exit("invalid version response: "+err.Error(), 1)
+	}
+
+	tmp, err := os.CreateTemp("", "gofunc-prebuilt-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	origin := CollectOrigin(ctx, srcdir)
+	build := opts.Build
+	// the server cannot stamp prebuilt binaries, -X flags given by the
+	// user come last and take precedence
+	build.LDFlags = strings.TrimSpace(funcs.StampFlags("", origin.Git) + " " + build.LDFlags)
+	if err := BuildBinary(ctx, srcdir, tmp.Name(), sv.GOOS, sv.GOARCH, build); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	binaryURL := *serverURL
+	binaryURL.Path = path.Join(serverURL.Path, "_admin", name, "binary")
+	q := url.Values{}
+	if opts.Kind != "" {
+		q.Set("kind", opts.Kind)
+	}
+	origin.encode(q)
+	binaryURL.RawQuery = q.Encode()
+	resp, err = send(ctx, client, http.MethodPut, binaryURL.String(), f, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// BuildBinary builds a static binary of the module in srcdir for goos/goarch.
+// CGO is disabled unless build.CGO is "true".
+func BuildBinary(ctx context.Context, srcdir, out, goos, goarch string, build BuildOptions) error {
+	args := []string{"build", "-o", out}
+	if build.Tags != "" {
+		args = append(args, "-tags="+build.Tags)
+	}
+	if build.LDFlags != "" {
+		args = append(args, "-ldflags="+build.LDFlags)
+	}
+	if build.GCFlags != "" {
+		args = append(args, "-gcflags="+build.GCFlags)
+	}
+	if build.TrimPath == "true" {
+		args = append(args, "-trimpath")
+	}
+	pkg := build.Package
+	if pkg == "" {
+		pkg = "."
+	} else if !strings.HasPrefix(pkg, ".") {
+		pkg = "./" + pkg
+	}
+	cmd := exec.CommandContext(ctx, "go", append(args, pkg)...)
+	cmd.Dir = srcdir
+	cgo := "0"
+	if build.CGO == "true" {
+		cgo = "1"
+	}
+	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED="+cgo)
+	cmd.Env = append(cmd.Env, build.Env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go build for %v/%v failed: %w: %s", goos, goarch, err, out)
+	}
+	return nil
+}
+
+// send performs a request and fails on responses other than 2xx
+func send(ctx context.Context, client *http.Client, method, target string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, cli.Exit("failed to create request: "+err.Error(), 1)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, cli.Exit("request failed: "+err.Error(), 1)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, cli.Exit(fmt.Sprintf("request failed: status=%d body=%s", resp.StatusCode, string(body)), 1)
+	}
+	return resp, nil
+}
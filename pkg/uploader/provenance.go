@@ -0,0 +1,70 @@
+package uploader
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/andrebq/gofunc/funcs"
+)
+
+type (
+	// Origin is sent with every upload, the server records it in the
+	// provenance of the build
+	Origin struct {
+		Git funcs.GitInfo
+		// User identifies the person deploying (eg.: user@host), it is
+		// recorded as reported
+		User string
+	}
+)
+
+// CollectOrigin reads the commit, branch and dirty flag of the git
+// repository holding srcdir, they are left empty when srcdir is not part
+// of a repository or git is not installed
+func CollectOrigin(ctx context.Context, srcdir string) Origin {
+	var o Origin
+	if u, err := user.Current(); err == nil {
+		o.User = u.Username
+	}
+	if host, err := os.Hostname(); err == nil && o.User != "" {
+		o.User += "@" + host
+	}
+	git := func(args ...string) (string, bool) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = srcdir
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err == nil
+	}
+	commit, ok := git("rev-parse", "HEAD")
+	if !ok {
+		return o
+	}
+	o.Git.Commit = commit
+	// detached heads have no branch
+	o.Git.Branch, _ = git("symbolic-ref", "-q", "--short", "HEAD")
+	// only changes under srcdir make the upload dirty
+	if status, ok := git("status", "--porcelain", "--", "."); ok && status != "" {
+		o.Git.Dirty = true
+	}
+	return o
+}
+
+func (o Origin) encode(q url.Values) {
+	for name, v := range map[string]string{
+		"commit": o.Git.Commit,
+		"branch": o.Git.Branch,
+		"user":   o.User,
+	} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	if o.Git.Dirty {
+		q.Set("dirty", strconv.FormatBool(true))
+	}
+}
@@ -13,9 +13,50 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/andrebq/gofunc/pkg/tlsfiles"
 	"github.com/urfave/cli/v2"
 )
 
+type (
+	// Options controls how Upload talks to the gofunc server
+	Options struct {
+		// CAFile is a PEM bundle used to verify the server certificate,
+		// when empty the system pool is used
+		CAFile string
+		// CertFile and KeyFile hold the client certificate used to
+		// authenticate against the admin routes
+		CertFile string
+		KeyFile  string
+		// Kind overrides the function kind (service or worker) declared
+		// in the gofunc.json manifest
+		Kind string
+		// Vendor includes the dependencies of the module in the upload, the
+		// server then builds without downloading modules
+		Vendor bool
+		// Build overrides the build section of the gofunc.json manifest
+		Build BuildOptions
+		// Prebuilt builds the binary locally, for the platform of the server,
+		// and uploads it instead of the sources
+		Prebuilt bool
+	}
+
+	// BuildOptions are sent as query parameters of the upload, empty
+	// values keep the settings of the manifest
+	BuildOptions struct {
+		// Package is the main package relative to the uploaded directory
+		Package string
+		// Tags is a comma separated list of build tags
+		Tags    string
+		LDFlags string
+		GCFlags string
+		// TrimPath and CGO are "true", "false" or empty
+		TrimPath string
+		CGO      string
+		// Env holds KEY=VALUE pairs for go build
+		Env []string
+	}
+)
+
 // LoadIgnoreFile reads patterns from a file, returning empty slice on error or missing file.
 func LoadIgnoreFile(path string) []string {
 	b, err := os.ReadFile(path)
@@ -35,6 +76,19 @@ func LoadIgnoreFile(path string) []string {
 
 // Upload srcdir, which should be a go module, to a gofaas server located at gofaasBaseURL
 func Upload(ctx context.Context, gofaasBaseURL string, name string, srcdir string) error {
+	return UploadWithOptions(ctx, gofaasBaseURL, name, srcdir, Options{})
+}
+
+// UploadWithOptions works like Upload but allows the caller to configure TLS
+func UploadWithOptions(ctx context.Context, gofaasBaseURL string, name string, srcdir string, opts Options) error {
+	client, err := opts.HTTPClient()
+	if err != nil {
+		return cli.Exit("invalid tls settings: "+err.Error(), 1)
+	}
+	if opts.Prebuilt {
+		return uploadPrebuilt(ctx, client, gofaasBaseURL, name, srcdir, opts)
+	}
+
 	// Build ignore patterns
 	ga := LoadIgnoreFile(filepath.Join(srcdir, ".gofaasignore"))
 	gi := LoadIgnoreFile(filepath.Join(srcdir, ".gitignore"))
@@ -49,7 +103,14 @@ func Upload(ctx context.Context, gofaasBaseURL string, name string, srcdir strin
 	tmp.Close()
 	defer os.Remove(tmpPath)
 
-	if err := CreateZip(tmpPath, srcdir, patterns); err != nil {
+	vendorDir := ""
+	if opts.Vendor {
+		if vendorDir, err = VendorModules(ctx, srcdir); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		defer os.RemoveAll(filepath.Dir(vendorDir))
+	}
+	if err := createZip(tmpPath, srcdir, patterns, vendorDir); err != nil {
 		return fmt.Errorf("failed to create zip: %w", err)
 	}
 
@@ -65,6 +126,13 @@ func Upload(ctx context.Context, gofaasBaseURL string, name string, srcdir strin
 		return fmt.Errorf("invalid server URL: %w", err)
 	}
 	serverURL.Path = path.Join(serverURL.Path, "_admin", name, "recompile")
+	q := serverURL.Query()
+	if opts.Kind != "" {
+		q.Set("kind", opts.Kind)
+	}
+	opts.Build.encode(q)
+	CollectOrigin(ctx, srcdir).encode(q)
+	serverURL.RawQuery = q.Encode()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPut, serverURL.String(), f)
 	if err != nil {
@@ -72,7 +140,7 @@ func Upload(ctx context.Context, gofaasBaseURL string, name string, srcdir strin
 	}
 	req.Header.Set("Content-Type", "application/zip")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return cli.Exit("upload failed: "+err.Error(), 1)
 	}
@@ -80,13 +148,51 @@ func Upload(ctx context.Context, gofaasBaseURL string, name string, srcdir strin
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return cli.Exit(fmt.Sprintf("upload failed: status=%d body=%s", resp.StatusCode, string(body)), 1)
+		return uploadFailed(resp.StatusCode, body, srcdir)
 	}
 	return nil
 }
 
+func (b BuildOptions) encode(q url.Values) {
+	for name, v := range map[string]string{
+		"package":  b.Package,
+		"tags":     b.Tags,
+		"ldflags":  b.LDFlags,
+		"gcflags":  b.GCFlags,
+		"trimpath": b.TrimPath,
+		"cgo":      b.CGO,
+	} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	for _, kv := range b.Env {
+		q.Add("env", kv)
+	}
+}
+
+// HTTPClient returns a client configured with the TLS settings of o
+func (o Options) HTTPClient() (*http.Client, error) {
+	if o.CAFile == "" && o.CertFile == "" && o.KeyFile == "" {
+		return http.DefaultClient, nil
+	}
+	tlsCfg, err := tlsfiles.ClientConfig(o.CAFile, o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return &http.Client{Transport: transport}, nil
+}
+
 // CreateZip walks root and writes files to dest zipPath, skipping patterns.
 func CreateZip(zipPath, root string, patterns []string) error {
+	return createZip(zipPath, root, patterns, "")
+}
+
+// createZip works like CreateZip, when vendorDir is not empty its files
+// replace the vendor directory of root
+func createZip(zipPath, root string, patterns []string, vendorDir string) error {
 	zf, err := os.Create(zipPath)
 	if err != nil {
 		return err
@@ -95,6 +201,17 @@ func CreateZip(zipPath, root string, patterns []string) error {
 	zw := zip.NewWriter(zf)
 	defer zw.Close()
 
+	if vendorDir != "" {
+		patterns = append(patterns[:len(patterns):len(patterns)], "vendor/")
+		if err := addTree(zw, vendorDir, "vendor/", nil); err != nil {
+			return err
+		}
+	}
+	return addTree(zw, root, "", patterns)
+}
+
+// addTree writes the files under root to zw, their names start with prefix
+func addTree(zw *zip.Writer, root, prefix string, patterns []string) error {
 	rootAbs, err := filepath.Abs(root)
 	if err != nil {
 		return err
@@ -124,7 +241,7 @@ func CreateZip(zipPath, root string, patterns []string) error {
 
 		if d.IsDir() {
 			// add directory entry
-			_, err := zw.Create(relUnix + "/")
+			_, err := zw.Create(prefix + relUnix + "/")
 			return err
 		}
 
@@ -143,7 +260,7 @@ func CreateZip(zipPath, root string, patterns []string) error {
 		if err != nil {
 			return err
 		}
-		hdr.Name = relUnix
+		hdr.Name = prefix + relUnix
 		hdr.Method = zip.Deflate
 
 		w, err := zw.CreateHeader(hdr)
@@ -3,13 +3,19 @@ package uploader
 import (
 	"archive/zip"
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/andrebq/gofunc/funcs"
 	"github.com/andrebq/gofunc/server"
 )
 
@@ -92,7 +98,7 @@ func main() {
 	binDir := t.TempDir()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	h := server.NewHandler(ctx, tmpDir, binDir)
+	h := server.NewHandler(ctx, tmpDir, binDir, t.TempDir())
 	ts := httptest.NewServer(h)
 	defer ts.Close()
 
@@ -114,3 +120,156 @@ func main() {
 		t.Fatalf("unexpected status code: %d", resp.StatusCode)
 	}
 }
+
+func TestCreateZip_Vendor(t *testing.T) {
+	root := t.TempDir()
+	greet, src := filepath.Join(root, "greet"), filepath.Join(root, "app")
+	os.MkdirAll(greet, 0700)
+	os.MkdirAll(filepath.Join(src, "vendor"), 0700)
+	os.WriteFile(filepath.Join(greet, "go.mod"), []byte("module example.com/greet\n\ngo 1.24\n"), 0600)
+	os.WriteFile(filepath.Join(greet, "greet.go"), []byte("package greet\n\nfunc Hello() string { return \"hello\" }\n"), 0600)
+	os.WriteFile(filepath.Join(src, "go.mod"), []byte("module app\n\ngo 1.24\n\nrequire example.com/greet v1.0.0\n\nreplace example.com/greet => ../greet\n"), 0600)
+	os.WriteFile(filepath.Join(src, "main.go"), []byte("package main\n\nimport \"example.com/greet\"\n\nfunc main() { println(greet.Hello()) }\n"), 0600)
+	os.WriteFile(filepath.Join(src, "vendor", "stale.txt"), []byte("old"), 0600)
+	os.WriteFile(filepath.Join(src, ".gitignore"), []byte("vendor/\n*.txt\n"), 0600)
+
+	vendorDir, err := VendorModules(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(vendorDir))
+	tmp := filepath.Join(t.TempDir(), "out.zip")
+	if err := createZip(tmp, src, LoadIgnoreFile(filepath.Join(src, ".gitignore")), vendorDir); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	for _, name := range []string{"main.go", "vendor/modules.txt", "vendor/example.com/greet/greet.go"} {
+		if !contains(names, name) {
+			t.Fatalf("expected %v in zip, got %v", name, names)
+		}
+	}
+	if contains(names, "vendor/stale.txt") {
+		t.Fatalf("the local vendor directory should be replaced, got %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(src, "vendor", "modules.txt")); err == nil {
+		t.Fatal("the project should not be modified")
+	}
+}
+
+func TestUpload_Prebuilt(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("prebuilt binaries are only supported on linux servers")
+	}
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "go.mod"), []byte("module prebuilt\n\ngo 1.24\n"), 0600)
+	mainGo := `package main
+import (
+	"net/http"
+	"os"
+)
+var version = "dev"
+func main() {
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("BIND_PORT"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(version))
+	}))
+}`
+	os.WriteFile(filepath.Join(src, "main.go"), []byte(mainGo), 0600)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := httptest.NewServer(server.NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir()))
+	defer ts.Close()
+
+	opts := Options{Prebuilt: true, Build: BuildOptions{LDFlags: "-X main.version=ci"}}
+	if err := UploadWithOptions(context.Background(), ts.URL, "prebuilt", src, opts); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(ts.URL + "/prebuilt/")
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK && string(body) == "ci" {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("prebuilt function did not answer: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestUpload_Diagnostics(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "go.mod"), []byte("module broken\n\ngo 1.24\n"), 0600)
+	os.WriteFile(filepath.Join(src, "main.go"), []byte("package main\n\nfunc main() {\n\tx := 1\n}\n"), 0600)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := httptest.NewServer(server.NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir()))
+	defer ts.Close()
+
+	err := Upload(context.Background(), ts.URL, "broken", src)
+	if err == nil {
+		t.Fatal("upload should fail")
+	}
+	expected := filepath.Join(src, "main.go") + ":4:2: declared and not used: x"
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q in the error, got %v", expected, err)
+	}
+}
+
+func TestUpload_Provenance(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "go.mod"), []byte("module provenance\n\ngo 1.24\n"), 0600)
+	os.WriteFile(filepath.Join(src, "main.go"), []byte("package main\n\nfunc main() { select {} }\n"), 0600)
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "release"},
+		{"add", "."},
+		{"-c", "user.name=dev", "-c", "user.email=dev@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = src
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	os.WriteFile(filepath.Join(src, "extra.go"), []byte("package main\n"), 0600)
+	origin := CollectOrigin(context.Background(), src)
+	if len(origin.Git.Commit) != 40 || origin.Git.Branch != "release" || !origin.Git.Dirty {
+		t.Fatalf("unexpected origin %+v", origin)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := httptest.NewServer(server.NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir()))
+	defer ts.Close()
+	if err := Upload(context.Background(), ts.URL, "provenance", src); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(ts.URL + "/_admin/provenance/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var info funcs.Provenance
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Git != origin.Git || info.Deployer.User != origin.User || info.SourceDigest == "" || info.Main.Path != "provenance" {
+		t.Fatalf("unexpected provenance %+v", info)
+	}
+}
@@ -0,0 +1,33 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// VendorModules vendors the dependencies of the module in srcdir into a
+// temporary directory and returns the path of its vendor directory, callers
+// remove its parent once done. The vendor directory of srcdir is not touched
+// and replace directives pointing to local directories keep working.
+func VendorModules(ctx context.Context, srcdir string) (string, error) {
+	tmp, err := os.MkdirTemp("", "gofunc-vendor-*")
+	if err != nil {
+		return "", err
+	}
+	vendorDir := filepath.Join(tmp, "vendor")
+	cmd := exec.CommandContext(ctx, "go", "mod", "vendor", "-o", vendorDir)
+	cmd.Dir = srcdir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("go mod vendor: %w: %s", err, out)
+	}
+	// modules without dependencies have nothing to vendor
+	if err := os.MkdirAll(vendorDir, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	return vendorDir, nil
+}
@@ -0,0 +1,52 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Decode reads a definition encoded as mediaType, YAML media types
+// (eg.: application/yaml) select YAML and anything else JSON. Both accept
+// the same fields and reject unknown ones.
+func Decode(r io.Reader, mediaType string) (Definition, error) {
+	var def Definition
+	if isYAML(mediaType) {
+		// YAML is converted to JSON, so both go through the same decoding
+		js, err := yamlToJSON(r)
+		if err != nil {
+			return def, err
+		}
+		r = bytes.NewReader(js)
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&def)
+	return def, err
+}
+
+func yamlToJSON(r io.Reader) ([]byte, error) {
+	var doc any
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("yaml: %w", err)
+	}
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("yaml: %w", err)
+	}
+	return js, nil
+}
+
+// isYAML returns true for the media types used by YAML documents
+func isYAML(mediaType string) bool {
+	mt, _, _ := mime.ParseMediaType(mediaType)
+	switch mt {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type (
+	// Run is an execution of a workflow, it carries a copy of the definition
+	// so changes to the workflow do not affect the runs in progress
+	Run struct {
+		ID         string                `json:"id"`
+		Workflow   string                `json:"workflow"`
+		Definition Definition            `json:"definition"`
+		Status     Status                `json:"status"`
+		Input      json.RawMessage       `json:"input,omitempty"`
+		Output     json.RawMessage       `json:"output,omitempty"`
+		Error      string                `json:"error,omitempty"`
+		Steps      map[string]*StepState `json:"steps"`
+		Created    time.Time             `json:"created"`
+		Finished   time.Time             `json:"finished,omitzero"`
+	}
+
+	// StepState is the state of a step within a run
+	StepState struct {
+		Status     Status          `json:"status"`
+		Attempts   int             `json:"attempts,omitempty"`
+		StatusCode int             `json:"statusCode,omitempty"`
+		Output     json.RawMessage `json:"output,omitempty"`
+		Error      string          `json:"error,omitempty"`
+		Started    time.Time       `json:"started,omitzero"`
+		Finished   time.Time       `json:"finished,omitzero"`
+	}
+
+	// Status of a run or a step
+	Status string
+)
+
+const (
+	Pending   = Status("pending")
+	Running   = Status("running")
+	Succeeded = Status("succeeded")
+	Failed    = Status("failed")
+	Skipped   = Status("skipped")
+	Canceled  = Status("canceled")
+)
+
+// ErrCanceled is given to Run.Finish for runs canceled on request
+var ErrCanceled = errors.New("run canceled")
+
+// Done returns true for the final states
+func (s Status) Done() bool {
+	return s == Succeeded || s == Failed || s == Skipped || s == Canceled
+}
+
+// NewRun returns a run of d, with all steps pending
+func NewRun(id string, d Definition, input json.RawMessage) *Run {
+	r := &Run{
+		ID:         id,
+		Workflow:   d.Name,
+		Definition: d,
+		Status:     Running,
+		Input:      input,
+		Steps:      map[string]*StepState{},
+		Created:    time.Now(),
+	}
+	for _, s := range d.Steps {
+		r.Steps[s.ID] = &StepState{Status: Pending}
+	}
+	return r
+}
+
+// Reset moves the steps interrupted by a restart back to pending
+func (r *Run) Reset() {
+	for _, st := range r.Steps {
+		if st.Status == Running {
+			st.Status = Pending
+		}
+	}
+}
+
+// Advance skips the pending steps that should not run and marks the ones
+// whose dependencies are done as running, it returns the ids of the latter.
+// Steps run when at least one of their dependencies succeeded.
+func (r *Run) Advance() []string {
+	var ready []string
+	for changed := true; changed; {
+		changed = false
+		scope := r.scope()
+		for _, s := range r.Definition.Steps {
+			st := r.Steps[s.ID]
+			if st.Status != Pending {
+				continue
+			}
+			done, succeeded := true, len(s.After) == 0
+			for _, dep := range s.After {
+				switch r.Steps[dep].Status {
+				case Succeeded:
+					succeeded = true
+				case Skipped:
+				default:
+					done = false
+				}
+			}
+			if !done {
+				continue
+			}
+			if !succeeded || (s.If != nil && !s.If.Eval(scope)) {
+				st.Status = Skipped
+				st.Finished = time.Now()
+				changed = true
+				continue
+			}
+			st.Status = Running
+			ready = append(ready, s.ID)
+		}
+	}
+	return ready
+}
+
+// StepInput returns the body sent to a step: the run input for steps without
+// dependencies, the output of the dependency when there is only one and an
+// object with the outputs of the dependencies that succeeded otherwise
+func (r *Run) StepInput(id string) json.RawMessage {
+	s, _ := r.Definition.Step(id)
+	switch len(s.After) {
+	case 0:
+		return r.Input
+	case 1:
+		return r.Steps[s.After[0]].Output
+	}
+	return r.outputs(s.After)
+}
+
+// Finish computes the status of the run once no step is running, pending
+// steps are canceled. The output is the output of the last step or, when the
+// workflow ends with many steps, an object with the outputs of those steps.
+// A non-nil err aborts the run, ErrCanceled marks it as canceled.
+func (r *Run) Finish(err error) {
+	status := Succeeded
+	for _, s := range r.Definition.Steps {
+		st := r.Steps[s.ID]
+		switch st.Status {
+		case Pending, Running:
+			st.Status = Canceled
+			st.Finished = time.Now()
+			status = Failed
+		case Failed, Canceled:
+			status = Failed
+		}
+	}
+	switch {
+	case errors.Is(err, ErrCanceled):
+		status = Canceled
+		r.Error = err.Error()
+	case err != nil:
+		status = Failed
+		r.Error = err.Error()
+	case status == Failed:
+		r.Error = "step failed"
+		for _, s := range r.Definition.Steps {
+			if st := r.Steps[s.ID]; st.Status == Failed {
+				r.Error = "step " + s.ID + " failed: " + st.Error
+				break
+			}
+		}
+	}
+	r.Status = status
+	r.Finished = time.Now()
+	if status != Succeeded {
+		return
+	}
+	if last := r.lastSteps(); len(last) == 1 {
+		r.Output = r.Steps[last[0]].Output
+	} else {
+		r.Output = r.outputs(last)
+	}
+}
+
+// lastSteps returns the steps no other step depends on
+func (r *Run) lastSteps() []string {
+	used := map[string]bool{}
+	for _, s := range r.Definition.Steps {
+		for _, dep := range s.After {
+			used[dep] = true
+		}
+	}
+	var out []string
+	for _, s := range r.Definition.Steps {
+		if !used[s.ID] {
+			out = append(out, s.ID)
+		}
+	}
+	return out
+}
+
+// outputs returns an object with the outputs of the steps that succeeded
+func (r *Run) outputs(ids []string) json.RawMessage {
+	obj := map[string]json.RawMessage{}
+	for _, id := range ids {
+		if st := r.Steps[id]; st.Status == Succeeded {
+			obj[id] = st.Output
+			if len(obj[id]) == 0 {
+				obj[id] = json.RawMessage("null")
+			}
+		}
+	}
+	buf, _ := json.Marshal(obj)
+	return buf
+}
+
+func (r *Run) scope() map[string]any {
+	scope := map[string]any{}
+	decode := func(key string, raw json.RawMessage) {
+		var v any
+		if len(raw) > 0 && json.Unmarshal(raw, &v) == nil {
+			scope[key] = v
+		}
+	}
+	decode(InputRef, r.Input)
+	for id, st := range r.Steps {
+		if st.Status == Succeeded {
+			decode(id, st.Output)
+		}
+	}
+	return scope
+}
+
+// Clone returns a copy of r whose steps can be read while r changes
+func (r *Run) Clone() *Run {
+	c := *r
+	c.Steps = make(map[string]*StepState, len(r.Steps))
+	for id, st := range r.Steps {
+		cp := *st
+		c.Steps[id] = &cp
+	}
+	return &c
+}
+
+// AsOutput turns a response body into a step output, bodies that are not
+// JSON are stored as a JSON string
+func AsOutput(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return append(json.RawMessage(nil), body...)
+	}
+	buf, _ := json.Marshal(string(body))
+	return buf
+}
@@ -0,0 +1,299 @@
+// Package workflow defines workflows that chain function invocations and
+// tracks the state of their runs.
+//
+// A workflow is a list of steps forming a DAG: each step waits for the steps
+// listed in After (the previous step when After is not set) which gives
+// sequences, fan-out (many steps after the same one) and fan-in (a step after
+// many others). Steps with a condition that does not hold are skipped, along
+// with the steps that only depend on skipped steps.
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrebq/gofunc/funcs"
+)
+
+type (
+	// Definition is a named workflow
+	Definition struct {
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+		Steps       []Step `json:"steps"`
+		// Timeout fails runs that take longer than its value
+		Timeout funcs.Duration `json:"timeout,omitempty"`
+	}
+
+	// Step invokes a function, the request body is the step input and the
+	// response body is the step output
+	Step struct {
+		ID   string `json:"id"`
+		Func string `json:"func"`
+		// Method and Path (relative to /{func_name}) default to POST /
+		Method  string            `json:"method,omitempty"`
+		Path    string            `json:"path,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		// After lists the steps that must finish before this one, it
+		// defaults to the previous step, use [] to start with the run
+		After []string `json:"after"`
+		// If skips the step when the condition does not hold
+		If      *Condition     `json:"if,omitempty"`
+		Retry   Retry          `json:"retry"`
+		Timeout funcs.Duration `json:"timeout,omitempty"`
+	}
+
+	// Retry controls how failed invocations (transport errors, 5xx and 429)
+	// are retried, by default they are not
+	Retry struct {
+		MaxAttempts int `json:"maxAttempts,omitempty"`
+		// Backoff doubles after each attempt, up to MaxBackoff
+		Backoff    funcs.Duration `json:"backoff,omitempty"`
+		MaxBackoff funcs.Duration `json:"maxBackoff,omitempty"`
+	}
+
+	// Condition compares the value at Path with Value. Path starts with
+	// "input" (the run input) or the id of a step (its output) followed by
+	// object keys or array indexes, separated by dots (eg.: "order.items.0.sku").
+	Condition struct {
+		Path  string          `json:"path"`
+		Op    Op              `json:"op,omitempty"`
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+
+	// Op is a comparison operator
+	Op string
+)
+
+const (
+	// Eq is the default operator
+	Eq = Op("eq")
+	Ne = Op("ne")
+	Gt = Op("gt")
+	Ge = Op("ge")
+	Lt = Op("lt")
+	Le = Op("le")
+	// Exists holds if the path is present, Value is ignored
+	Exists = Op("exists")
+
+	// InputRef is used in condition paths to refer to the run input
+	InputRef = "input"
+
+	DefaultBackoff    = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Normalize fills the dependencies left implicit, it must be called before Validate
+func (d *Definition) Normalize() {
+	for i := range d.Steps {
+		if d.Steps[i].After != nil {
+			continue
+		}
+		d.Steps[i].After = []string{}
+		if i > 0 {
+			d.Steps[i].After = []string{d.Steps[i-1].ID}
+		}
+	}
+}
+
+// Validate checks the definition, steps can only depend on (and refer to)
+// the steps declared before them
+func (d *Definition) Validate() error {
+	if !validID.MatchString(d.Name) {
+		return fmt.Errorf("invalid workflow name %q, use lowercase letters, digits, - and _", d.Name)
+	}
+	if len(d.Steps) == 0 {
+		return errors.New("a workflow needs at least one step")
+	}
+	if d.Timeout < 0 {
+		return errors.New("timeout cannot be negative")
+	}
+	declared := map[string]bool{}
+	for _, s := range d.Steps {
+		if !validID.MatchString(s.ID) || s.ID == InputRef {
+			return fmt.Errorf("steps: invalid id %q, use lowercase letters, digits, - and _", s.ID)
+		}
+		if declared[s.ID] {
+			return fmt.Errorf("steps: duplicated id %q", s.ID)
+		}
+		if s.Func == "" {
+			return fmt.Errorf("steps: %v: func is required", s.ID)
+		}
+		if s.Path != "" && !strings.HasPrefix(s.Path, "/") {
+			return fmt.Errorf("steps: %v: path must start with /", s.ID)
+		}
+		for _, dep := range s.After {
+			if !declared[dep] {
+				return fmt.Errorf("steps: %v: after %q, which is not declared before it", s.ID, dep)
+			}
+		}
+		if s.If != nil {
+			if err := s.If.validate(declared); err != nil {
+				return fmt.Errorf("steps: %v: if: %w", s.ID, err)
+			}
+		}
+		if s.Retry.MaxAttempts < 0 || s.Retry.Backoff < 0 || s.Retry.MaxBackoff < 0 || s.Timeout < 0 {
+			return fmt.Errorf("steps: %v: retry and timeout cannot be negative", s.ID)
+		}
+		declared[s.ID] = true
+	}
+	return nil
+}
+
+// Step returns the step with the given id
+func (d *Definition) Step(id string) (Step, bool) {
+	for _, s := range d.Steps {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return Step{}, false
+}
+
+// Request returns the method and path used to invoke the step
+func (s Step) Request() (method, path string) {
+	method, path = s.Method, s.Path
+	if method == "" {
+		method = http.MethodPost
+	}
+	if path == "" {
+		path = "/"
+	}
+	return method, path
+}
+
+// Attempts returns the number of invocations before the step fails
+func (r Retry) Attempts() int {
+	return max(r.MaxAttempts, 1)
+}
+
+// Delay returns the delay before the next invocation, after attempt failed ones
+func (r Retry) Delay(attempt int) time.Duration {
+	d, hi := r.Backoff.D(), r.MaxBackoff.D()
+	if d <= 0 {
+		d = DefaultBackoff
+	}
+	if hi <= 0 {
+		hi = DefaultMaxBackoff
+	}
+	for i := 1; i < attempt && d < hi; i++ {
+		d *= 2
+	}
+	return min(d, hi)
+}
+
+func (c *Condition) validate(declared map[string]bool) error {
+	root, _, _ := strings.Cut(c.Path, ".")
+	if root != InputRef && !declared[root] {
+		return fmt.Errorf("path %q must start with input or a step declared before", c.Path)
+	}
+	switch c.Op {
+	case "", Eq, Ne, Gt, Ge, Lt, Le:
+		if len(c.Value) == 0 {
+			return errors.New("value is required")
+		}
+		var v any
+		if err := json.Unmarshal(c.Value, &v); err != nil {
+			return fmt.Errorf("invalid value: %w", err)
+		}
+	case Exists:
+	default:
+		return fmt.Errorf("unknown op %q", c.Op)
+	}
+	return nil
+}
+
+// Eval returns true if the condition holds, scope maps "input" and the ids
+// of the steps to their (decoded) data
+func (c *Condition) Eval(scope map[string]any) bool {
+	got, ok := lookup(scope, c.Path)
+	if c.Op == Exists {
+		return ok
+	}
+	if !ok {
+		return false
+	}
+	var want any
+	if json.Unmarshal(c.Value, &want) != nil {
+		return false
+	}
+	switch c.Op {
+	case "", Eq:
+		return equal(got, want)
+	case Ne:
+		return !equal(got, want)
+	}
+	cmp, ok := compare(got, want)
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case Gt:
+		return cmp > 0
+	case Ge:
+		return cmp >= 0
+	case Lt:
+		return cmp < 0
+	case Le:
+		return cmp <= 0
+	}
+	return false
+}
+
+func lookup(scope map[string]any, path string) (any, bool) {
+	var v any = scope
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func equal(a, b any) bool {
+	buf1, err1 := json.Marshal(a)
+	buf2, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(buf1) == string(buf2)
+}
+
+// compare orders numbers and strings, other types cannot be compared
+func compare(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
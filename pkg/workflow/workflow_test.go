@@ -0,0 +1,160 @@
+package workflow
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefinition_Validate(t *testing.T) {
+	for name, def := range map[string]string{
+		"name":      `{"name":"Bad Name","steps":[{"id":"a","func":"f"}]}`,
+		"no steps":  `{"name":"w"}`,
+		"dup":       `{"name":"w","steps":[{"id":"a","func":"f"},{"id":"a","func":"f"}]}`,
+		"input id":  `{"name":"w","steps":[{"id":"input","func":"f"}]}`,
+		"func":      `{"name":"w","steps":[{"id":"a"}]}`,
+		"forward":   `{"name":"w","steps":[{"id":"a","func":"f","after":["b"]},{"id":"b","func":"f","after":[]}]}`,
+		"cond path": `{"name":"w","steps":[{"id":"a","func":"f","if":{"path":"b.x","value":1}}]}`,
+		"cond op":   `{"name":"w","steps":[{"id":"a","func":"f","if":{"path":"input.x","op":"like","value":1}}]}`,
+		"cond val":  `{"name":"w","steps":[{"id":"a","func":"f","if":{"path":"input.x"}}]}`,
+	} {
+		var d Definition
+		if err := json.Unmarshal([]byte(def), &d); err != nil {
+			t.Fatal(err)
+		}
+		d.Normalize()
+		if err := d.Validate(); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	js := `{"name":"w","timeout":"1m","steps":[
+		{"id":"a","func":"f","path":"/run","headers":{"X-Tenant":"t1"},"retry":{"maxAttempts":3,"backoff":"2s"}},
+		{"id":"b","func":"g","after":[],"if":{"path":"input.total","op":"gt","value":100}}
+	]}`
+	yml := `
+name: w
+timeout: 1m
+steps:
+  - id: a
+    func: f
+    path: /run
+    headers:
+      X-Tenant: t1
+    retry:
+      maxAttempts: 3
+      backoff: 2s
+  - id: b
+    func: g
+    after: []
+    if:
+      path: input.total
+      op: gt
+      value: 100
+`
+	fromJSON, err := Decode(strings.NewReader(js), "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := Decode(strings.NewReader(yml), "application/yaml; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Fatalf("yaml and json should decode to the same definition:\n%+v\n%+v", fromYAML, fromJSON)
+	}
+	if _, err := Decode(strings.NewReader("name: w\nstage: 1\n"), "text/yaml"); err == nil {
+		t.Fatalf("unknown yaml fields should be rejected")
+	}
+}
+
+func TestRun(t *testing.T) {
+	var d Definition
+	json.Unmarshal([]byte(`{"name":"w","steps":[
+		{"id":"a","func":"f"},
+		{"id":"b","func":"f"},
+		{"id":"c","func":"f","after":["a"],"if":{"path":"a.total","op":"gt","value":100}},
+		{"id":"d","func":"f","after":["b","c"]},
+		{"id":"e","func":"f","after":["c"]}
+	]}`), &d)
+	d.Normalize()
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	run := NewRun("1", d, json.RawMessage(`{"total":10}`))
+	complete := func(id, output string) {
+		t.Helper()
+		if st := run.Steps[id]; st.Status != Running {
+			t.Fatalf("%v should be running, got %v", id, st.Status)
+		}
+		run.Steps[id].Status = Succeeded
+		run.Steps[id].Output = json.RawMessage(output)
+	}
+
+	if ready := run.Advance(); len(ready) != 1 || ready[0] != "a" {
+		t.Fatalf("unexpected steps %v", ready)
+	}
+	if string(run.StepInput("a")) != `{"total":10}` {
+		t.Fatalf("unexpected input %s", run.StepInput("a"))
+	}
+	complete("a", `{"total":10}`)
+	// b follows a, c does not pass its condition and e only depends on c
+	if ready := run.Advance(); len(ready) != 1 || ready[0] != "b" {
+		t.Fatalf("unexpected steps %v", ready)
+	}
+	if run.Steps["c"].Status != Skipped || run.Steps["e"].Status != Skipped {
+		t.Fatalf("c and e should be skipped: %v %v", run.Steps["c"].Status, run.Steps["e"].Status)
+	}
+	complete("b", `"ok"`)
+	if ready := run.Advance(); len(ready) != 1 || ready[0] != "d" {
+		t.Fatalf("unexpected steps %v", ready)
+	}
+	if string(run.StepInput("d")) != `{"b":"ok"}` {
+		t.Fatalf("unexpected fan-in input %s", run.StepInput("d"))
+	}
+	complete("d", `42`)
+	if ready := run.Advance(); len(ready) != 0 {
+		t.Fatalf("unexpected steps %v", ready)
+	}
+	run.Finish(nil)
+	if run.Status != Succeeded || string(run.Output) != `{"d":42}` {
+		t.Fatalf("unexpected result %v %s", run.Status, run.Output)
+	}
+}
+
+func TestRun_Failed(t *testing.T) {
+	d := Definition{Name: "w", Steps: []Step{{ID: "a", Func: "f"}, {ID: "b", Func: "f"}}}
+	d.Normalize()
+	run := NewRun("1", d, nil)
+	run.Advance()
+	run.Steps["a"].Status = Failed
+	run.Steps["a"].Error = "boom"
+	run.Finish(nil)
+	if run.Status != Failed || run.Error != "step a failed: boom" || run.Steps["b"].Status != Canceled {
+		t.Fatalf("unexpected result %v %q %v", run.Status, run.Error, run.Steps["b"].Status)
+	}
+}
+
+func TestCondition_Eval(t *testing.T) {
+	var scope map[string]any
+	json.Unmarshal([]byte(`{"input":{"items":[{"sku":"x1","qty":3}],"name":"bob"}}`), &scope)
+	for _, tc := range []struct {
+		cond Condition
+		want bool
+	}{
+		{Condition{Path: "input.items.0.sku", Value: json.RawMessage(`"x1"`)}, true},
+		{Condition{Path: "input.items.0.qty", Op: Ge, Value: json.RawMessage(`3`)}, true},
+		{Condition{Path: "input.items.0.qty", Op: Lt, Value: json.RawMessage(`3`)}, false},
+		{Condition{Path: "input.name", Op: Ne, Value: json.RawMessage(`"alice"`)}, true},
+		{Condition{Path: "input.name", Op: Gt, Value: json.RawMessage(`1`)}, false},
+		{Condition{Path: "input.items.1", Op: Exists}, false},
+		{Condition{Path: "input.items", Value: json.RawMessage(`[{"qty":3,"sku":"x1"}]`)}, true},
+	} {
+		if got := tc.cond.Eval(scope); got != tc.want {
+			t.Errorf("%+v: got %v", tc.cond, got)
+		}
+	}
+}
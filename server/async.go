@@ -0,0 +1,527 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrebq/gofunc/funcs"
+	"github.com/andrebq/gofunc/pkg/jsonstore"
+	"github.com/andrebq/gofunc/pkg/metrics"
+	"github.com/andrebq/maestro"
+)
+
+type (
+	// asyncQueue persists asynchronous invocations and delivers them to
+	// functions, at least once, through the local client
+	asyncQueue struct {
+		h      *handler
+		ctx    maestro.Context
+		client *http.Client
+		jobs   *jsonstore.Store
+		dead   *jsonstore.Store
+		// slots limits the number of deliveries in flight
+		slots chan struct{}
+	}
+
+	asyncJob struct {
+		ID     string      `json:"id"`
+		Func   string      `json:"func"`
+		Method string      `json:"method"`
+		Path   string      `json:"path"`
+		Header http.Header `json:"header,omitempty"`
+		Body   []byte      `json:"body,omitempty"`
+
+		// Trigger is sent to the function in Gofunc-Trigger, defaults to async
+		Trigger      string `json:"trigger,omitempty"`
+		Topic        string `json:"topic,omitempty"`
+		Subscription string `json:"subscription,omitempty"`
+
+		Status      string    `json:"status"`
+		Attempts    int       `json:"attempts"`
+		NextAttempt time.Time `json:"nextAttempt,omitzero"`
+		LastError   string    `json:"lastError,omitempty"`
+		Created     time.Time `json:"created"`
+		Finished    time.Time `json:"finished,omitzero"`
+
+		Result *asyncResult `json:"result,omitempty"`
+	}
+
+	asyncResult struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header,omitempty"`
+		Body   []byte      `json:"body,omitempty"`
+	}
+)
+
+const (
+	jobPending   = "pending"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobDead      = "dead"
+
+	// asyncWorkers is the number of jobs delivered at the same time
+	asyncWorkers = 16
+	// maxAsyncBody is used when the function does not set http.maxRequestBody
+	maxAsyncBody = 10 << 20
+	// asyncGCInterval is how often finished jobs past their retention are removed
+	asyncGCInterval = time.Minute
+)
+
+var (
+	asyncEnqueued  = metrics.Default.Counter("gofunc_async_jobs_total", "Asynchronous jobs accepted")
+	asyncSucceeded = metrics.Default.Counter("gofunc_async_deliveries_total", "Deliveries of asynchronous jobs", "outcome", "succeeded")
+	asyncFailed    = metrics.Default.Counter("gofunc_async_deliveries_total", "Deliveries of asynchronous jobs", "outcome", "failed")
+	asyncRetried   = metrics.Default.Counter("gofunc_async_deliveries_total", "Deliveries of asynchronous jobs", "outcome", "retried")
+	asyncDead      = metrics.Default.Counter("gofunc_async_deliveries_total", "Deliveries of asynchronous jobs", "outcome", "dead")
+)
+
+func newAsyncQueue(h *handler, dir string) (*asyncQueue, error) {
+	jobs, err := jsonstore.Open(filepath.Join(dir, "jobs"))
+	if err != nil {
+		return nil, err
+	}
+	dead, err := jsonstore.Open(filepath.Join(dir, "dead"))
+	if err != nil {
+		return nil, err
+	}
+	q := &asyncQueue{
+		h:      h,
+		ctx:    maestro.New(h.ctx),
+		client: h.localClient(),
+		jobs:   jobs,
+		dead:   dead,
+		slots:  make(chan struct{}, asyncWorkers),
+	}
+	if err := q.resume(); err != nil {
+		return nil, err
+	}
+	q.ctx.Spawn(q.gc)
+	return q, nil
+}
+
+// resume schedules the jobs left pending (or running) by a previous run
+func (q *asyncQueue) resume() error {
+	keys, err := q.jobs.Keys()
+	if err != nil {
+		return err
+	}
+	for _, id := range keys {
+		var job asyncJob
+		if err := q.jobs.Get(id, &job); err != nil {
+			slog.Error("Unable to load async job", "id", id, "error", err)
+			continue
+		}
+		switch job.Status {
+		case jobPending, jobRunning:
+			q.schedule(job.ID, job.NextAttempt)
+		case jobDead:
+			// the server stopped before the job was moved
+			q.jobs.Move(id, q.dead)
+		}
+	}
+	return nil
+}
+
+func (q *asyncQueue) enqueue(job *asyncJob) error {
+	job.ID = newJobID()
+	job.Status = jobPending
+	job.Created = time.Now()
+	if err := q.jobs.Put(job.ID, job); err != nil {
+		return err
+	}
+	asyncEnqueued.Inc()
+	q.schedule(job.ID, time.Time{})
+	return nil
+}
+
+// schedule delivers the job at the given time
+func (q *asyncQueue) schedule(id string, at time.Time) {
+	q.ctx.Spawn(func(ctx maestro.Context) error {
+		timer := time.NewTimer(time.Until(at))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case q.slots <- struct{}{}:
+		}
+		defer func() { <-q.slots }()
+		q.deliver(id)
+		return nil
+	})
+}
+
+func (q *asyncQueue) deliver(id string) {
+	var job asyncJob
+	if err := q.jobs.Get(id, &job); err != nil {
+		slog.Error("Unable to load async job", "id", id, "error", err)
+		return
+	}
+	if job.Status != jobPending && job.Status != jobRunning {
+		return
+	}
+	job.Status = jobRunning
+	job.Attempts++
+	if err := q.jobs.Put(id, job); err != nil {
+		slog.Error("Unable to update async job", "id", id, "error", err)
+		return
+	}
+
+	result, err := q.send(job)
+	if q.ctx.Err() != nil {
+		// the server is stopping, the attempt does not count
+		job.Attempts--
+		job.Status = jobPending
+		q.jobs.Put(id, job)
+		return
+	}
+	retry := err != nil || result.Status >= 500 || result.Status == http.StatusTooManyRequests
+	switch {
+	case errors.Is(err, errResponseTooLarge):
+		// another attempt would produce the same result
+		job.Result = nil
+		job.Status = jobFailed
+		job.LastError = "result discarded: " + err.Error()
+		job.Finished = time.Now()
+		job.NextAttempt = time.Time{}
+		asyncFailed.Inc()
+	case !retry:
+		job.Result = result
+		job.Status = jobSucceeded
+		if result.Status >= 400 {
+			job.Status = jobFailed
+			job.LastError = "function returned " + strconv.Itoa(result.Status)
+			asyncFailed.Inc()
+		} else {
+			asyncSucceeded.Inc()
+		}
+		job.Finished = time.Now()
+		job.NextAttempt = time.Time{}
+	default:
+		job.Result = result
+		if err != nil {
+			job.LastError = err.Error()
+		} else {
+			job.LastError = "function returned " + strconv.Itoa(result.Status)
+		}
+		settings := q.settings(job.Func)
+		if job.Attempts >= settings.Attempts() {
+			q.kill(job)
+			return
+		}
+		asyncRetried.Inc()
+		job.Status = jobPending
+		job.NextAttempt = time.Now().Add(settings.Backoff(job.Attempts))
+		slog.Warn("Async delivery failed, retrying", "id", id, "name", job.Func, "attempt", job.Attempts, "error", job.LastError, "next", job.NextAttempt)
+	}
+	if err := q.jobs.Put(id, job); err != nil {
+		slog.Error("Unable to update async job", "id", id, "error", err)
+		return
+	}
+	if job.Status == jobPending {
+		q.schedule(id, job.NextAttempt)
+	}
+}
+
+// kill moves a job that exhausted its attempts to the dead-letter queue
+func (q *asyncQueue) kill(job asyncJob) {
+	job.Status = jobDead
+	job.Finished = time.Now()
+	job.NextAttempt = time.Time{}
+	asyncDead.Inc()
+	slog.Error("Async job exhausted its attempts", "id", job.ID, "name", job.Func, "attempts", job.Attempts, "error", job.LastError)
+	// the job is moved as a whole, so a crash cannot leave it in both queues
+	if err := q.jobs.Put(job.ID, job); err != nil {
+		slog.Error("Unable to update async job", "id", job.ID, "error", err)
+		return
+	}
+	if err := q.jobs.Move(job.ID, q.dead); err != nil {
+		slog.Error("Unable to dead-letter async job", "id", job.ID, "error", err)
+	}
+}
+
+func (q *asyncQueue) send(job asyncJob) (*asyncResult, error) {
+	req, err := http.NewRequestWithContext(q.ctx, job.Method, "http://gofunc.local"+job.Path, bytes.NewReader(job.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = job.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	trigger := job.Trigger
+	if trigger == "" {
+		trigger = "async"
+	}
+	req.Header.Set("Gofunc-Trigger", trigger)
+	if job.Topic != "" {
+		req.Header.Set("Gofunc-Topic", job.Topic)
+		req.Header.Set("Gofunc-Subscription", job.Subscription)
+	}
+	req.Header.Set("Gofunc-Job-Id", job.ID)
+	req.Header.Set("Gofunc-Attempt", strconv.Itoa(job.Attempts))
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &asyncResult{Status: resp.StatusCode, Header: resp.Header, Body: body}, nil
+}
+
+func (q *asyncQueue) settings(funcName string) funcs.Async {
+	if fnVal, ok := q.h.funcs.Load(funcName); ok {
+		return fnVal.(*funcs.Func).Config().Async
+	}
+	return funcs.Async{}
+}
+
+// gc removes finished jobs past their retention period
+func (q *asyncQueue) gc(ctx maestro.Context) error {
+	ticker := time.NewTicker(asyncGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		keys, err := q.jobs.Keys()
+		if err != nil {
+			slog.Error("Unable to list async jobs", "error", err)
+			continue
+		}
+		for _, id := range keys {
+			var job asyncJob
+			if err := q.jobs.Get(id, &job); err != nil || job.Finished.IsZero() {
+				continue
+			}
+			if time.Since(job.Finished) > q.settings(job.Func).RetentionPeriod() {
+				q.jobs.Delete(id)
+			}
+		}
+	}
+}
+
+// replay moves a dead job back to the queue, with a fresh set of attempts
+func (q *asyncQueue) replay(id string) (*asyncJob, error) {
+	var job asyncJob
+	if err := q.dead.Get(id, &job); err != nil {
+		return nil, err
+	}
+	job.Status = jobPending
+	job.Attempts = 0
+	job.NextAttempt = time.Time{}
+	job.Finished = time.Time{}
+	if err := q.dead.Put(id, job); err != nil {
+		return nil, err
+	}
+	if err := q.dead.Move(id, q.jobs); err != nil {
+		return nil, err
+	}
+	q.schedule(id, time.Time{})
+	return &job, nil
+}
+
+func newJobID() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// enqueueAsync persists the request and returns the job id to the client
+func (h *handler) enqueueAsync(w http.ResponseWriter, r *http.Request) {
+	if h.async == nil {
+		http.Error(w, "async queue not available", http.StatusServiceUnavailable)
+		return
+	}
+	fn, ok := h.lookupFunc(w, r)
+	if !ok {
+		return
+	}
+	cfg := fn.Config()
+	if cfg.IsWorker() {
+		http.Error(w, "function is a worker and cannot be invoked", http.StatusNotFound)
+		return
+	}
+	limit := cfg.HTTP.MaxRequestBody
+	if limit <= 0 {
+		limit = maxAsyncBody
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "unable to read body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	job := &asyncJob{
+		Func:   fn.Name(),
+		Method: r.Method,
+		// the function sees the same path as a synchronous invocation
+		Path:   strings.TrimPrefix(r.URL.RequestURI(), "/_async"),
+		Header: persistedHeader(r.Header),
+		Body:   body,
+	}
+	if err := h.async.enqueue(job); err != nil {
+		slog.Error("Unable to enqueue async job", "name", fn.Name(), "error", err)
+		http.Error(w, "unable to enqueue job", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/_async/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": job.ID, "status": job.Status})
+}
+
+// persistedHeader returns the request headers stored with a job, without
+// hop-by-hop headers and credentials since jobs are written to disk
+func persistedHeader(h http.Header) http.Header {
+	header := h.Clone()
+	for _, c := range header.Values("Connection") {
+		for _, name := range strings.Split(c, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range []string{
+		"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length", "Expect",
+		"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key",
+	} {
+		header.Del(name)
+	}
+	return header
+}
+
+// getAsyncJob returns a job, including its result once delivered. Anyone
+// with the id can read it, so the request headers are left out.
+func (h *handler) getAsyncJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.loadAsyncJob(w, r)
+	if !ok {
+		return
+	}
+	job.Header = nil
+	writeJSON(w, http.StatusOK, job)
+}
+
+// getAsyncResult replays the response of the function as-is
+func (h *handler) getAsyncResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.loadAsyncJob(w, r)
+	if !ok {
+		return
+	}
+	if job.Finished.IsZero() || job.Result == nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("job is %v", job.Status), http.StatusConflict)
+		return
+	}
+	for k, v := range job.Result.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(job.Result.Status)
+	w.Write(job.Result.Body)
+}
+
+func (h *handler) loadAsyncJob(w http.ResponseWriter, r *http.Request) (*asyncJob, bool) {
+	if h.async == nil {
+		http.Error(w, "async queue not available", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	id := r.PathValue("id")
+	var job asyncJob
+	err := h.async.jobs.Get(id, &job)
+	if errors.Is(err, jsonstore.ErrNotFound) {
+		err = h.async.dead.Get(id, &job)
+	}
+	if errors.Is(err, jsonstore.ErrNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return nil, false
+	}
+	return &job, true
+}
+
+func (h *handler) listDeadJobs(w http.ResponseWriter, r *http.Request) {
+	if h.async == nil {
+		http.Error(w, "async queue not available", http.StatusServiceUnavailable)
+		return
+	}
+	funcName := r.URL.Query().Get("func")
+	keys, err := h.async.dead.Keys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jobs := []asyncJob{}
+	for _, id := range keys {
+		var job asyncJob
+		if err := h.async.dead.Get(id, &job); err != nil {
+			continue
+		}
+		if funcName != "" && job.Func != funcName {
+			continue
+		}
+		// bodies are available through GET /_admin/async/dead/{id}
+		job.Body, job.Result = nil, nil
+		jobs = append(jobs, job)
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (h *handler) getDeadJob(w http.ResponseWriter, r *http.Request) {
+	if h.async == nil {
+		http.Error(w, "async queue not available", http.StatusServiceUnavailable)
+		return
+	}
+	var job asyncJob
+	if err := h.async.dead.Get(r.PathValue("id"), &job); err != nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (h *handler) replayDeadJob(w http.ResponseWriter, r *http.Request) {
+	if h.async == nil {
+		http.Error(w, "async queue not available", http.StatusServiceUnavailable)
+		return
+	}
+	job, err := h.async.replay(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	slog.Info("Replaying async job", "id", job.ID, "name", job.Func, "addr", r.RemoteAddr)
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": job.ID, "status": job.Status})
+}
+
+func (h *handler) deleteDeadJob(w http.ResponseWriter, r *http.Request) {
+	if h.async == nil {
+		http.Error(w, "async queue not available", http.StatusServiceUnavailable)
+		return
+	}
+	if err := h.async.dead.Delete(r.PathValue("id")); err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_Async(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	// the first attempt of every job fails, /fail never succeeds
+	mainGo := `package main
+import (
+	"io"
+	"net/http"
+	"os"
+)
+func main() {
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("BIND_PORT"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jobs/fail" || r.Header.Get("Gofunc-Attempt") == "1" {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/jobs/big" {
+			w.Write(make([]byte, 2<<20))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Path", r.URL.RequestURI())
+		w.Header().Set("X-Auth", r.Header.Get("Authorization")+r.Header.Get("Cookie"))
+		w.Write([]byte("done: " + string(body)))
+	}))
+}`
+	zipPath := createTestZip(t, map[string]string{"main.go": mainGo, "go.mod": "module jobs\n\ngo 1.24\n"})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/jobs/recompile", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompile failed: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/jobs/config", strings.NewReader(`{"async":{"maxAttempts":2,"minBackoff":"10ms"}}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("config failed: %s", rec.Body.String())
+	}
+
+	enqueue := func(path string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader("payload"))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Cookie", "session=secret")
+		req.Header.Set("X-Custom", "kept")
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("enqueue failed: %d %s", rec.Code, rec.Body.String())
+		}
+		var resp map[string]string
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Header().Get("Location") != "/_async/jobs/"+resp["id"] {
+			t.Fatalf("unexpected location %q", rec.Header().Get("Location"))
+		}
+		return resp["id"]
+	}
+	waitJob := func(id, status string) asyncJob {
+		t.Helper()
+		var job asyncJob
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/_async/jobs/"+id, nil))
+			// fields left out of a response must not survive from the previous one
+			job = asyncJob{}
+			json.Unmarshal(rec.Body.Bytes(), &job)
+			if job.Status == status {
+				return job
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("job should be %v, got %+v", status, job)
+		return job
+	}
+
+	ok := enqueue("/_async/jobs/work?x=1")
+	job := waitJob(ok, jobSucceeded)
+	if job.Attempts != 2 {
+		t.Fatalf("job should succeed on the second attempt, got %v", job.Attempts)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/_async/jobs/"+ok+"/result", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "done: payload" || rec.Header().Get("X-Path") != "/jobs/work?x=1" || rec.Header().Get("X-Auth") != "" {
+		t.Fatalf("unexpected result: %d %v %s", rec.Code, rec.Header(), rec.Body.String())
+	}
+	if job.Header != nil {
+		t.Fatalf("the public job view should not include headers: %v", job.Header)
+	}
+	var stored asyncJob
+	h.async.jobs.Get(ok, &stored)
+	if stored.Header.Get("Authorization") != "" || stored.Header.Get("Cookie") != "" || stored.Header.Get("X-Custom") != "kept" {
+		t.Fatalf("credentials should not be stored: %v", stored.Header)
+	}
+
+	big := waitJob(enqueue("/_async/jobs/big"), jobFailed)
+	if big.Result != nil || !strings.Contains(big.LastError, "response larger than") {
+		t.Fatalf("oversized results should fail the job, got %+v", big)
+	}
+
+	failed := enqueue("/_async/jobs/fail")
+	waitJob(failed, jobDead)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/_admin/async/dead?func=jobs", nil))
+	var dead []asyncJob
+	json.Unmarshal(rec.Body.Bytes(), &dead)
+	if len(dead) != 1 || dead[0].ID != failed || dead[0].Attempts != 2 {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/_admin/async/dead/"+failed+"/replay", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("replay failed: %s", rec.Body.String())
+	}
+	// the job is retried from scratch and dies again
+	job = waitJob(failed, jobDead)
+	if job.Attempts != 2 {
+		t.Fatalf("replayed jobs should get a fresh set of attempts, got %v", job.Attempts)
+	}
+}
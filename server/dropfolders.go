@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/andrebq/gofunc/funcs"
+	"github.com/andrebq/gofunc/pkg/fswatch"
+	"github.com/andrebq/gofunc/pkg/metrics"
+	"github.com/andrebq/maestro"
+)
+
+type (
+	// dropWatcher sends the files written to a drop folder to a function,
+	// one file at a time
+	dropWatcher struct {
+		funcName string
+		folder   funcs.DropFolder
+		ctx      maestro.Context
+		client   *http.Client
+
+		processed, failed *metrics.Value
+	}
+)
+
+const triggerFile = "file"
+
+// syncDropFolders starts watching the drop folders declared by fn and stops
+// the ones that were removed or changed
+func (h *handler) syncDropFolders(fn *funcs.Func) {
+	h.dropLock.Lock()
+	defer h.dropLock.Unlock()
+
+	declared := map[string]funcs.DropFolder{}
+	for _, d := range fn.Config().DropFolders {
+		declared[d.ID] = d
+	}
+	for key, dw := range h.dropFolders {
+		if dw.funcName != fn.Name() {
+			continue
+		}
+		if d, ok := declared[dw.folder.ID]; ok && reflect.DeepEqual(d, dw.folder) {
+			delete(declared, d.ID)
+			continue
+		}
+		dw.ctx.Shutdown()
+		delete(h.dropFolders, key)
+	}
+	for _, d := range declared {
+		dw := &dropWatcher{
+			funcName:  fn.Name(),
+			folder:    d,
+			ctx:       maestro.New(h.ctx),
+			client:    h.localClient(),
+			processed: metrics.Default.Counter("gofunc_drop_files_total", "Files sent from drop folders", "func", fn.Name(), "folder", d.ID, "outcome", "processed"),
+			failed:    metrics.Default.Counter("gofunc_drop_files_total", "Files sent from drop folders", "func", fn.Name(), "folder", d.ID, "outcome", "failed"),
+		}
+		h.ctx.Spawn(dw.run)
+		h.dropFolders[fn.Name()+"/"+d.ID] = dw
+	}
+}
+
+func (dw *dropWatcher) run(maestro.Context) error {
+	processed, failed := dw.folder.Dirs()
+	for _, dir := range []string{dw.folder.Dir, processed, failed} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			slog.Error("Unable to create drop folder", "name", dw.funcName, "folder", dw.folder.ID, "dir", dir, "error", err)
+			return nil
+		}
+	}
+	slog.Info("Watching drop folder", "name", dw.funcName, "folder", dw.folder.ID, "dir", dw.folder.Dir)
+	w := &fswatch.Watcher{Dir: dw.folder.Dir, Interval: dw.folder.PollInterval(), Accept: dw.folder.Accepts}
+	if err := w.Run(dw.ctx, dw.deliver); err != nil {
+		slog.Error("Unable to watch drop folder", "name", dw.funcName, "folder", dw.folder.ID, "dir", dw.folder.Dir, "error", err)
+	}
+	return nil
+}
+
+// deliver sends a file to the function and moves it according to the response,
+// files are left in place when the delivery is interrupted by a shutdown
+func (dw *dropWatcher) deliver(name string) {
+	src := filepath.Join(dw.folder.Dir, name)
+	status, msg, err := dw.invoke(src, name)
+	if err != nil && dw.ctx.Err() != nil {
+		return
+	}
+	processed, failed := dw.folder.Dirs()
+	dest := processed
+	if err != nil {
+		dest = failed
+		dw.failed.Inc()
+		slog.Warn("Drop folder delivery failed", "name", dw.funcName, "folder", dw.folder.ID, "file", name, "status", status, "error", err)
+	} else {
+		dw.processed.Inc()
+	}
+	target, moveErr := moveFile(src, dest)
+	if moveErr != nil {
+		slog.Error("Unable to move file out of drop folder", "name", dw.funcName, "folder", dw.folder.ID, "file", name, "error", moveErr)
+		return
+	}
+	if err != nil {
+		// keep the reason next to the file
+		report := fmt.Sprintf("status: %v\nerror: %v\n\n%s", status, err, msg)
+		os.WriteFile(target+".error", []byte(report), 0644)
+	}
+}
+
+func (dw *dropWatcher) invoke(src, name string) (int, []byte, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+	ctx := context.Context(dw.ctx)
+	if dw.folder.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dw.folder.Timeout.D())
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://gofunc.local/"+dw.funcName+dw.folder.DeliveryPath(), f)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.ContentLength = info.Size()
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Gofunc-Trigger", triggerFile)
+	req.Header.Set("Gofunc-Drop-Folder", dw.folder.ID)
+	req.Header.Set("Gofunc-File-Name", name)
+	req.Header.Set("Gofunc-File-Size", strconv.FormatInt(info.Size(), 10))
+	req.Header.Set("Gofunc-File-Modified", info.ModTime().UTC().Format(time.RFC3339Nano))
+	resp, err := dw.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, body, fmt.Errorf("function returned status %v", resp.StatusCode)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// moveFile moves src into dir, a numeric suffix is added when a file with
+// the same name already exists there
+func moveFile(src, dir string) (string, error) {
+	base := filepath.Base(src)
+	target := filepath.Join(dir, base)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%v.%v", base, i))
+	}
+	return target, os.Rename(src, target)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_DropFolder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	// the function accepts files whose content is "ok"
+	mainGo := `package main
+import (
+	"io"
+	"net/http"
+	"os"
+)
+func main() {
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("BIND_PORT"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/importer/import" || r.Header.Get("Gofunc-File-Name") == "" || string(body) != "ok" {
+			http.Error(w, "rejected "+r.Header.Get("Gofunc-File-Name"), http.StatusUnprocessableEntity)
+		}
+	}))
+}`
+	inbox := t.TempDir()
+	manifest := fmt.Sprintf(`{"dropFolders":[{"id":"partner","dir":%q,"pattern":"*.csv","path":"/import","poll":"100ms"}]}`, inbox)
+	zipPath := createTestZip(t, map[string]string{"main.go": mainGo, "go.mod": "module importer\n\ngo 1.24\n", "gofunc.json": manifest})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/importer/recompile", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompile failed: %s", rec.Body.String())
+	}
+
+	os.WriteFile(filepath.Join(inbox, "good.csv"), []byte("ok"), 0644)
+	os.WriteFile(filepath.Join(inbox, "bad.csv"), []byte("nope"), 0644)
+	os.WriteFile(filepath.Join(inbox, "other.txt"), []byte("ok"), 0644)
+
+	waitFile := func(path string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(path); err == nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("%v was not created", path)
+	}
+	waitFile(filepath.Join(inbox, "processed", "good.csv"))
+	waitFile(filepath.Join(inbox, "failed", "bad.csv"))
+	report, _ := os.ReadFile(filepath.Join(inbox, "failed", "bad.csv.error"))
+	if !strings.Contains(string(report), "422") || !strings.Contains(string(report), "rejected bad.csv") {
+		t.Fatalf("unexpected error report %q", report)
+	}
+	if _, err := os.Stat(filepath.Join(inbox, "other.txt")); err != nil {
+		t.Fatalf("files not matching the pattern should be kept: %v", err)
+	}
+
+	// files with the same name do not overwrite the processed ones
+	os.WriteFile(filepath.Join(inbox, "good.csv"), []byte("ok"), 0644)
+	waitFile(filepath.Join(inbox, "processed", "good.csv.1"))
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andrebq/gofunc/funcs"
+	"github.com/andrebq/gofunc/pkg/cloudevents"
+	"github.com/andrebq/gofunc/pkg/metrics"
+	"github.com/andrebq/maestro"
+)
+
+type (
+	topicInfo struct {
+		Topic         string             `json:"topic"`
+		Subscriptions []subscriptionInfo `json:"subscriptions"`
+	}
+
+	subscriptionInfo struct {
+		Func string `json:"func"`
+		funcs.Subscription
+	}
+)
+
+const triggerEvent = "event"
+
+// listenInternal starts the loopback listener used by functions to reach gofunc,
+// its address is given to every function in GOFUNC_URL
+func (h *handler) listenInternal() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	h.internalURL = "http://" + ln.Addr().String()
+	srv := &http.Server{Handler: h.internal}
+	h.ctx.Spawn(func(ctx maestro.Context) error {
+		stop := context.AfterFunc(ctx, func() { srv.Close() })
+		defer stop()
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+	return nil
+}
+
+// publish accepts an event in CloudEvents binary or structured mode, attributes
+// other than type are filled by gofunc when missing
+func (h *handler) publish(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("topic")
+	if err := funcs.ValidTopic(topic); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.async == nil {
+		http.Error(w, "async queue not available", http.StatusServiceUnavailable)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAsyncBody)
+	e, err := cloudevents.ParseRequest(r)
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		http.Error(w, "event too large", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if e.SpecVersion == "" {
+		e.SpecVersion = cloudevents.SpecVersion
+	}
+	if e.ID == "" {
+		e.ID = newJobID()
+	}
+	if e.Source == "" {
+		e.Source = "/gofunc/topics/" + topic
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if err := e.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n, err := h.publishEvent(topic, e)
+	if err != nil {
+		slog.Error("Unable to publish event", "topic", topic, "id", e.ID, "error", err)
+		http.Error(w, "unable to publish event", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"id": e.ID, "topic": topic, "deliveries": n})
+}
+
+// ingest accepts CloudEvents from outside gofunc and dispatches them to the
+// subscriptions without a topic that match their type
+func (h *handler) ingest(w http.ResponseWriter, r *http.Request) {
+	if h.async == nil {
+		http.Error(w, "async queue not available", http.StatusServiceUnavailable)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAsyncBody)
+	e, err := cloudevents.ParseRequest(r)
+	if err == nil {
+		err = e.Validate()
+	}
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		http.Error(w, "event too large", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n, err := h.publishEvent("", e)
+	if err != nil {
+		slog.Error("Unable to dispatch event", "type", e.Type, "id", e.ID, "error", err)
+		http.Error(w, "unable to dispatch event", http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "no function subscribed to "+e.Type, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"id": e.ID, "type": e.Type, "deliveries": n})
+}
+
+// publishEvent enqueues one delivery per matching subscription, deliveries
+// are retried and dead-lettered like any other async job. The empty topic
+// holds the events received by the CloudEvents ingress.
+func (h *handler) publishEvent(topic string, e *cloudevents.Event) (int, error) {
+	subs := h.subscriptions(func(s funcs.Subscription) bool { return s.Topic == topic })
+	label := topic
+	switch {
+	case topic == "":
+		label = "_cloudevents"
+	case len(subs) == 0:
+		// topics come from the url, only the subscribed ones get their own series
+		label = "_other"
+	}
+	metrics.Default.Counter("gofunc_events_published_total", "Events published to a topic", "topic", label).Inc()
+	var errs []error
+	n := 0
+	for _, sub := range subs {
+		if !sub.Matches(e) {
+			continue
+		}
+		header, body, err := cloudevents.Encode(e, sub.Mode)
+		if err != nil {
+			return n, err
+		}
+		job := &asyncJob{
+			Func:         sub.Func,
+			Method:       http.MethodPost,
+			Path:         "/" + sub.Func + sub.DeliveryPath(),
+			Header:       header,
+			Body:         body,
+			Trigger:      triggerEvent,
+			Topic:        topic,
+			Subscription: sub.ID,
+		}
+		if err := h.async.enqueue(job); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+	return n, errors.Join(errs...)
+}
+
+// subscriptions returns the subscriptions accepted by match, sorted by function and id
+func (h *handler) subscriptions(match func(funcs.Subscription) bool) []subscriptionInfo {
+	var out []subscriptionInfo
+	h.funcs.Range(func(_, v any) bool {
+		fn := v.(*funcs.Func)
+		for _, s := range fn.Config().Subscriptions {
+			if match(s) {
+				out = append(out, subscriptionInfo{Func: fn.Name(), Subscription: s})
+			}
+		}
+		return true
+	})
+	slices.SortFunc(out, func(a, b subscriptionInfo) int {
+		if c := strings.Compare(a.Func, b.Func); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return out
+}
+
+func (h *handler) listTopics(w http.ResponseWriter, r *http.Request) {
+	byTopic := map[string][]subscriptionInfo{}
+	for _, s := range h.subscriptions(func(funcs.Subscription) bool { return true }) {
+		byTopic[s.Topic] = append(byTopic[s.Topic], s)
+	}
+	topics := []topicInfo{}
+	for _, name := range slices.Sorted(maps.Keys(byTopic)) {
+		topics = append(topics, topicInfo{Topic: name, Subscriptions: byTopic[name]})
+	}
+	writeJSON(w, http.StatusOK, topics)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrebq/gofunc/pkg/metrics"
+)
+
+func TestHandler_PublishSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	// the subscriber appends the events it receives to OUT
+	out := filepath.Join(t.TempDir(), "events.txt")
+	mainGo := `package main
+import (
+	"io"
+	"net/http"
+	"os"
+)
+func main() {
+	if os.Getenv("GOFUNC_URL") == "" {
+		os.Exit(1)
+	}
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("BIND_PORT"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		f, _ := os.OpenFile(os.Getenv("OUT"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		f.WriteString(r.URL.Path + " " + r.Header.Get("Content-Type") + " " + r.Header.Get("Ce-Type") + " " + string(body) + "\n")
+		f.Close()
+	}))
+}`
+	manifest := fmt.Sprintf(`{"env":{"OUT":%q},"subscriptions":[{"id":"orders","topic":"shop","path":"/orders","filter":{"type":"order.*"}},{"id":"ingress","path":"/ce","mode":"binary","filter":{"type":"com.example.*"}}]}`, out)
+	zipPath := createTestZip(t, map[string]string{"main.go": mainGo, "go.mod": "module sink\n\ngo 1.24\n", "gofunc.json": manifest})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/sink/recompile", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompile failed: %s", rec.Body.String())
+	}
+
+	publish := func(eventType string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/_events/shop", strings.NewReader(`{"total":10}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Ce-Type", eventType)
+		rec := httptest.NewRecorder()
+		h.internal.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("publish failed: %d %s", rec.Code, rec.Body.String())
+		}
+		var resp struct{ Deliveries int }
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp.Deliveries
+	}
+	if n := publish("user.created"); n != 0 {
+		t.Fatalf("filtered events should not be delivered, got %v deliveries", n)
+	}
+	if n := publish("order.created"); n != 1 {
+		t.Fatalf("expected one delivery, got %v", n)
+	}
+
+	// topics without subscriptions share a metric series
+	other := metrics.Default.Counter("gofunc_events_published_total", "Events published to a topic", "topic", "_other")
+	before := other.Get()
+	req := httptest.NewRequest("POST", "/_events/nobody-listens", strings.NewReader(`{}`))
+	req.Header.Set("Ce-Type", "order.created")
+	h.internal.ServeHTTP(httptest.NewRecorder(), req)
+	var text bytes.Buffer
+	metrics.Default.WriteText(&text)
+	if other.Get() != before+1 || strings.Contains(text.String(), "nobody-listens") {
+		t.Fatalf("unsubscribed topics should not get their own series:\n%s", text.String())
+	}
+
+	waitLines := func(n int) []string {
+		t.Helper()
+		var lines []string
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			buf, _ := os.ReadFile(out)
+			lines = strings.Split(strings.TrimSpace(string(buf)), "\n")
+			if len(buf) > 0 && len(lines) >= n {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if len(lines) != n {
+			t.Fatalf("expected %v events, got %q", n, lines)
+		}
+		return lines
+	}
+	fields := strings.SplitN(waitLines(1)[0], " ", 4)
+	path, contentType, body := fields[0], fields[1], fields[3]
+	if path != "/sink/orders" || contentType != "application/cloudevents+json" {
+		t.Fatalf("unexpected delivery %q", fields)
+	}
+	var event map[string]any
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event["type"] != "order.created" || event["source"] != "/gofunc/topics/shop" || event["specversion"] != "1.0" {
+		t.Fatalf("unexpected event %v", event)
+	}
+	if data, _ := event["data"].(map[string]any); data["total"] != float64(10) {
+		t.Fatalf("unexpected data %v", event["data"])
+	}
+
+	// events from outside gofunc are dispatched by type, here in binary mode
+	ingest := func(body string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/_cloudevents", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/cloudevents+json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := ingest(`{"specversion":"1.0","id":"1","type":"com.example.created"}`); code != http.StatusBadRequest {
+		t.Fatalf("events without source should be rejected, got %v", code)
+	}
+	if code := ingest(`{"specversion":"1.0","id":"1","source":"/test","type":"com.other.created"}`); code != http.StatusNotFound {
+		t.Fatalf("events without subscribers should be rejected, got %v", code)
+	}
+	if code := ingest(`{"specversion":"1.0","id":"1","source":"/test","type":"com.example.created","data":{"ok":true}}`); code != http.StatusAccepted {
+		t.Fatalf("ingest failed: %v", code)
+	}
+	fields = strings.SplitN(waitLines(2)[1], " ", 4)
+	if fields[0] != "/sink/ce" || fields[1] != "application/json" || fields[2] != "com.example.created" || fields[3] != `{"ok":true}` {
+		t.Fatalf("unexpected binary delivery %q", fields)
+	}
+}
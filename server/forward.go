@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/andrebq/gofunc/funcs"
+	"github.com/andrebq/gofunc/pkg/metrics"
+	"github.com/andrebq/maestro"
+)
+
+type (
+	// portForwarder owns the public listener of a function port,
+	// it outlives function restarts and redeploys
+	portForwarder struct {
+		funcName string
+		port     funcs.Port
+		ctx      maestro.Context
+
+		connections *metrics.Value
+		active      *metrics.Value
+		bytesIn     *metrics.Value
+		bytesOut    *metrics.Value
+		rejected    *metrics.Value
+	}
+
+	udpSession struct {
+		packets chan []byte
+	}
+
+	countingWriter struct {
+		w io.Writer
+		n *metrics.Value
+	}
+)
+
+const (
+	// udpSessionTimeout closes udp sessions without traffic
+	udpSessionTimeout = time.Minute
+	// udpSessionBacklog is the number of packets buffered while the function starts
+	udpSessionBacklog = 64
+	maxDatagramSize   = 64 * 1024
+	// maxUDPSessions caps the sessions of a port, each one holds a socket and
+	// a goroutine and udp sources are trivial to spoof
+	maxUDPSessions = 1024
+)
+
+// syncPorts opens listeners for the ports declared by fn and closes
+// the ones that are no longer declared
+func (h *handler) syncPorts(fn *funcs.Func) {
+	h.portsLock.Lock()
+	defer h.portsLock.Unlock()
+
+	declared := map[string]funcs.Port{}
+	for _, p := range fn.Config().Ports {
+		declared[p.Name] = p
+	}
+	for key, fwd := range h.forwarders {
+		if fwd.funcName != fn.Name() {
+			continue
+		}
+		if p, ok := declared[fwd.port.Name]; ok && p == fwd.port {
+			delete(declared, p.Name)
+			continue
+		}
+		slog.Info("Closing port", "name", fn.Name(), "port", fwd.port.Name, "listen", fwd.port.Listen)
+		fwd.ctx.Shutdown()
+		delete(h.forwarders, key)
+	}
+	for _, p := range declared {
+		fwd, err := h.startForwarder(fn.Name(), p)
+		if err != nil {
+			slog.Error("Unable to open port", "name", fn.Name(), "port", p.Name, "listen", p.Listen, "error", err)
+			continue
+		}
+		h.forwarders[fn.Name()+"/"+p.Name] = fwd
+	}
+}
+
+func (h *handler) startForwarder(funcName string, p funcs.Port) (*portForwarder, error) {
+	fwd := &portForwarder{
+		funcName:    funcName,
+		port:        p,
+		ctx:         maestro.New(h.ctx),
+		connections: metrics.Default.Counter("gofunc_port_connections_total", "Connections (or udp sessions) accepted by a function port", "func", funcName, "port", p.Name),
+		active:      metrics.Default.Gauge("gofunc_port_connections_active", "Open connections (or udp sessions) of a function port", "func", funcName, "port", p.Name),
+		bytesIn:     metrics.Default.Counter("gofunc_port_bytes_total", "Bytes forwarded by a function port", "func", funcName, "port", p.Name, "direction", "in"),
+		bytesOut:    metrics.Default.Counter("gofunc_port_bytes_total", "Bytes forwarded by a function port", "func", funcName, "port", p.Name, "direction", "out"),
+		rejected:    metrics.Default.Counter("gofunc_port_rejected_total", "Packets dropped because a function port reached its udp session limit", "func", funcName, "port", p.Name),
+	}
+	switch p.Protocol {
+	case "udp":
+		pc, err := net.ListenPacket("udp", p.Listen)
+		if err != nil {
+			return nil, err
+		}
+		h.ctx.Spawn(func(maestro.Context) error { return fwd.serveUDP(h, pc) })
+	default:
+		ln, err := net.Listen("tcp", p.Listen)
+		if err != nil {
+			return nil, err
+		}
+		h.ctx.Spawn(func(maestro.Context) error { return fwd.serveTCP(h, ln) })
+	}
+	slog.Info("Forwarding port", "name", funcName, "port", p.Name, "protocol", p.Protocol, "listen", p.Listen)
+	return fwd, nil
+}
+
+func (fwd *portForwarder) lease(h *handler) (*funcs.PortLease, error) {
+	fnVal, ok := h.funcs.Load(fwd.funcName)
+	if !ok {
+		return nil, errors.New("function not found")
+	}
+	return fnVal.(*funcs.Func).AcquirePort(fwd.ctx, fwd.port.Name)
+}
+
+func (fwd *portForwarder) serveTCP(h *handler, ln net.Listener) error {
+	stop := context.AfterFunc(fwd.ctx, func() { ln.Close() })
+	defer stop()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if fwd.ctx.Err() != nil {
+				return nil
+			}
+			slog.Error("Accept failed", "name", fwd.funcName, "port", fwd.port.Name, "error", err)
+			return err
+		}
+		go fwd.forwardTCP(h, conn)
+	}
+}
+
+func (fwd *portForwarder) forwardTCP(h *handler, conn net.Conn) {
+	defer conn.Close()
+	fwd.connections.Inc()
+	fwd.active.Inc()
+	defer fwd.active.Dec()
+
+	lease, err := fwd.lease(h)
+	if err != nil {
+		slog.Warn("Dropping connection", "name", fwd.funcName, "port", fwd.port.Name, "remote", conn.RemoteAddr(), "error", err)
+		return
+	}
+	defer lease.Release()
+	backend, err := net.Dial("tcp", lease.Addr)
+	if err != nil {
+		slog.Warn("Unable to reach function port", "name", fwd.funcName, "port", fwd.port.Name, "error", err)
+		return
+	}
+	defer backend.Close()
+
+	// closing both ends unblocks the copies below
+	stopDrain := context.AfterFunc(lease.Context(), func() {
+		conn.Close()
+		backend.Close()
+	})
+	defer stopDrain()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(countingWriter{backend, fwd.bytesIn}, conn)
+		closeWrite(backend)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(countingWriter{conn, fwd.bytesOut}, backend)
+		closeWrite(conn)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+func (fwd *portForwarder) serveUDP(h *handler, pc net.PacketConn) error {
+	stop := context.AfterFunc(fwd.ctx, func() { pc.Close() })
+	defer stop()
+
+	var lock sync.Mutex
+	sessions := map[string]*udpSession{}
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if fwd.ctx.Err() != nil {
+				return nil
+			}
+			slog.Error("Read failed", "name", fwd.funcName, "port", fwd.port.Name, "error", err)
+			return err
+		}
+		fwd.bytesIn.Add(int64(n))
+		packet := append([]byte(nil), buf[:n]...)
+
+		lock.Lock()
+		s := sessions[addr.String()]
+		if s == nil && len(sessions) >= maxUDPSessions {
+			lock.Unlock()
+			fwd.rejected.Inc()
+			continue
+		}
+		if s == nil {
+			s = &udpSession{packets: make(chan []byte, udpSessionBacklog)}
+			sessions[addr.String()] = s
+			go func() {
+				fwd.forwardUDP(h, pc, addr, s)
+				lock.Lock()
+				delete(sessions, addr.String())
+				lock.Unlock()
+			}()
+		}
+		select {
+		case s.packets <- packet:
+		default:
+			// the function is not keeping up, udp allows us to drop it
+		}
+		lock.Unlock()
+	}
+}
+
+func (fwd *portForwarder) forwardUDP(h *handler, pc net.PacketConn, client net.Addr, s *udpSession) {
+	fwd.connections.Inc()
+	fwd.active.Inc()
+	defer fwd.active.Dec()
+
+	lease, err := fwd.lease(h)
+	if err != nil {
+		slog.Warn("Dropping udp session", "name", fwd.funcName, "port", fwd.port.Name, "remote", client, "error", err)
+		return
+	}
+	defer lease.Release()
+	backend, err := net.Dial("udp", lease.Addr)
+	if err != nil {
+		slog.Warn("Unable to reach function port", "name", fwd.funcName, "port", fwd.port.Name, "error", err)
+		return
+	}
+	defer backend.Close()
+
+	// replies keep the session alive as much as requests do
+	replies := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := backend.Read(buf)
+			if err != nil {
+				return
+			}
+			fwd.bytesOut.Add(int64(n))
+			pc.WriteTo(buf[:n], client)
+			select {
+			case replies <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	idle := time.NewTimer(udpSessionTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case packet := <-s.packets:
+			backend.Write(packet)
+			idle.Reset(udpSessionTimeout)
+		case <-replies:
+			idle.Reset(udpSessionTimeout)
+		case <-idle.C:
+			return
+		case <-lease.Context().Done():
+			return
+		case <-fwd.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
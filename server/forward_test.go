@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestHandler_ForwardTCP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	mainGo := `package main
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"os"
+)
+func main() {
+	ln, err := net.Listen("tcp", "127.0.0.1:"+os.Getenv("PORT_ECHO"))
+	if err == nil {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					line, _ := bufio.NewReader(conn).ReadString('\n')
+					conn.Write([]byte("echo: " + line))
+				}()
+			}
+		}()
+	}
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("BIND_PORT"), http.NotFoundHandler())
+}`
+	zipPath := createTestZip(t, map[string]string{"main.go": mainGo, "go.mod": "module echo\n\ngo 1.24\n"})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/echo/recompile", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompile failed: %s", rec.Body.String())
+	}
+
+	listen := freeAddr(t)
+	cfg := fmt.Sprintf(`{"ports":[{"name":"echo","protocol":"tcp","listen":%q}]}`, listen)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/echo/config", strings.NewReader(cfg)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("config failed: %s", rec.Body.String())
+	}
+
+	conn, err := net.DialTimeout("tcp", listen, time.Second)
+	if err != nil {
+		t.Fatalf("dial forwarded port: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	io.WriteString(conn, "hello\n")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if line != "echo: hello\n" {
+		t.Fatalf("unexpected response: %q", line)
+	}
+}
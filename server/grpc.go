@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// grpcFuncHeader can be sent as gRPC metadata to select the function
+const grpcFuncHeader = "Gofunc-Function"
+
+// routeGRPC finds the function that should receive a gRPC request.
+//
+// gRPC clients cannot easily prefix the method path, so the function is taken
+// from (in order): the Gofunc-Function metadata, a path prefix naming a
+// deployed function (which is removed), or the first label of the authority.
+func (h *handler) routeGRPC(r *http.Request) (string, *http.Request) {
+	if name := r.Header.Get(grpcFuncHeader); name != "" {
+		return name, r
+	}
+	if name := r.PathValue("func_name"); h.hasFunc(name) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = strings.TrimPrefix(r.URL.Path, "/"+name)
+		r2.URL.RawPath = ""
+		return name, r2
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, _, _ := strings.Cut(host, ".")
+	return label, r
+}
+
+func (h *handler) hasFunc(name string) bool {
+	_, ok := h.funcs.Load(name)
+	return ok
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrebq/gofunc/funcs"
+)
+
+func TestRouteGRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+	h.funcs.Store("greeter", &funcs.Func{})
+
+	req := httptest.NewRequest("POST", "/greeter/helloworld.Greeter/SayHello", nil)
+	req.SetPathValue("func_name", "greeter")
+	name, routed := h.routeGRPC(req)
+	if name != "greeter" || routed.URL.Path != "/helloworld.Greeter/SayHello" {
+		t.Fatalf("path prefix: got %q %q", name, routed.URL.Path)
+	}
+
+	req = httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+	req.SetPathValue("func_name", "helloworld.Greeter")
+	req.Host = "greeter.gofunc.local:9000"
+	name, routed = h.routeGRPC(req)
+	if name != "greeter" || routed.URL.Path != "/helloworld.Greeter/SayHello" {
+		t.Fatalf("authority: got %q %q", name, routed.URL.Path)
+	}
+
+	req.Header.Set(grpcFuncHeader, "other")
+	if name, _ = h.routeGRPC(req); name != "other" {
+		t.Fatalf("metadata: got %q", name)
+	}
+}
@@ -2,44 +2,152 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"io"
 	"os"
 
 	"github.com/andrebq/gofunc/funcs"
+	"github.com/andrebq/gofunc/pkg/metrics"
+	"github.com/andrebq/gofunc/pkg/modmirror"
 	"github.com/andrebq/maestro"
 )
 
 type (
 	handler struct {
-		m *http.ServeMux
+		m      *http.ServeMux
+		public *http.ServeMux
+		admin  *http.ServeMux
+		// internal is served on a loopback listener for the functions
+		internal    *http.ServeMux
+		internalURL string
 
 		ctx maestro.Context
 
 		funcs    sync.Map
 		funcsCtx sync.Map
+		limiters sync.Map
 
-		srcDir, binDir string
+		portsLock  sync.Mutex
+		forwarders map[string]*portForwarder
+
+		schedLock sync.Mutex
+		schedules map[string]*scheduledJob
+
+		dropLock    sync.Mutex
+		dropFolders map[string]*dropWatcher
+
+		// async is nil if the queue could not be opened
+		async *asyncQueue
+		// workflows is nil if its stores could not be opened
+		workflows *workflowEngine
+		// modules is nil if the mirror could not be opened
+		modules *modmirror.Mirror
+
+		srcDir, binDir, dataDir string
+		builder                 *funcs.Builder
 	}
 )
 
-func NewHandler(ctx context.Context, tmpDir, binDir string) *handler {
+const (
+	minRestartBackoff = 100 * time.Millisecond
+	maxRestartBackoff = 30 * time.Second
+)
+
+func NewHandler(ctx context.Context, tmpDir, binDir, dataDir string) *handler {
 	h := &handler{
 		m:      http.NewServeMux(),
-		srcDir: tmpDir,
-		binDir: binDir,
-		ctx:    maestro.New(ctx),
+		public: http.NewServeMux(),
+		admin:  http.NewServeMux(),
+
+		internal: http.NewServeMux(),
+		srcDir:   tmpDir,
+		binDir:   binDir,
+		dataDir:  dataDir,
+		builder:  &funcs.Builder{CacheDir: filepath.Join(dataDir, "builds")},
+		ctx:      maestro.New(ctx),
+
+		forwarders:  map[string]*portForwarder{},
+		schedules:   map[string]*scheduledJob{},
+		dropFolders: map[string]*dropWatcher{},
+	}
+	if m, err := modmirror.Open(filepath.Join(dataDir, "modules")); err != nil {
+		slog.Error("Unable to open the module mirror", "error", err, "dataDir", dataDir)
+	} else {
+		h.modules = m
 	}
+	if err := h.listenInternal(); err != nil {
+		slog.Error("Unable to open the internal listener, functions will not receive GOFUNC_URL", "error", err)
+	}
+	h.admin.HandleFunc("PUT /_admin/{func_name}/recompile", h.recompile)
+	h.admin.HandleFunc("PUT /_admin/{func_name}/binary", h.putBinary)
+	h.admin.HandleFunc("GET /_admin/{func_name}/info", h.getInfo)
+	h.admin.HandleFunc("GET /_admin/{func_name}/config", h.getConfig)
+	h.admin.HandleFunc("PUT /_admin/{func_name}/config", h.putConfig)
+	h.admin.HandleFunc("GET /_admin/{func_name}/schedules", h.getSchedules)
+	h.admin.HandleFunc("PUT /_admin/{func_name}/schedules", h.putSchedules)
+	h.admin.HandleFunc("POST /_admin/{func_name}/schedules/{id}/pause", h.pauseSchedule)
+	h.admin.HandleFunc("POST /_admin/{func_name}/schedules/{id}/resume", h.resumeSchedule)
+	h.admin.HandleFunc("POST /_admin/{func_name}/schedules/{id}/trigger", h.triggerSchedule)
+	h.admin.HandleFunc("GET /_admin/schedules", h.listSchedules)
+	h.admin.HandleFunc("GET /_admin/async/dead", h.listDeadJobs)
+	h.admin.HandleFunc("GET /_admin/async/dead/{id}", h.getDeadJob)
+	h.admin.HandleFunc("DELETE /_admin/async/dead/{id}", h.deleteDeadJob)
+	h.admin.HandleFunc("POST /_admin/async/dead/{id}/replay", h.replayDeadJob)
+	h.admin.HandleFunc("GET /_admin/events/topics", h.listTopics)
+	h.admin.HandleFunc("GET /_admin/workflows", h.listWorkflows)
+	h.admin.HandleFunc("POST /_admin/workflows", h.putWorkflow)
+	h.admin.HandleFunc("GET /_admin/workflows/defs/{name}", h.getWorkflow)
+	h.admin.HandleFunc("DELETE /_admin/workflows/defs/{name}", h.deleteWorkflow)
+	h.admin.HandleFunc("GET /_admin/workflows/runs", h.listWorkflowRuns)
+	h.admin.HandleFunc("POST /_admin/workflows/runs", h.startWorkflow)
+	h.admin.HandleFunc("GET /_admin/workflows/runs/{id}", h.getWorkflowRun)
+	h.admin.HandleFunc("POST /_admin/workflows/runs/{id}/cancel", h.cancelWorkflowRun)
+	h.admin.HandleFunc("GET /_admin/modules", h.listModules)
+	h.admin.HandleFunc("PUT /_admin/modules/zip", h.putModuleZip)
+	h.admin.HandleFunc("PUT /_admin/modules/cache", h.putModuleCache)
+	h.admin.HandleFunc("GET /_admin/metrics", h.metrics)
+	h.admin.HandleFunc("GET /_admin/version", h.version)
+	h.admin.HandleFunc("/_health/check", h.healthCheck)
+
+	h.public.HandleFunc("POST /_async/{func_name}/", h.enqueueAsync)
+	h.public.HandleFunc("POST /_async/{func_name}", h.enqueueAsync)
+	h.public.HandleFunc("GET /_async/jobs/{id}", h.getAsyncJob)
+	h.public.HandleFunc("GET /_async/jobs/{id}/result", h.getAsyncResult)
+	h.public.HandleFunc("POST /_cloudevents", h.ingest)
+	h.public.HandleFunc("/{func_name}/", h.invoke)
+	h.public.HandleFunc("/{func_name}", h.invoke)
+	h.public.HandleFunc("/_health/check", h.healthCheck)
+
+	// m serves both routers when a single listener is used
+	h.m.Handle("/_admin/", h.admin)
+	h.m.Handle("/", h.public)
+
+	// functions can publish events and call each other
+	h.internal.HandleFunc("POST /_events/{topic}", h.publish)
+	h.internal.Handle("/", h.public)
+
+	// drop folders and pending jobs are delivered through the public routes
 	h.loadFuncs()
-	h.m.HandleFunc("PUT /_admin/{func_name}/recompile", h.recompile)
-	h.m.HandleFunc("/{func_name}/", h.invoke)
-	h.m.HandleFunc("/{func_name}", h.invoke)
-	h.m.HandleFunc("/_health/check", h.healthCheck)
+	if q, err := newAsyncQueue(h, filepath.Join(dataDir, "async")); err != nil {
+		slog.Error("Unable to open the async queue", "error", err, "dataDir", dataDir)
+	} else {
+		h.async = q
+	}
+	if e, err := newWorkflowEngine(h, filepath.Join(dataDir, "workflows")); err != nil {
+		slog.Error("Unable to open the workflow store", "error", err, "dataDir", dataDir)
+	} else {
+		h.workflows = e
+	}
 	return h
 }
 
@@ -55,19 +163,32 @@ func (h *handler) loadFuncs() {
 }
 
 func (h *handler) registerFunc(fn *funcs.Func) error {
+	slog.Info("Registering function", "name", fn.Name(), "binfile", fn.Bin())
+	if h.internalURL != "" {
+		fn.SetHostEnv("GOFUNC_URL=" + h.internalURL)
+	}
+	// new requests are held by fn until it is ready
+	h.funcs.Store(fn.Name(), fn)
+	h.syncLimiter(fn.Name(), fn.Config().Limits)
+	h.syncPorts(fn)
+	h.syncSchedules(fn)
+	h.syncDropFolders(fn)
 	if oldCtx, _ := h.funcsCtx.Load(fn.Name()); oldCtx != nil {
+		// the previous version drains its in-flight requests in the background
 		oldCtx.(maestro.Context).Shutdown()
-		err := maestro.SyncShutdown(oldCtx.(maestro.Context), maestro.TimeoutAfter(time.Minute))
-		if err != nil {
-			return err
-		}
 	}
-	slog.Info("Registering function", "name", fn.Name(), "binfile", fn.Bin())
-	h.funcs.Store(fn.Name(), fn)
 	h.ctx.Spawn(h.runFunc(fn.Name(), fn))
 	return nil
 }
 
+// syncLimiter applies limits to the limiter of funcName, the limiter is kept
+// across config changes and redeploys so requests in flight keep counting
+func (h *handler) syncLimiter(funcName string, limits funcs.Limits) {
+	if l, loaded := h.limiters.LoadOrStore(funcName, newLimiter(funcName, limits)); loaded {
+		l.(*limiter).update(limits)
+	}
+}
+
 func (h *handler) healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
@@ -81,34 +202,72 @@ func (h *handler) recompile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing func_name", http.StatusBadRequest)
 		return
 	}
-
-	slog.Info("Recompiling function", "name", funcName, "addr", r.RemoteAddr, "forwarding", r.Header.Get("X-Forwarded-For"))
-	zipFile, err := os.CreateTemp("", "gofaas-upload-*.zip")
+	var kind funcs.Kind
+	if k := r.URL.Query().Get("kind"); k != "" {
+		var err error
+		if kind, err = funcs.ParseKind(k); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	overrides, err := buildOverrides(r.URL.Query())
 	if err != nil {
-		http.Error(w, "failed to create temp zipfile: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer func() {
-		zipFile.Close()
-		os.Remove(zipFile.Name())
-	}()
-	// Copy body to zip file
-	if _, err := io.Copy(zipFile, r.Body); err != nil {
-		http.Error(w, "failed to read zip from body: "+err.Error(), http.StatusBadRequest)
+	origin, err := uploadOrigin(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	slog.Info("Recompiling function", "name", funcName, "addr", r.RemoteAddr, "forwarding", r.Header.Get("X-Forwarded-For"))
+	upload, err := receiveUpload(w, r, h.builder.Archive.UploadLimit())
+	if err != nil {
+		writeUploadError(w, err)
 		return
 	}
-	zipFile.Close()
-	slog.Info("Uploaded zip file", "path", zipFile.Name())
+	defer os.Remove(upload)
+	slog.Info("Uploaded source", "path", upload, "format", funcs.FormatOf(upload))
 
 	// Compile
 	start := time.Now()
-	fn, err := funcs.Compile(zipFile.Name(), filepath.Join(h.srcDir, funcName), filepath.Join(h.binDir, funcName), funcName)
-	if err != nil {
+	fn, err := h.builder.CompileWith(r.Context(), upload, filepath.Join(h.srcDir, funcName), filepath.Join(h.binDir, funcName), funcName, overrides, origin)
+	var archiveErr *funcs.ArchiveError
+	var buildErr *funcs.BuildError
+	if errors.As(err, &archiveErr) {
+		status := http.StatusBadRequest
+		if archiveErr.TooLarge() {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeJSON(w, status, archiveErr)
+		return
+	} else if errors.Is(err, funcs.ErrBuildLimit) {
+		http.Error(w, "compile error: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	} else if errors.As(err, &buildErr) {
+		writeJSON(w, http.StatusBadRequest, buildErr)
+		return
+	} else if err != nil {
 		http.Error(w, "compile error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	slog.Info("Compiled function", "name", funcName, "binfile", fn.Bin(), "duration", time.Since(start))
-	err = h.registerFunc(fn)
+	slog.Info("Compiled function", "name", funcName, "binfile", fn.Bin(), "duration", time.Since(start), "commit", origin.Git.Commit)
+	h.deploy(w, funcName, fn, kind)
+}
+
+// deploy registers a freshly built or installed fn, kind (when not empty)
+// takes precedence over the manifest
+func (h *handler) deploy(w http.ResponseWriter, funcName string, fn *funcs.Func, kind funcs.Kind) {
+	if kind != "" {
+		cfg := fn.Config()
+		cfg.Kind = kind
+		if err := fn.UpdateConfig(cfg); err != nil {
+			http.Error(w, "invalid kind: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	err := h.registerFunc(fn)
 	if err != nil {
 		slog.Error("Failed to register function", "error", err)
 		http.Error(w, "failed to register function", http.StatusInternalServerError)
@@ -120,23 +279,82 @@ func (h *handler) recompile(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"ok","funcName":"` + funcName + `"}`))
 }
 
+// buildOverrides reads the build settings given in the query of an upload,
+// they take precedence over the manifest
+func buildOverrides(q url.Values) (funcs.BuildSettings, error) {
+	b := funcs.BuildSettings{
+		Package: q.Get("package"),
+		LDFlags: q.Get("ldflags"),
+		GCFlags: q.Get("gcflags"),
+	}
+	if tags := q.Get("tags"); tags != "" {
+		b.Tags = strings.Split(tags, ",")
+	}
+	for name, dst := range map[string]**bool{"trimpath": &b.TrimPath, "cgo": &b.CGO} {
+		if !q.Has(name) {
+			continue
+		}
+		v, err := strconv.ParseBool(q.Get(name))
+		if err != nil {
+			return b, fmt.Errorf("invalid %v: %w", name, err)
+		}
+		*dst = &v
+	}
+	for _, kv := range q["env"] {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return b, fmt.Errorf("invalid env %q, use KEY=VALUE", kv)
+		}
+		if b.Env == nil {
+			b.Env = map[string]string{}
+		}
+		b.Env[k] = v
+	}
+	return b, nil
+}
+
+// runFunc supervises fn, restarting it with an exponential backoff until ctx is done
 func (h *handler) runFunc(name string, fn *funcs.Func) func(ctx maestro.Context) error {
 	return func(ctx maestro.Context) error {
 		h.funcsCtx.Store(name, ctx)
 		defer func() {
-			h.funcsCtx.Delete(name)
-			h.funcs.Delete(name)
+			// a newer version might have been registered already
+			h.funcsCtx.CompareAndDelete(name, ctx)
+			h.funcs.CompareAndDelete(name, fn)
 		}()
-		err := fn.Run(ctx)
-		if err != nil {
-			println("[ERROR] ", err.Error())
+		backoff := minRestartBackoff
+		for {
+			started := time.Now()
+			errCh := make(chan error, 1)
+			// run as a child so waiting on ctx also waits for the process to exit
+			ctx.Spawn(func(ctx maestro.Context) error {
+				err := fn.Run(ctx)
+				errCh <- err
+				return err
+			})
+			err := <-errCh
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if time.Since(started) > maxRestartBackoff {
+				backoff = minRestartBackoff
+			}
+			slog.Error("Function exited, restarting", "name", name, "error", err, "backoff", backoff)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxRestartBackoff)
 		}
-		return err
 	}
 }
 
 func (h *handler) invoke(w http.ResponseWriter, r *http.Request) {
 	funcName := r.PathValue("func_name")
+	if funcs.IsGRPC(r) {
+		funcName, r = h.routeGRPC(r)
+	}
 	if funcName == "" {
 		http.Error(w, "missing func_name", http.StatusBadRequest)
 		return
@@ -153,9 +371,92 @@ func (h *handler) invoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if l, ok := h.limiters.Load(funcName); ok {
+		release, rej := l.(*limiter).acquire(r)
+		if rej != nil {
+			rej.write(w)
+			return
+		}
+		defer release()
+	}
+
 	fn.ServeHTTP(w, r)
 }
 
+func (h *handler) lookupFunc(w http.ResponseWriter, r *http.Request) (*funcs.Func, bool) {
+	funcName := r.PathValue("func_name")
+	fnVal, ok := h.funcs.Load(funcName)
+	if !ok {
+		http.Error(w, "function not found", http.StatusNotFound)
+		return nil, false
+	}
+	return fnVal.(*funcs.Func), true
+}
+
+func (h *handler) getConfig(w http.ResponseWriter, r *http.Request) {
+	fn, ok := h.lookupFunc(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, fn.Config())
+}
+
+func (h *handler) putConfig(w http.ResponseWriter, r *http.Request) {
+	fn, ok := h.lookupFunc(w, r)
+	if !ok {
+		return
+	}
+	var cfg funcs.Config
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	old := fn.Config()
+	if err := fn.UpdateConfig(cfg); err != nil {
+		http.Error(w, "unable to update config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("Updated function config", "name", fn.Name(), "addr", r.RemoteAddr)
+	if cfg.NeedsRestart(old) {
+		restarted, err := funcs.Open(fn.Bin())
+		if err != nil {
+			http.Error(w, "unable to restart function: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.registerFunc(restarted)
+	} else {
+		h.syncLimiter(fn.Name(), cfg.Limits)
+		h.syncPorts(fn)
+		h.syncSchedules(fn)
+		h.syncDropFolders(fn)
+	}
+	writeJSON(w, http.StatusOK, cfg)
+}
+
+func (h *handler) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Default.WriteText(w)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Public returns the router used for function invocations
+func (h *handler) Public() http.Handler {
+	return h.public
+}
+
+// Admin returns the router used for deploys and other admin tasks
+func (h *handler) Admin() http.Handler {
+	return h.admin
+}
+
+// ServeHTTP serves both the public and the admin routes
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.m.ServeHTTP(w, r)
 }
@@ -5,12 +5,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/andrebq/gofunc/funcs"
 )
 
 func createTestZip(t *testing.T, files map[string]string) string {
@@ -59,7 +63,7 @@ func TestHandler_RecompileAndInvoke(t *testing.T) {
 	binDir := t.TempDir()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	h := NewHandler(ctx, tmpDir, binDir)
+	h := NewHandler(ctx, tmpDir, binDir, t.TempDir())
 
 	// Create a minimal Go function as a zip
 	mainGo := `package main
@@ -113,3 +117,95 @@ func main() {
 		t.Fatalf("unexpected invoke response: %s", rec2.Body.String())
 	}
 }
+
+func TestHandler_SplitRouters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	req := httptest.NewRequest("PUT", "/_admin/testfunc/recompile", strings.NewReader("not a zip"))
+	rec := httptest.NewRecorder()
+	h.Public().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("public router should not expose admin routes, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/testfunc/", nil)
+	rec = httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("admin router should not invoke functions, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("PUT", "/_admin/testfunc/recompile", strings.NewReader("not a zip"))
+	rec = httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("admin router should handle recompile, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "admin.sock")
+	ln, err := listenUnix(path, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode()&os.ModeSocket == 0 || st.Mode().Perm() != 0660 {
+		t.Fatalf("unexpected socket mode %v", st.Mode())
+	}
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial socket: %v", err)
+	}
+	conn.Close()
+	ln.Close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("closing the listener should remove the socket, found %v", entries)
+	}
+}
+
+func TestHandler_RecompileArchiveLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+	h.builder.Archive = funcs.ArchiveLimits{MaxUpload: 1 << 20, MaxFiles: 2}
+
+	upload := func(body []byte) (int, funcs.ArchiveError) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/_admin/bomb/recompile", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/zip")
+		h.ServeHTTP(rec, req)
+		var resp funcs.ArchiveError
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("expected a json error, got %d %q", rec.Code, rec.Body.String())
+		}
+		return rec.Code, resp
+	}
+	code, resp := upload(make([]byte, 2<<20))
+	if code != http.StatusRequestEntityTooLarge || resp.Limit != "maxUpload" {
+		t.Fatalf("unexpected response %d %+v", code, resp)
+	}
+	zipPath := createTestZip(t, map[string]string{"a.go": "package main", "b.go": "package main", "c.go": "package main"})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+	code, resp = upload(zipData)
+	if code != http.StatusRequestEntityTooLarge || resp.Limit != "maxFiles" || resp.Max != 2 {
+		t.Fatalf("unexpected response %d %+v", code, resp)
+	}
+	code, resp = upload([]byte("not a zip"))
+	if code != http.StatusBadRequest || resp.Limit != "" || resp.Reason == "" {
+		t.Fatalf("unexpected response %d %+v", code, resp)
+	}
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andrebq/gofunc/funcs"
+	"github.com/andrebq/gofunc/pkg/metrics"
+)
+
+type (
+	// limiter enforces funcs.Limits for a single function, it outlives
+	// config changes so requests in flight keep counting
+	limiter struct {
+		lock   sync.Mutex
+		limits funcs.Limits
+		global *tokenBucket
+		// held and waiting count the requests holding and waiting for a
+		// concurrency slot, freed is closed when a slot is released or
+		// the limits change
+		held, waiting int
+		freed         chan struct{}
+
+		clientsLock sync.Mutex
+		clients     map[string]*list.Element
+		// recent orders the clients from the most to the least recently
+		// seen, its values are *clientBucket
+		recent *list.List
+
+		requests       *metrics.Value
+		rejectedRate   *metrics.Value
+		rejectedClient *metrics.Value
+		rejectedBusy   *metrics.Value
+		inFlight       *metrics.Value
+		queued         *metrics.Value
+	}
+
+	clientBucket struct {
+		key string
+		*tokenBucket
+	}
+
+	tokenBucket struct {
+		sync.Mutex
+		rate   float64
+		burst  float64
+		tokens float64
+		last   time.Time
+	}
+
+	rejection struct {
+		status     int
+		reason     string
+		retryAfter time.Duration
+	}
+)
+
+const (
+	// maxClientBuckets bounds the memory used to track clients, the
+	// least recently seen are dropped once the limit is reached
+	maxClientBuckets = 10000
+)
+
+func newLimiter(name string, limits funcs.Limits) *limiter {
+	l := &limiter{
+		limits:         limits,
+		clients:        map[string]*list.Element{},
+		recent:         list.New(),
+		requests:       metrics.Default.Counter("gofunc_requests_total", "Requests received by a function", "func", name),
+		rejectedRate:   metrics.Default.Counter("gofunc_rejected_total", "Requests rejected by a function limit", "func", name, "reason", "rate"),
+		rejectedClient: metrics.Default.Counter("gofunc_rejected_total", "Requests rejected by a function limit", "func", name, "reason", "client_rate"),
+		rejectedBusy:   metrics.Default.Counter("gofunc_rejected_total", "Requests rejected by a function limit", "func", name, "reason", "concurrency"),
+		inFlight:       metrics.Default.Gauge("gofunc_requests_in_flight", "Requests being served by a function", "func", name),
+		queued:         metrics.Default.Gauge("gofunc_requests_queued", "Requests waiting for a concurrency slot", "func", name),
+	}
+	if limits.Rate > 0 {
+		l.global = newTokenBucket(limits.Rate, limits.Burst)
+	}
+	return l
+}
+
+// update applies new limits, requests in flight keep their concurrency
+// slot and the token buckets keep the tokens they have
+func (l *limiter) update(limits funcs.Limits) {
+	l.lock.Lock()
+	old := l.limits
+	l.limits = limits
+	switch {
+	case limits.Rate <= 0:
+		l.global = nil
+	case l.global == nil:
+		l.global = newTokenBucket(limits.Rate, limits.Burst)
+	default:
+		l.global.resize(limits.Rate, limits.Burst)
+	}
+	// waiting requests may fit within the new limits
+	l.wake()
+	l.lock.Unlock()
+
+	l.clientsLock.Lock()
+	defer l.clientsLock.Unlock()
+	if limits.ClientRate <= 0 || limits.ClientKeyHeader != old.ClientKeyHeader {
+		clear(l.clients)
+		l.recent.Init()
+		return
+	}
+	for e := l.recent.Front(); e != nil; e = e.Next() {
+		e.Value.(*clientBucket).resize(limits.ClientRate, limits.ClientBurst)
+	}
+}
+
+// acquire checks all limits and returns a release function when the request
+// is allowed to proceed
+func (l *limiter) acquire(r *http.Request) (func(), *rejection) {
+	l.requests.Inc()
+	l.lock.Lock()
+	limits, global := l.limits, l.global
+	l.lock.Unlock()
+	if global != nil {
+		if ok, wait := global.take(); !ok {
+			l.rejectedRate.Inc()
+			return nil, &rejection{status: http.StatusTooManyRequests, reason: "rate limit exceeded", retryAfter: wait}
+		}
+	}
+	if limits.ClientRate > 0 {
+		if ok, wait := l.clientBucket(clientKey(r, limits)).take(); !ok {
+			l.rejectedClient.Inc()
+			return nil, &rejection{status: http.StatusTooManyRequests, reason: "client rate limit exceeded", retryAfter: wait}
+		}
+	}
+	if rej := l.waitSlot(r.Context()); rej != nil {
+		l.rejectedBusy.Inc()
+		return nil, rej
+	}
+	l.inFlight.Inc()
+	return func() {
+		l.inFlight.Dec()
+		l.release()
+	}, nil
+}
+
+// waitSlot takes a concurrency slot, waiting for one if the queue has room.
+// Slots are counted even without MaxConcurrency, so lowering it later still
+// accounts for the requests in flight.
+func (l *limiter) waitSlot(ctx context.Context) *rejection {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.hasSlot() {
+		l.held++
+		return nil
+	}
+	busy := &rejection{status: http.StatusServiceUnavailable, reason: "too many concurrent requests", retryAfter: time.Second}
+	if l.waiting >= l.limits.MaxQueue {
+		return busy
+	}
+	l.waiting++
+	l.queued.Inc()
+	defer func() {
+		l.waiting--
+		l.queued.Dec()
+	}()
+
+	var timeout <-chan time.Time
+	if l.limits.QueueTimeout > 0 {
+		t := time.NewTimer(l.limits.QueueTimeout.D())
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		if l.freed == nil {
+			l.freed = make(chan struct{})
+		}
+		freed := l.freed
+		l.lock.Unlock()
+		select {
+		case <-freed:
+		case <-timeout:
+			l.lock.Lock()
+			return busy
+		case <-ctx.Done():
+			l.lock.Lock()
+			return busy
+		}
+		l.lock.Lock()
+		if l.hasSlot() {
+			l.held++
+			return nil
+		}
+	}
+}
+
+func (l *limiter) hasSlot() bool {
+	return l.limits.MaxConcurrency == 0 || l.held < l.limits.MaxConcurrency
+}
+
+func (l *limiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.held--
+	l.wake()
+}
+
+// wake notifies the requests waiting for a slot, l.lock must be held
+func (l *limiter) wake() {
+	if l.freed != nil {
+		close(l.freed)
+		l.freed = nil
+	}
+}
+
+func (l *limiter) clientBucket(key string) *tokenBucket {
+	l.clientsLock.Lock()
+	defer l.clientsLock.Unlock()
+	if e := l.clients[key]; e != nil {
+		l.recent.MoveToFront(e)
+		return e.Value.(*clientBucket).tokenBucket
+	}
+	if l.recent.Len() >= maxClientBuckets {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.clients, oldest.Value.(*clientBucket).key)
+	}
+	l.lock.Lock()
+	limits := l.limits
+	l.lock.Unlock()
+	b := newTokenBucket(limits.ClientRate, limits.ClientBurst)
+	l.clients[key] = l.recent.PushFront(&clientBucket{key: key, tokenBucket: b})
+	return b
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	capacity := bucketSize(rate, burst)
+	return &tokenBucket{rate: rate, burst: capacity, tokens: capacity, last: time.Now()}
+}
+
+// bucketSize defaults the burst to the tokens added in a second
+func bucketSize(rate float64, burst int) float64 {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return float64(burst)
+}
+
+// resize changes the rate and burst, keeping the tokens left
+func (b *tokenBucket) resize(rate float64, burst int) {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	b.rate = rate
+	b.burst = bucketSize(rate, burst)
+	b.tokens = math.Min(b.tokens, b.burst)
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take consumes one token, if none is available it returns how long
+// the caller should wait before trying again
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// clientKey identifies the client by its IP address, or by the header set
+// in ClientKeyHeader when the operator opted into trusting it. Header values
+// are hashed, so long values do not inflate the buckets kept in memory.
+func clientKey(r *http.Request, limits funcs.Limits) string {
+	if limits.ClientKeyHeader != "" {
+		if v := r.Header.Get(limits.ClientKeyHeader); v != "" {
+			sum := sha256.Sum256([]byte(v))
+			return "header:" + hex.EncodeToString(sum[:])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+func (rej *rejection) write(w http.ResponseWriter) {
+	secs := int(math.Ceil(rej.retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, rej.reason, rej.status)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andrebq/gofunc/funcs"
+)
+
+func TestLimiter_Rate(t *testing.T) {
+	l := newLimiter("rate-test", funcs.Limits{Rate: 1, Burst: 2})
+	req := httptest.NewRequest("GET", "/rate-test/", nil)
+	for i := 0; i < 2; i++ {
+		release, rej := l.acquire(req)
+		if rej != nil {
+			t.Fatalf("request %d should be allowed: %v", i, rej.reason)
+		}
+		release()
+	}
+	_, rej := l.acquire(req)
+	if rej == nil || rej.status != http.StatusTooManyRequests {
+		t.Fatalf("third request should be rate limited, got %#v", rej)
+	}
+
+	rec := httptest.NewRecorder()
+	rej.write(rec)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("missing Retry-After header")
+	}
+}
+
+func TestLimiter_ClientRate(t *testing.T) {
+	l := newLimiter("client-test", funcs.Limits{ClientRate: 1, ClientBurst: 1})
+	alice := httptest.NewRequest("GET", "/client-test/", nil)
+	alice.RemoteAddr = "10.0.0.1:1234"
+	bob := httptest.NewRequest("GET", "/client-test/", nil)
+	bob.RemoteAddr = "10.0.0.2:1234"
+
+	if _, rej := l.acquire(alice); rej != nil {
+		t.Fatalf("first request from alice should be allowed")
+	}
+	alice.RemoteAddr = "10.0.0.1:4321"
+	alice.Header.Set("X-API-Key", "random")
+	if _, rej := l.acquire(alice); rej == nil {
+		t.Fatalf("second request from alice should be limited")
+	}
+	if _, rej := l.acquire(bob); rej != nil {
+		t.Fatalf("bob should not be affected by alice")
+	}
+}
+
+func TestLimiter_ClientKeyHeader(t *testing.T) {
+	l := newLimiter("header-test", funcs.Limits{ClientRate: 1, ClientBurst: 1, ClientKeyHeader: "X-API-Key"})
+	request := func(addr, key string) *http.Request {
+		r := httptest.NewRequest("GET", "/header-test/", nil)
+		r.RemoteAddr = addr
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		return r
+	}
+	if _, rej := l.acquire(request("10.0.0.1:1234", "alice")); rej != nil {
+		t.Fatalf("first request from alice should be allowed")
+	}
+	// alice is limited wherever she comes from, bob behind the same address is not
+	if _, rej := l.acquire(request("10.0.0.2:1234", "alice")); rej == nil {
+		t.Fatalf("second request from alice should be limited")
+	}
+	if _, rej := l.acquire(request("10.0.0.1:1234", "bob")); rej != nil {
+		t.Fatalf("bob should not be affected by alice")
+	}
+	// without the header, clients are keyed by their address
+	if _, rej := l.acquire(request("10.0.0.1:1234", "")); rej != nil {
+		t.Fatalf("requests without the header should use their own bucket")
+	}
+	if _, rej := l.acquire(request("10.0.0.1:4321", "")); rej == nil {
+		t.Fatalf("requests without the header should be limited by address")
+	}
+}
+
+func TestLimiter_ConcurrencyQueue(t *testing.T) {
+	l := newLimiter("queue-test", funcs.Limits{MaxConcurrency: 1, MaxQueue: 1, QueueTimeout: funcs.Duration(time.Second)})
+	req := httptest.NewRequest("GET", "/queue-test/", nil)
+
+	release, rej := l.acquire(req)
+	if rej != nil {
+		t.Fatalf("first request should be allowed")
+	}
+
+	queued := make(chan *rejection)
+	go func() {
+		release, rej := l.acquire(req)
+		if rej == nil {
+			release()
+		}
+		queued <- rej
+	}()
+
+	// wait for the second request to enter the queue
+	deadline := time.Now().Add(time.Second)
+	for l.queued.Get() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// queue is full, so the third request is rejected immediately
+	if _, rej := l.acquire(req); rej == nil || rej.status != http.StatusServiceUnavailable {
+		t.Fatalf("third request should be rejected, got %#v", rej)
+	}
+
+	release()
+	if rej := <-queued; rej != nil {
+		t.Fatalf("queued request should have been served: %v", rej.reason)
+	}
+}
+
+func TestLimiter_UpdateWhileInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+	h.syncLimiter("update-test", funcs.Limits{MaxConcurrency: 1, MaxQueue: 1, QueueTimeout: funcs.Duration(5 * time.Second)})
+	limiterOf := func() *limiter {
+		l, _ := h.limiters.Load("update-test")
+		return l.(*limiter)
+	}
+	req := httptest.NewRequest("GET", "/update-test/", nil)
+
+	release, rej := limiterOf().acquire(req)
+	if rej != nil {
+		t.Fatalf("first request should be allowed")
+	}
+	// a config change keeps counting the request in flight
+	h.syncLimiter("update-test", funcs.Limits{MaxConcurrency: 1, Rate: 100})
+	if _, rej := limiterOf().acquire(req); rej == nil || rej.status != http.StatusServiceUnavailable {
+		t.Fatalf("the request in flight should still hold its slot, got %#v", rej)
+	}
+
+	h.syncLimiter("update-test", funcs.Limits{MaxConcurrency: 1, MaxQueue: 1, QueueTimeout: funcs.Duration(5 * time.Second)})
+	queued := make(chan *rejection)
+	go func() {
+		release, rej := limiterOf().acquire(req)
+		if rej == nil {
+			release()
+		}
+		queued <- rej
+	}()
+	deadline := time.Now().Add(time.Second)
+	for limiterOf().queued.Get() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// raising the limit lets the queued request in right away
+	h.syncLimiter("update-test", funcs.Limits{MaxConcurrency: 2, MaxQueue: 1})
+	select {
+	case rej := <-queued:
+		if rej != nil {
+			t.Fatalf("queued request should have been served: %v", rej.reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued request should get the new slot")
+	}
+	release()
+	if held := limiterOf().held; held != 0 {
+		t.Fatalf("all slots should be released, %v still held", held)
+	}
+}
+
+func TestLimiter_EvictsLeastRecentClients(t *testing.T) {
+	l := newLimiter("evict-test", funcs.Limits{ClientRate: 1, ClientBurst: 1})
+	first := l.clientBucket("first")
+	for i := range maxClientBuckets {
+		if i == maxClientBuckets/2 {
+			// seen recently, so it survives
+			l.clientBucket("first")
+		}
+		l.clientBucket(strconv.Itoa(i))
+	}
+	if len(l.clients) != maxClientBuckets || l.recent.Len() != maxClientBuckets {
+		t.Fatalf("expected %v buckets, got %v", maxClientBuckets, len(l.clients))
+	}
+	if l.clientBucket("first") != first {
+		t.Fatal("recently seen clients should keep their bucket")
+	}
+	if _, ok := l.clients["0"]; ok {
+		t.Fatal("the least recently seen client should be evicted")
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type (
+	// localTransport sends requests straight to an http.Handler, it lets
+	// internal triggers invoke functions through the same path (limits,
+	// cold start, gateway errors) used by external clients
+	localTransport struct {
+		h http.Handler
+	}
+
+	// localResponse buffers the response written by the handler
+	localResponse struct {
+		status    int
+		header    http.Header
+		body      bytes.Buffer
+		truncated bool
+	}
+
+	// errReader fails every read with err
+	errReader struct {
+		err error
+	}
+)
+
+// maxLocalResponse is the amount of the response body kept by the local client,
+// reading past it fails with errResponseTooLarge
+const maxLocalResponse = 1 << 20
+
+var errResponseTooLarge = fmt.Errorf("response larger than %v bytes", maxLocalResponse)
+
+// localClient returns a client that invokes functions without going through the network
+func (h *handler) localClient() *http.Client {
+	return &http.Client{Transport: localTransport{h: h.public}}
+}
+
+func (t localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	if req.RemoteAddr == "" {
+		req.RemoteAddr = "127.0.0.1:0"
+	}
+	if req.RequestURI == "" {
+		req.RequestURI = req.URL.RequestURI()
+	}
+	rw := &localResponse{header: http.Header{}}
+	t.h.ServeHTTP(rw, req)
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	var body io.Reader = &rw.body
+	if rw.truncated {
+		// callers reading the whole body must not mistake a part for it
+		body = io.MultiReader(body, errReader{errResponseTooLarge})
+	}
+	return &http.Response{
+		Status:        http.StatusText(rw.status),
+		StatusCode:    rw.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rw.header,
+		Body:          io.NopCloser(body),
+		ContentLength: int64(rw.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+func (r *localResponse) Header() http.Header { return r.header }
+
+func (r *localResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *localResponse) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	room := maxLocalResponse - r.body.Len()
+	if len(p) > room {
+		r.truncated = true
+	}
+	if room > 0 {
+		r.body.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// Flush is a no-op, the response is only read after the handler returns
+func (r *localResponse) Flush() {}
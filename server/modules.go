@@ -0,0 +1,135 @@
+package server
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/andrebq/gofunc/pkg/modmirror"
+)
+
+type (
+	// ModulesConfig controls how builds resolve modules
+	ModulesConfig struct {
+		// Mirror selects how builds use the local module mirror: MirrorOff (the
+		// default) keeps the GOPROXY of the environment, MirrorPrefer tries the
+		// mirror first and MirrorOnly resolves modules exclusively from it
+		Mirror string
+		// SumDB and NoSumDB set GOSUMDB and GONOSUMDB for builds when not empty
+		SumDB   string
+		NoSumDB string
+	}
+)
+
+const (
+	MirrorOff    = "off"
+	MirrorPrefer = "prefer"
+	MirrorOnly   = "only"
+
+	// maxModuleUpload caps the size of module uploads
+	maxModuleUpload = 1 << 30
+	// defaultGoProxy is used by go when GOPROXY is not set
+	defaultGoProxy = "https://proxy.golang.org,direct"
+)
+
+// buildEnv returns the variables given to go commands to use the mirror at mirrorURL
+func (c ModulesConfig) buildEnv(mirrorURL string) ([]string, error) {
+	var env []string
+	switch c.Mirror {
+	case "", MirrorOff:
+	case MirrorPrefer:
+		upstream := os.Getenv("GOPROXY")
+		if upstream == "" {
+			upstream = defaultGoProxy
+		}
+		env = append(env, "GOPROXY="+mirrorURL+","+upstream)
+	case MirrorOnly:
+		// GONOPROXY=none stops GOPRIVATE from bypassing the mirror
+		env = append(env,
+			"GOPROXY="+mirrorURL,
+			"GONOPROXY=none",
+			"GOFLAGS="+strings.TrimSpace(os.Getenv("GOFLAGS")+" -mod=mod"))
+	default:
+		return nil, fmt.Errorf("unknown module mirror mode %q, use %v, %v or %v", c.Mirror, MirrorOff, MirrorPrefer, MirrorOnly)
+	}
+	if c.SumDB != "" {
+		env = append(env, "GOSUMDB="+c.SumDB)
+	}
+	if c.NoSumDB != "" {
+		env = append(env, "GONOSUMDB="+c.NoSumDB)
+	}
+	return env, nil
+}
+
+func (h *handler) listModules(w http.ResponseWriter, r *http.Request) {
+	if h.modules == nil {
+		http.Error(w, "module mirror not available", http.StatusServiceUnavailable)
+		return
+	}
+	mods, err := h.modules.Modules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if mods == nil {
+		mods = []modmirror.Module{}
+	}
+	writeJSON(w, http.StatusOK, mods)
+}
+
+// putModuleZip stores a module zip, the module and version are given in the query
+func (h *handler) putModuleZip(w http.ResponseWriter, r *http.Request) {
+	modPath, version := r.URL.Query().Get("module"), r.URL.Query().Get("version")
+	h.uploadModules(w, r, func(zr *zip.Reader) (int, error) {
+		return 1, h.modules.AddZip(modPath, version, zr)
+	})
+}
+
+// putModuleCache merges a zip of a module download cache, as produced by
+// gofunc modules push, into the mirror
+func (h *handler) putModuleCache(w http.ResponseWriter, r *http.Request) {
+	h.uploadModules(w, r, h.modules.AddDownloadCache)
+}
+
+func (h *handler) uploadModules(w http.ResponseWriter, r *http.Request, add func(*zip.Reader) (int, error)) {
+	if h.modules == nil {
+		http.Error(w, "module mirror not available", http.StatusServiceUnavailable)
+		return
+	}
+	tmp, err := os.CreateTemp("", "gofunc-modules-*.zip")
+	if err != nil {
+		http.Error(w, "unable to store upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxModuleUpload))
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "unable to read upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		http.Error(w, "invalid zip: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	n, err := add(zr)
+	if errors.Is(err, modmirror.ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "unable to store modules: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Updated module mirror", "files", n, "addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]int{"files": n})
+}
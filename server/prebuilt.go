@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/andrebq/gofunc/funcs"
+)
+
+type (
+	// serverVersion is returned by GET /_admin/version, clients use it to
+	// cross-compile prebuilt binaries
+	serverVersion struct {
+		// Version of gofunc
+		Version string `json:"version"`
+		GOOS    string `json:"goos"`
+		GOARCH  string `json:"goarch"`
+		// Toolchain is the go version used to build uploads, empty when the
+		// server has no go toolchain
+		Toolchain    string `json:"toolchain,omitempty"`
+		MinGoVersion string `json:"minGoVersion"`
+	}
+)
+
+var toolchainVersion = sync.OnceValue(func() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+})
+
+func (h *handler) version(w http.ResponseWriter, r *http.Request) {
+	v := serverVersion{
+		Version:      "(devel)",
+		GOOS:         runtime.GOOS,
+		GOARCH:       runtime.GOARCH,
+		Toolchain:    toolchainVersion(),
+		MinGoVersion: funcs.MinGoVersion,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		v.Version = bi.Main.Version
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// putBinary deploys a prebuilt binary, it is stored and registered like
+// the binaries built from sources
+func (h *handler) putBinary(w http.ResponseWriter, r *http.Request) {
+	funcName := r.PathValue("func_name")
+	var kind funcs.Kind
+	if k := r.URL.Query().Get("kind"); k != "" {
+		var err error
+		if kind, err = funcs.ParseKind(k); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	origin, err := uploadOrigin(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("Installing prebuilt binary", "name", funcName, "addr", r.RemoteAddr, "forwarding", r.Header.Get("X-Forwarded-For"))
+	// the temporary file is created next to the binary of the function,
+	// so it can be linked and concurrent uploads do not share a directory
+	bindir := filepath.Join(h.binDir, funcName)
+	if err := os.MkdirAll(bindir, 0755); err != nil {
+		http.Error(w, "failed to create bindir: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmp, err := os.CreateTemp(bindir, ".upload-*")
+	if err != nil {
+		http.Error(w, "failed to create temp file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	maxUpload := h.builder.Archive.UploadLimit()
+	if _, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxUpload)); err != nil {
+		writeUploadError(w, uploadFailed(err, maxUpload))
+		return
+	}
+	tmp.Close()
+
+	fn, info, err := h.builder.Install(r.Context(), tmp.Name(), bindir, funcName, origin)
+	if errors.Is(err, funcs.ErrInvalidBinary) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to install binary: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Installed prebuilt binary", "name", funcName, "binfile", fn.Bin(), "goVersion", info.GoVersion, "path", info.Path)
+	h.deploy(w, funcName, fn, kind)
+}
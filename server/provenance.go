@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/andrebq/gofunc/funcs"
+)
+
+// uploadOrigin reads the git state reported by the uploader and identifies
+// who is deploying, the client certificate is the only verified identity
+func uploadOrigin(r *http.Request) (funcs.Origin, error) {
+	q := r.URL.Query()
+	o := funcs.Origin{
+		Git: funcs.GitInfo{
+			Commit: q.Get("commit"),
+			Branch: q.Get("branch"),
+		},
+		Deployer: funcs.Deployer{
+			User: q.Get("user"),
+			Addr: r.RemoteAddr,
+		},
+	}
+	if q.Has("dirty") {
+		dirty, err := strconv.ParseBool(q.Get("dirty"))
+		if err != nil {
+			return o, errors.New("invalid dirty: " + err.Error())
+		}
+		o.Git.Dirty = dirty
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		o.Deployer.Certificate = r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	return o, o.Validate()
+}
+
+func (h *handler) getInfo(w http.ResponseWriter, r *http.Request) {
+	fn, ok := h.lookupFunc(w, r)
+	if !ok {
+		return
+	}
+	p, err := fn.Provenance()
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "no build information recorded, redeploy the function", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
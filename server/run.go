@@ -2,31 +2,227 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/andrebq/gofunc/funcs"
 	"github.com/andrebq/maestro"
 )
 
-func Run(ctx context.Context, addr string, port uint, baseDir string) error {
-	srcDir := filepath.Join(baseDir, "tmp")
-	binDir := filepath.Join(baseDir, "bin")
-	h := NewHandler(ctx, srcDir, binDir)
-	srv := &http.Server{
-		Addr:    net.JoinHostPort(addr, strconv.FormatUint(uint64(port), 10)),
-		Handler: h,
+type (
+	// Config holds the settings used by Run
+	Config struct {
+		Addr    string
+		Port    uint
+		BaseDir string
+
+		// AdminAddr moves the admin routes to a dedicated listener,
+		// it accepts host:port or unix:/path/to/socket.
+		// When empty, admin routes are served by the public listener.
+		AdminAddr string
+		// AdminSocketMode are the permissions applied to the admin unix socket
+		AdminSocketMode os.FileMode
+
+		TLS TLSConfig
+		// HTTP2 enables HTTP/2 on TLS listeners
+		HTTP2 bool
+		// H2C enables HTTP/2 without TLS (prior knowledge) on plain listeners,
+		// required by gRPC clients that do not use TLS
+		H2C bool
+
+		Modules ModulesConfig
+		// BuildTimeout bounds each build, funcs.DefaultBuildTimeout when zero
+		BuildTimeout time.Duration
+		// BuildLimits are applied to go build and inherited by the compilers it starts
+		BuildLimits funcs.Resources
+		// BuildParallel is the number of builds running at the same time and
+		// of compilers each of them runs
+		BuildParallel int
+		// Archive limits the archives uploaded to build functions
+		Archive funcs.ArchiveLimits
+	}
+
+	listener struct {
+		name string
+		srv  *http.Server
+		ln   net.Listener
+	}
+
+	// unixListener removes its socket, which was created under another name
+	unixListener struct {
+		net.Listener
+		path string
+	}
+)
+
+const unixPrefix = "unix:"
+
+func Run(ctx context.Context, cfg Config) error {
+	srcDir := filepath.Join(cfg.BaseDir, "tmp")
+	binDir := filepath.Join(cfg.BaseDir, "bin")
+	dataDir := filepath.Join(cfg.BaseDir, "data")
+	if _, err := cfg.Modules.buildEnv(""); err != nil {
+		return err
+	}
+	if cfg.BuildTimeout < 0 || cfg.BuildParallel < 0 || cfg.BuildLimits.MaxMemory < 0 || cfg.BuildLimits.MaxCPUTime < 0 {
+		return errors.New("build limits cannot be negative")
 	}
+	if err := cfg.Archive.Validate(); err != nil {
+		return err
+	}
+	h := NewHandler(ctx, srcDir, binDir, dataDir)
+	// the go caches are shared by all functions and survive restarts
+	h.builder.GoCache = filepath.Join(cfg.BaseDir, "cache", "go-build")
+	h.builder.GoModCache = filepath.Join(cfg.BaseDir, "cache", "gomod")
+	h.builder.Timeout = cfg.BuildTimeout
+	h.builder.Limits = cfg.BuildLimits
+	h.builder.Parallel = cfg.BuildParallel
+	h.builder.Archive = cfg.Archive
+	mirrorURL := ""
+	if h.modules != nil {
+		mirrorURL = h.modules.URL()
+	} else if cfg.Modules.Mirror != "" && cfg.Modules.Mirror != MirrorOff {
+		return errors.New("the module mirror is required but could not be opened")
+	}
+	// the mode was validated above
+	h.builder.Env, _ = cfg.Modules.buildEnv(mirrorURL)
+
+	var tlsCfg *tls.Config
+	if cfg.TLS.Enabled() {
+		var err error
+		tlsCfg, err = cfg.TLS.serverConfig()
+		if err != nil {
+			return err
+		}
+	}
+
+	publicAddr := net.JoinHostPort(cfg.Addr, strconv.FormatUint(uint64(cfg.Port), 10))
+	var listeners []*listener
+	if cfg.AdminAddr == "" {
+		public, err := cfg.listen("public", publicAddr, h, tlsCfg)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, public)
+	} else {
+		public, err := cfg.listen("public", publicAddr, h.Public(), tlsCfg)
+		if err != nil {
+			return err
+		}
+		admin, err := cfg.listen("admin", cfg.AdminAddr, h.Admin(), tlsCfg)
+		if err != nil {
+			public.ln.Close()
+			return err
+		}
+		listeners = append(listeners, public, admin)
+	}
+
 	mctx := maestro.New(ctx)
-	mctx.Spawn(func(ctx maestro.Context) error {
-		defer mctx.Shutdown()
-		slog.Info("Starting server", "address", srv.Addr, "sourceDir", srcDir, "binDir", binDir)
-		return srv.ListenAndServe()
-	})
+	for _, l := range listeners {
+		mctx.Spawn(func(ctx maestro.Context) error {
+			defer mctx.Shutdown()
+			slog.Info("Starting server", "listener", l.name, "address", l.ln.Addr().String(), "sourceDir", srcDir, "binDir", binDir, "dataDir", dataDir, "tls", tlsCfg != nil)
+			var err error
+			if tlsCfg != nil {
+				// certificates are provided by TLSConfig.GetCertificate
+				err = l.srv.ServeTLS(l.ln, "", "")
+			} else {
+				err = l.srv.Serve(l.ln)
+			}
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		})
+	}
 
 	<-mctx.Done()
-	slog.Info("Shutting down server", "address", srv.Addr)
-	return srv.Shutdown(context.TODO())
+	var errs []error
+	for _, l := range listeners {
+		slog.Info("Shutting down server", "listener", l.name, "address", l.ln.Addr().String())
+		errs = append(errs, l.srv.Shutdown(context.TODO()))
+	}
+	return errors.Join(errs...)
+}
+
+func (cfg Config) listen(name, addr string, h http.Handler, tlsCfg *tls.Config) (*listener, error) {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: h,
+	}
+	if tlsCfg == nil && cfg.H2C {
+		// gRPC clients without TLS use HTTP/2 with prior knowledge
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if tlsCfg != nil {
+		srv.TLSConfig = tlsCfg
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(cfg.HTTP2)
+		if cfg.TLS.ClientCAFile != "" {
+			srv.Handler = requireClientCert("/_admin/", h)
+		}
+	}
+
+	var ln net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		ln, err = listenUnix(path, cfg.AdminSocketMode)
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v listener: %w", name, err)
+	}
+	return &listener{name: name, srv: srv, ln: ln}, nil
+}
+
+// listenUnix creates the socket inside a private directory and moves it to
+// path once its mode is set, so it is never reachable with looser permissions
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	// remove stale sockets left by a previous run
+	if st, err := os.Lstat(path); err == nil && st.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	if mode == 0 {
+		mode = 0600
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".gofunc-socket-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed from path instead of tmp
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod socket: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("move socket: %w", err)
+	}
+	return unixListener{Listener: ln, path: path}, nil
+}
+
+func (ul unixListener) Close() error {
+	err := ul.Listener.Close()
+	os.Remove(ul.path)
+	return err
 }
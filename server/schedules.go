@@ -0,0 +1,451 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrebq/gofunc/funcs"
+	"github.com/andrebq/gofunc/pkg/cron"
+	"github.com/andrebq/gofunc/pkg/metrics"
+	"github.com/andrebq/maestro"
+)
+
+type (
+	// scheduledJob runs a single schedule of a function, its state
+	// is kept in memory and survives changes to the schedule
+	scheduledJob struct {
+		funcName string
+		sched    funcs.Schedule
+		cron     *cron.Schedule
+		loc      *time.Location
+		ctx      maestro.Context
+		// runs are not canceled when the schedule changes or is paused
+		runCtx context.Context
+		client *http.Client
+		state  *scheduleState
+
+		lock sync.Mutex
+		next time.Time
+
+		ok, failed, skipped *metrics.Value
+	}
+
+	// scheduleState is shared by the versions of a schedule, so runs that
+	// finish after the schedule changed are still recorded and still count
+	// for the overlap policy
+	scheduleState struct {
+		// slot is held by the current run when overlaps are not allowed
+		slot chan struct{}
+
+		lock    sync.Mutex
+		running int
+		queued  int
+		runs    []scheduleRun
+	}
+
+	scheduleRun struct {
+		Trigger   string    `json:"trigger"`
+		Scheduled time.Time `json:"scheduled"`
+		Started   time.Time `json:"started,omitzero"`
+		Duration  string    `json:"duration,omitempty"`
+		Status    int       `json:"status,omitempty"`
+		Outcome   string    `json:"outcome"`
+		Error     string    `json:"error,omitempty"`
+	}
+
+	scheduleStatus struct {
+		Func string `json:"func"`
+		funcs.Schedule
+		Next    *time.Time    `json:"next,omitempty"`
+		Running int           `json:"running"`
+		Queued  int           `json:"queued"`
+		Runs    []scheduleRun `json:"runs"`
+	}
+)
+
+const (
+	// maxScheduleRuns is the number of runs kept in the history of a schedule
+	maxScheduleRuns = 20
+	// maxQueuedRuns caps the ticks waiting for the previous run with the queue policy
+	maxQueuedRuns = 10
+
+	triggerSchedule = "schedule"
+	triggerManual   = "manual"
+)
+
+var errQueueFull = errors.New("too many runs queued")
+
+// syncSchedules starts the schedules declared by fn and stops the ones that
+// were removed or changed
+func (h *handler) syncSchedules(fn *funcs.Func) {
+	h.schedLock.Lock()
+	defer h.schedLock.Unlock()
+
+	declared := map[string]funcs.Schedule{}
+	for _, s := range fn.Config().Schedules {
+		declared[s.ID] = s
+	}
+	states := map[string]*scheduleState{}
+	for key, job := range h.schedules {
+		if job.funcName != fn.Name() {
+			continue
+		}
+		if s, ok := declared[job.sched.ID]; ok && reflect.DeepEqual(s, job.sched) {
+			delete(declared, s.ID)
+			continue
+		}
+		job.ctx.Shutdown()
+		delete(h.schedules, key)
+		states[job.sched.ID] = job.state
+	}
+	for _, s := range declared {
+		state := states[s.ID]
+		if state == nil {
+			state = &scheduleState{slot: make(chan struct{}, 1)}
+		}
+		job, err := h.startSchedule(fn.Name(), s, state)
+		if err != nil {
+			// the config is validated before being saved, this should not happen
+			slog.Error("Unable to start schedule", "name", fn.Name(), "schedule", s.ID, "error", err)
+			continue
+		}
+		h.schedules[fn.Name()+"/"+s.ID] = job
+	}
+}
+
+func (h *handler) startSchedule(funcName string, s funcs.Schedule, state *scheduleState) (*scheduledJob, error) {
+	c, loc, err := s.Parse()
+	if err != nil {
+		return nil, err
+	}
+	job := &scheduledJob{
+		funcName: funcName,
+		sched:    s,
+		cron:     c,
+		loc:      loc,
+		ctx:      maestro.New(h.ctx),
+		runCtx:   h.ctx,
+		client:   h.localClient(),
+		state:    state,
+		ok:       metrics.Default.Counter("gofunc_schedule_runs_total", "Runs of function schedules", "func", funcName, "schedule", s.ID, "outcome", "ok"),
+		failed:   metrics.Default.Counter("gofunc_schedule_runs_total", "Runs of function schedules", "func", funcName, "schedule", s.ID, "outcome", "failed"),
+		skipped:  metrics.Default.Counter("gofunc_schedule_runs_total", "Runs of function schedules", "func", funcName, "schedule", s.ID, "outcome", "skipped"),
+	}
+	h.ctx.Spawn(job.loop)
+	if !s.Paused {
+		slog.Info("Scheduling function", "name", funcName, "schedule", s.ID, "cron", s.Cron, "timeZone", loc.String())
+	}
+	return job, nil
+}
+
+func (j *scheduledJob) loop(maestro.Context) error {
+	if j.sched.Paused {
+		<-j.ctx.Done()
+		return nil
+	}
+	next := j.cron.Next(time.Now().In(j.loc))
+	for !next.IsZero() {
+		j.lock.Lock()
+		j.next = next
+		j.lock.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-j.ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		go j.fireLater(next)
+
+		// ticks follow the previous one, unless the clock jumped past them
+		next = j.cron.Next(next)
+		if now := time.Now().In(j.loc); !next.IsZero() && next.Before(now) {
+			next = j.cron.Next(now)
+		}
+	}
+	<-j.ctx.Done()
+	return nil
+}
+
+// fireLater runs a tick of the schedule after its jitter, the tick is
+// dropped if the schedule changes in the meantime
+func (j *scheduledJob) fireLater(scheduled time.Time) {
+	if j.sched.Jitter > 0 {
+		timer := time.NewTimer(rand.N(j.sched.Jitter.D()))
+		select {
+		case <-j.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	j.fire(triggerSchedule, scheduled)
+}
+
+// fire runs the schedule once, applying the overlap policy
+func (j *scheduledJob) fire(trigger string, scheduled time.Time) {
+	run := scheduleRun{Trigger: trigger, Scheduled: scheduled}
+	switch j.sched.OverlapPolicy() {
+	case funcs.OverlapSkip:
+		select {
+		case j.state.slot <- struct{}{}:
+			defer func() { <-j.state.slot }()
+		default:
+			j.skip(run, "previous run still in progress")
+			return
+		}
+	case funcs.OverlapQueue:
+		if err := j.waitSlot(); err != nil {
+			j.skip(run, err.Error())
+			return
+		}
+		defer func() { <-j.state.slot }()
+	}
+
+	j.state.lock.Lock()
+	j.state.running++
+	j.state.lock.Unlock()
+	defer func() {
+		j.state.lock.Lock()
+		j.state.running--
+		j.state.lock.Unlock()
+	}()
+
+	run.Started = time.Now()
+	status, err := j.invoke(trigger)
+	run.Duration = time.Since(run.Started).String()
+	run.Status = status
+	run.Outcome = "ok"
+	if err != nil {
+		run.Outcome = "failed"
+		run.Error = err.Error()
+		j.failed.Inc()
+		slog.Warn("Scheduled run failed", "name", j.funcName, "schedule", j.sched.ID, "status", status, "error", err)
+	} else {
+		j.ok.Inc()
+	}
+	j.state.record(run)
+}
+
+func (j *scheduledJob) waitSlot() error {
+	j.state.lock.Lock()
+	if j.state.queued >= maxQueuedRuns {
+		j.state.lock.Unlock()
+		return errQueueFull
+	}
+	j.state.queued++
+	j.state.lock.Unlock()
+	defer func() {
+		j.state.lock.Lock()
+		j.state.queued--
+		j.state.lock.Unlock()
+	}()
+	select {
+	case j.state.slot <- struct{}{}:
+		return nil
+	case <-j.ctx.Done():
+		return j.ctx.Err()
+	}
+}
+
+func (j *scheduledJob) invoke(trigger string) (int, error) {
+	ctx := j.runCtx
+	if j.sched.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.sched.Timeout.D())
+		defer cancel()
+	}
+	method, path := j.sched.Request()
+	req, err := http.NewRequestWithContext(ctx, method, "http://gofunc.local/"+j.funcName+path, strings.NewReader(j.sched.Body))
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range j.sched.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Gofunc-Trigger", trigger)
+	req.Header.Set("Gofunc-Schedule", j.sched.ID)
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, errors.New(strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+func (j *scheduledJob) skip(run scheduleRun, reason string) {
+	run.Outcome = "skipped"
+	run.Error = reason
+	j.skipped.Inc()
+	j.state.record(run)
+}
+
+func (ss *scheduleState) record(run scheduleRun) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.runs = append(ss.runs, run)
+	if len(ss.runs) > maxScheduleRuns {
+		ss.runs = slices.Clone(ss.runs[len(ss.runs)-maxScheduleRuns:])
+	}
+}
+
+// recent returns the runs, most recent first, and the number of runs
+// in progress and waiting for the previous one
+func (ss *scheduleState) recent() (runs []scheduleRun, running, queued int) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	runs = append([]scheduleRun{}, ss.runs...)
+	slices.Reverse(runs)
+	return runs, ss.running, ss.queued
+}
+
+func (j *scheduledJob) status() scheduleStatus {
+	runs, running, queued := j.state.recent()
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	st := scheduleStatus{
+		Func:     j.funcName,
+		Schedule: j.sched,
+		Running:  running,
+		Queued:   queued,
+		Runs:     runs,
+	}
+	if !j.sched.Paused && !j.next.IsZero() {
+		next := j.next
+		st.Next = &next
+	}
+	return st
+}
+
+// scheduleStatus returns the status of the schedules of funcName, or of all
+// functions if funcName is empty
+func (h *handler) scheduleStatus(funcName string) []scheduleStatus {
+	h.schedLock.Lock()
+	defer h.schedLock.Unlock()
+	out := []scheduleStatus{}
+	for _, job := range h.schedules {
+		if funcName != "" && job.funcName != funcName {
+			continue
+		}
+		out = append(out, job.status())
+	}
+	slices.SortFunc(out, func(a, b scheduleStatus) int {
+		if c := strings.Compare(a.Func, b.Func); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return out
+}
+
+func (h *handler) listSchedules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.scheduleStatus(""))
+}
+
+func (h *handler) getSchedules(w http.ResponseWriter, r *http.Request) {
+	fn, ok := h.lookupFunc(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.scheduleStatus(fn.Name()))
+}
+
+// putSchedules replaces the schedules of the function
+func (h *handler) putSchedules(w http.ResponseWriter, r *http.Request) {
+	fn, ok := h.lookupFunc(w, r)
+	if !ok {
+		return
+	}
+	var schedules []funcs.Schedule
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&schedules); err != nil {
+		http.Error(w, "invalid schedules: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg := fn.Config()
+	cfg.Schedules = schedules
+	if err := fn.UpdateConfig(cfg); err != nil {
+		http.Error(w, "unable to update schedules: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("Updated function schedules", "name", fn.Name(), "addr", r.RemoteAddr)
+	h.syncSchedules(fn)
+	writeJSON(w, http.StatusOK, h.scheduleStatus(fn.Name()))
+}
+
+func (h *handler) pauseSchedule(w http.ResponseWriter, r *http.Request) {
+	h.setSchedulePaused(w, r, true)
+}
+
+func (h *handler) resumeSchedule(w http.ResponseWriter, r *http.Request) {
+	h.setSchedulePaused(w, r, false)
+}
+
+func (h *handler) setSchedulePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	fn, ok := h.lookupFunc(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	cfg := fn.Config()
+	idx := slices.IndexFunc(cfg.Schedules, func(s funcs.Schedule) bool { return s.ID == id })
+	if idx < 0 {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	}
+	cfg.Schedules = slices.Clone(cfg.Schedules)
+	cfg.Schedules[idx].Paused = paused
+	if err := fn.UpdateConfig(cfg); err != nil {
+		http.Error(w, "unable to update schedule: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Updated schedule", "name", fn.Name(), "schedule", id, "paused", paused, "addr", r.RemoteAddr)
+	h.syncSchedules(fn)
+	h.writeSchedule(w, fn.Name(), id, http.StatusOK)
+}
+
+// triggerSchedule runs the schedule now, even if it is paused. The run
+// follows the overlap policy and shows up in the history.
+func (h *handler) triggerSchedule(w http.ResponseWriter, r *http.Request) {
+	fn, ok := h.lookupFunc(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	h.schedLock.Lock()
+	job := h.schedules[fn.Name()+"/"+id]
+	h.schedLock.Unlock()
+	if job == nil {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	}
+	slog.Info("Triggering schedule", "name", fn.Name(), "schedule", id, "addr", r.RemoteAddr)
+	go job.fire(triggerManual, time.Now())
+	h.writeSchedule(w, fn.Name(), id, http.StatusAccepted)
+}
+
+func (h *handler) writeSchedule(w http.ResponseWriter, funcName, id string, status int) {
+	for _, st := range h.scheduleStatus(funcName) {
+		if st.ID == id {
+			writeJSON(w, status, st)
+			return
+		}
+	}
+	http.Error(w, "schedule not found", http.StatusNotFound)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_Schedules(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	mainGo := `package main
+import (
+	"net/http"
+	"os"
+	"time"
+)
+func main() {
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("BIND_PORT"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Gofunc-Trigger") != "manual" || r.URL.Path != "/ticker/tick" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		time.Sleep(300 * time.Millisecond)
+	}))
+}`
+	zipPath := createTestZip(t, map[string]string{"main.go": mainGo, "go.mod": "module ticker\n\ngo 1.24\n"})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/ticker/recompile", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompile failed: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/ticker/schedules", strings.NewReader(`[{"id":"nightly","cron":"0 3 * * *","timeZone":"bad/zone"}]`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid time zones should be rejected, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/ticker/schedules", strings.NewReader(`[{"id":"nightly","cron":"0 3 * * *","path":"/tick","paused":true}]`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put schedules failed: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/_admin/ticker/schedules/nightly/resume", nil))
+	var st scheduleStatus
+	json.Unmarshal(rec.Body.Bytes(), &st)
+	if rec.Code != http.StatusOK || st.Paused {
+		t.Fatalf("resume failed: %s", rec.Body.String())
+	}
+
+	// the second trigger overlaps with the first one and is skipped,
+	// even though the schedule changed in between
+	for _, action := range []string{"trigger", "pause", "trigger", "resume"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "/_admin/ticker/schedules/nightly/"+action, nil))
+		if rec.Code != http.StatusAccepted && rec.Code != http.StatusOK {
+			t.Fatalf("%v failed: %s", action, rec.Body.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var list []scheduleStatus
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/_admin/schedules", nil))
+		list = nil
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		if len(list) == 1 && len(list[0].Runs) == 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(list) != 1 || len(list[0].Runs) != 2 {
+		t.Fatalf("expected two runs, got %+v", list)
+	}
+	if list[0].Next == nil {
+		t.Fatalf("active schedules should report the next run")
+	}
+	last, first := list[0].Runs[0], list[0].Runs[1]
+	if first.Outcome != "skipped" {
+		t.Fatalf("overlapping run should be skipped, got %+v", first)
+	}
+	if last.Outcome != "ok" || last.Status != http.StatusOK || last.Trigger != "manual" {
+		t.Fatalf("unexpected run: %+v", last)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrebq/gofunc/pkg/tlsfiles"
+)
+
+type (
+	// TLSConfig configures TLS termination on the gofunc listener.
+	TLSConfig struct {
+		// CertFile and KeyFile are reloaded whenever their modification time changes
+		CertFile string
+		KeyFile  string
+
+		// ClientCAFile enables client certificate authentication for the
+		// admin routes. Clients presenting a certificate signed by one of
+		// those CAs are allowed to call /_admin/
+		ClientCAFile string
+	}
+
+	certReloader struct {
+		certFile, keyFile string
+
+		sync.Mutex
+		cert      *tls.Certificate
+		certMod   time.Time
+		keyMod    time.Time
+		lastCheck time.Time
+	}
+)
+
+// reloadCheckInterval limits how often the certificate files are stat'ed
+const reloadCheckInterval = time.Second
+
+// Enabled returns true if the listener should use TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+func (c TLSConfig) serverConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls: both certificate and key files are required")
+	}
+	reloader, err := newCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if c.ClientCAFile != "" {
+		pool, err := tlsfiles.CertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		// public routes do not require a certificate, admin routes are
+		// checked by requireClientCert
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.Lock()
+	defer r.Unlock()
+	if time.Since(r.lastCheck) >= reloadCheckInterval {
+		r.lastCheck = time.Now()
+		if r.changed() {
+			// keep serving the old certificate if the new one is broken,
+			// this usually happens while the files are being replaced
+			_ = r.reload()
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) changed() bool {
+	certMod, err := modTime(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyMod, err := modTime(r.keyFile)
+	if err != nil {
+		return false
+	}
+	return !certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)
+}
+
+func (r *certReloader) reload() error {
+	certMod, err := modTime(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyMod, err := modTime(r.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: load key pair: %w", err)
+	}
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+	return nil
+}
+
+func modTime(file string) (time.Time, error) {
+	st, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("tls: stat %q: %w", file, err)
+	}
+	return st.ModTime(), nil
+}
+
+// requireClientCert rejects requests under prefix that did not present
+// a verified client certificate
+func requireClientCert(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				http.Error(w, "client certificate required", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrebq/gofunc/pkg/tlsfiles"
+)
+
+func writeSelfSigned(t *testing.T, dir, cn string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{cn},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, cn+".crt")
+	keyFile = filepath.Join(dir, cn+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloader_ReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSigned(t, dir, "first")
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	cert, _ := r.GetCertificate(nil)
+	if cert.Leaf.Subject.CommonName != "first" {
+		t.Fatalf("unexpected certificate: %v", cert.Leaf.Subject)
+	}
+
+	// replace the files with a new pair and force the mtime to move
+	other := t.TempDir()
+	newCert, newKey := writeSelfSigned(t, other, "second")
+	for src, dst := range map[string]string{newCert: certFile, newKey: keyFile} {
+		buf, _ := os.ReadFile(src)
+		if err := os.WriteFile(dst, buf, 0600); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(dst, future, future)
+	}
+	r.lastCheck = time.Time{}
+	cert, _ = r.GetCertificate(nil)
+	if cert.Leaf.Subject.CommonName != "second" {
+		t.Fatalf("certificate was not reloaded: %v", cert.Leaf.Subject)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeSelfSigned(t, dir, "localhost")
+	clientCert, clientKey := writeSelfSigned(t, dir, "deployer")
+
+	cfg := TLSConfig{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: clientCert}
+	tlsCfg, err := cfg.serverConfig()
+	if err != nil {
+		t.Fatalf("serverConfig: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(requireClientCert("/_admin/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	ts.TLS = tlsCfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	newClient := func(certFile, keyFile string) *http.Client {
+		cfg, err := tlsfiles.ClientConfig(serverCert, certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.ServerName = "localhost"
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	}
+
+	anon := newClient("", "")
+	if resp, err := anon.Get(ts.URL + "/myfunc/"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("public route should not require a certificate: %v %v", resp, err)
+	}
+	if resp, err := anon.Get(ts.URL + "/_admin/myfunc/recompile"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("admin route should require a certificate: %v %v", resp, err)
+	}
+
+	authed := newClient(clientCert, clientKey)
+	if resp, err := authed.Get(ts.URL + "/_admin/myfunc/recompile"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin route should accept the client certificate: %v %v", resp, err)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/andrebq/gofunc/funcs"
+)
+
+type (
+	// uploadError is returned by receiveUpload with the status sent to the client
+	uploadError struct {
+		status int
+		body   any
+	}
+)
+
+// formatsByType maps the content types accepted by recompile to archive formats
+var formatsByType = map[string]funcs.ArchiveFormat{
+	"application/zip":              funcs.FormatZip,
+	"application/x-zip-compressed": funcs.FormatZip,
+	"application/gzip":             funcs.FormatTarGz,
+	"application/x-gzip":           funcs.FormatTarGz,
+	"application/x-gtar":           funcs.FormatTarGz,
+	"application/x-tar+gzip":       funcs.FormatTarGz,
+	"application/zstd":             funcs.FormatTarZst,
+	"application/x-zstd":           funcs.FormatTarZst,
+	"application/x-tar+zstd":       funcs.FormatTarZst,
+	"text/x-go":                    funcs.FormatGoFile,
+	"text/plain":                   funcs.FormatGoFile,
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprint(e.body)
+}
+
+// receiveUpload stores the body of an upload in a temporary file named after
+// its format, which is selected by the Content-Type or, when it is missing
+// or generic, by the first bytes of the body. multipart/form-data bodies
+// upload the first file of the form. Callers remove the returned file.
+func receiveUpload(w http.ResponseWriter, r *http.Request, maxUpload int64) (string, error) {
+	body := http.MaxBytesReader(w, r.Body, maxUpload)
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		part, err := firstFile(multipart.NewReader(body, params["boundary"]))
+		if err != nil {
+			return "", uploadFailed(err, maxUpload)
+		}
+		defer part.Close()
+		mediaType, _, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+		return storeUpload(part, mediaType, part.FileName(), maxUpload)
+	}
+	return storeUpload(body, mediaType, "", maxUpload)
+}
+
+// firstFile returns the first part of the form holding a file
+func firstFile(mr *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, &uploadError{http.StatusBadRequest, "the form has no file"}
+		} else if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func storeUpload(body io.Reader, mediaType, fileName string, maxUpload int64) (string, error) {
+	br := bufio.NewReader(body)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", uploadFailed(err, maxUpload)
+	}
+	format, err := detectFormat(mediaType, fileName, head)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "gofaas-upload-*"+string(format))
+	if err != nil {
+		return "", &uploadError{http.StatusInternalServerError, "failed to create temp file: " + err.Error()}
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, br); err != nil {
+		os.Remove(f.Name())
+		return "", uploadFailed(err, maxUpload)
+	}
+	return f.Name(), nil
+}
+
+// detectFormat selects the format by the media type, the file name (for
+// multipart uploads) or the magic bytes of the body, in that order. Bodies
+// that cannot be detected are handled as zip archives.
+func detectFormat(mediaType, fileName string, head []byte) (funcs.ArchiveFormat, error) {
+	if format, ok := formatsByType[mediaType]; ok {
+		return format, nil
+	}
+	if mediaType != "" && mediaType != "application/octet-stream" {
+		return "", &uploadError{http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type %q, upload a zip, tar.gz, tar.zst or a single go file", mediaType)}
+	}
+	switch ext := path.Ext(fileName); {
+	case ext == ".zip", ext == ".go", ext == ".tgz", strings.HasSuffix(fileName, ".tar.gz"), strings.HasSuffix(fileName, ".tar.zst"):
+		return funcs.FormatOf(fileName), nil
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return funcs.FormatZip, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return funcs.FormatTarGz, nil
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return funcs.FormatTarZst, nil
+	case bytes.Contains(head, []byte("package ")):
+		return funcs.FormatGoFile, nil
+	}
+	// zip was the only format accepted by older versions
+	return funcs.FormatZip, nil
+}
+
+// uploadFailed reports errors reading the body, with a structured error
+// when the body is too large
+func uploadFailed(err error, maxUpload int64) error {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return &uploadError{http.StatusRequestEntityTooLarge, &funcs.ArchiveError{
+			Reason: fmt.Sprintf("upload is larger than %v bytes", maxUpload),
+			Limit:  "maxUpload",
+			Max:    float64(maxUpload),
+		}}
+	}
+	var uerr *uploadError
+	if errors.As(err, &uerr) {
+		return uerr
+	}
+	return &uploadError{http.StatusBadRequest, "failed to read upload: " + err.Error()}
+}
+
+// writeUploadError writes err as returned by receiveUpload
+func writeUploadError(w http.ResponseWriter, err error) {
+	var uerr *uploadError
+	if !errors.As(err, &uerr) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if msg, ok := uerr.body.(string); ok {
+		http.Error(w, msg, uerr.status)
+		return
+	}
+	writeJSON(w, uerr.status, uerr.body)
+}
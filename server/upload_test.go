@@ -0,0 +1,81 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_UploadFormats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	mainGo := `package main
+import (
+	"net/http"
+	"os"
+)
+func main() {
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("BIND_PORT"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(os.Args[0]))
+	}))
+}`
+	upload := func(name, contentType string, body []byte) {
+		t.Helper()
+		req := httptest.NewRequest("PUT", "/_admin/"+name+"/recompile", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload of %v failed: %d %s", name, rec.Code, rec.Body.String())
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/"+name+"/", nil))
+			if rec.Code == http.StatusOK && strings.HasSuffix(rec.Body.String(), name+".out") {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("invoke %v failed: %d %s", name, rec.Code, rec.Body.String())
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	// a browser form with a single file, go.mod is generated
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("comment", "quick experiment")
+	fw, _ := mw.CreateFormFile("file", "main.go")
+	fw.Write([]byte(mainGo))
+	mw.Close()
+	upload("single", mw.FormDataContentType(), form.Bytes())
+
+	// a tarball detected by its magic bytes
+	var tgz bytes.Buffer
+	gw := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gw)
+	for name, content := range map[string]string{"main.go": mainGo, "go.mod": "module tarball\n\ngo 1.24\n"} {
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gw.Close()
+	upload("tarball", "application/octet-stream", tgz.Bytes())
+
+	req := httptest.NewRequest("PUT", "/_admin/image/recompile", strings.NewReader("GIF89a"))
+	req.Header.Set("Content-Type", "image/gif")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+}
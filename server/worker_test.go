@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_Worker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	// the worker never listens, it records each run and exits to be restarted
+	out := filepath.Join(t.TempDir(), "runs.txt")
+	mainGo := `package main
+import "os"
+func main() {
+	f, err := os.OpenFile(os.Getenv("OUT"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		os.Exit(2)
+	}
+	f.WriteString(os.Getenv("BIND_PORT") + "run\n")
+	f.Close()
+	os.Exit(1)
+}`
+	manifest := fmt.Sprintf(`{"kind":"worker","env":{"OUT":%q}}`, out)
+	zipPath := createTestZip(t, map[string]string{"main.go": mainGo, "go.mod": "module worker\n\ngo 1.24\n", "gofunc.json": manifest})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/worker/recompile", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompile failed: %s", rec.Body.String())
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	var runs []string
+	for time.Now().Before(deadline) {
+		buf, _ := os.ReadFile(out)
+		runs = strings.Fields(string(buf))
+		if len(runs) >= 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(runs) < 2 {
+		t.Fatalf("worker should be restarted after exiting, got runs: %v", runs)
+	}
+	if runs[0] != "run" {
+		t.Fatalf("workers must not receive a BIND_PORT, got %q", runs[0])
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/worker/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("workers cannot be invoked, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/worker/recompile?kind=daemon", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown kinds should be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,502 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andrebq/gofunc/pkg/jsonstore"
+	"github.com/andrebq/gofunc/pkg/metrics"
+	"github.com/andrebq/gofunc/pkg/workflow"
+	"github.com/andrebq/maestro"
+)
+
+type (
+	// workflowEngine stores workflow definitions and executes their runs, the
+	// state of each run is saved after every change so runs interrupted by
+	// a restart resume where they stopped
+	workflowEngine struct {
+		h      *handler
+		ctx    maestro.Context
+		client *http.Client
+		defs   *jsonstore.Store
+		runs   *jsonstore.Store
+
+		lock   sync.Mutex
+		active map[string]*activeRun
+	}
+
+	activeRun struct {
+		// lock protects run, which is shared by the steps in progress
+		lock   sync.Mutex
+		run    *workflow.Run
+		ctx    context.Context
+		cancel context.CancelCauseFunc
+	}
+
+	workflowRunSummary struct {
+		ID       string          `json:"id"`
+		Workflow string          `json:"workflow"`
+		Status   workflow.Status `json:"status"`
+		Error    string          `json:"error,omitempty"`
+		Created  time.Time       `json:"created"`
+		Finished time.Time       `json:"finished,omitzero"`
+	}
+)
+
+const (
+	triggerWorkflow = "workflow"
+
+	// workflowRetention is how long finished runs are kept
+	workflowRetention = 7 * 24 * time.Hour
+	// workflowGCInterval is how often finished runs past their retention are removed
+	workflowGCInterval = time.Hour
+)
+
+var errWorkflowTimeout = errors.New("workflow timed out")
+
+func newWorkflowEngine(h *handler, dir string) (*workflowEngine, error) {
+	defs, err := jsonstore.Open(filepath.Join(dir, "defs"))
+	if err != nil {
+		return nil, err
+	}
+	runs, err := jsonstore.Open(filepath.Join(dir, "runs"))
+	if err != nil {
+		return nil, err
+	}
+	e := &workflowEngine{
+		h:      h,
+		ctx:    maestro.New(h.ctx),
+		client: h.localClient(),
+		defs:   defs,
+		runs:   runs,
+		active: map[string]*activeRun{},
+	}
+	if err := e.resume(); err != nil {
+		return nil, err
+	}
+	e.ctx.Spawn(e.gc)
+	return e, nil
+}
+
+// resume continues the runs interrupted by a previous shutdown, steps that
+// were running are invoked again
+func (e *workflowEngine) resume() error {
+	keys, err := e.runs.Keys()
+	if err != nil {
+		return err
+	}
+	for _, id := range keys {
+		var run workflow.Run
+		if err := e.runs.Get(id, &run); err != nil {
+			slog.Error("Unable to load workflow run", "id", id, "error", err)
+			continue
+		}
+		if run.Status != workflow.Running {
+			continue
+		}
+		run.Reset()
+		slog.Info("Resuming workflow run", "workflow", run.Workflow, "id", run.ID)
+		e.start(&run)
+	}
+	return nil
+}
+
+// start executes run in the background
+func (e *workflowEngine) start(run *workflow.Run) {
+	ctx, cancel := context.WithCancelCause(e.ctx)
+	stop := func() {}
+	if timeout := run.Definition.Timeout.D(); timeout > 0 {
+		ctx, stop = context.WithDeadlineCause(ctx, run.Created.Add(timeout), errWorkflowTimeout)
+	}
+	ar := &activeRun{run: run, ctx: ctx, cancel: cancel}
+	e.lock.Lock()
+	e.active[run.ID] = ar
+	e.lock.Unlock()
+	e.ctx.Spawn(func(maestro.Context) error {
+		defer func() {
+			stop()
+			cancel(nil)
+			e.lock.Lock()
+			delete(e.active, run.ID)
+			e.lock.Unlock()
+		}()
+		e.execute(ar)
+		return nil
+	})
+}
+
+func (e *workflowEngine) execute(ar *activeRun) {
+	done := make(chan struct{})
+	running := 0
+	for {
+		ar.lock.Lock()
+		if ar.ctx.Err() == nil {
+			for _, id := range ar.run.Advance() {
+				running++
+				go e.runStep(ar, id, done)
+			}
+		}
+		if running == 0 {
+			if e.ctx.Err() != nil {
+				// the server is stopping, the run resumes on the next start
+				ar.run.Reset()
+				e.save(ar.run)
+				ar.lock.Unlock()
+				return
+			}
+			var err error
+			if ar.ctx.Err() != nil {
+				err = context.Cause(ar.ctx)
+			}
+			ar.run.Finish(err)
+			e.save(ar.run)
+			metrics.Default.Counter("gofunc_workflow_runs_total", "Finished workflow runs", "workflow", ar.run.Workflow, "status", string(ar.run.Status)).Inc()
+			slog.Info("Workflow run finished", "workflow", ar.run.Workflow, "id", ar.run.ID, "status", ar.run.Status, "error", ar.run.Error)
+			ar.lock.Unlock()
+			return
+		}
+		e.save(ar.run)
+		ar.lock.Unlock()
+		<-done
+		running--
+	}
+}
+
+// runStep invokes the function of a step until it succeeds, fails without
+// being retryable or exhausts its attempts. A failed step aborts the run.
+func (e *workflowEngine) runStep(ar *activeRun, id string, done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+	step, _ := ar.run.Definition.Step(id)
+
+	ar.lock.Lock()
+	input := ar.run.StepInput(id)
+	st := ar.run.Steps[id]
+	st.Started = time.Now()
+	ar.lock.Unlock()
+
+	for {
+		ar.lock.Lock()
+		if ar.ctx.Err() != nil {
+			e.abortStep(ar, st)
+			ar.lock.Unlock()
+			return
+		}
+		st.Attempts++
+		attempt := st.Attempts
+		e.save(ar.run)
+		ar.lock.Unlock()
+
+		status, body, err := e.invoke(ar, step, input, attempt)
+
+		ar.lock.Lock()
+		if ar.ctx.Err() != nil {
+			if e.ctx.Err() != nil {
+				// interrupted by a shutdown, the attempt does not count
+				st.Attempts--
+			}
+			e.abortStep(ar, st)
+			ar.lock.Unlock()
+			return
+		}
+		st.StatusCode = status
+		retry := err != nil || status >= 500 || status == http.StatusTooManyRequests
+		if err == nil && status >= 400 {
+			err = errors.New("function returned " + strconv.Itoa(status) + ": " + string(bytes.TrimSpace(body[:min(len(body), 512)])))
+		}
+		if err == nil {
+			st.Status = workflow.Succeeded
+			st.Output = workflow.AsOutput(body)
+			st.Error = ""
+			st.Finished = time.Now()
+			ar.lock.Unlock()
+			return
+		}
+		st.Error = err.Error()
+		if !retry || attempt >= step.Retry.Attempts() {
+			st.Status = workflow.Failed
+			st.Finished = time.Now()
+			ar.cancel(fmt.Errorf("step %v failed: %v", id, st.Error))
+			ar.lock.Unlock()
+			return
+		}
+		e.save(ar.run)
+		ar.lock.Unlock()
+
+		delay := step.Retry.Delay(attempt)
+		slog.Warn("Workflow step failed, retrying", "workflow", ar.run.Workflow, "id", ar.run.ID, "step", id, "attempt", attempt, "error", err, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ar.ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// abortStep updates a step interrupted by the end of the run, the caller holds ar.lock
+func (e *workflowEngine) abortStep(ar *activeRun, st *workflow.StepState) {
+	if e.ctx.Err() != nil {
+		st.Status = workflow.Pending
+		return
+	}
+	st.Status = workflow.Canceled
+	st.Error = context.Cause(ar.ctx).Error()
+	st.Finished = time.Now()
+}
+
+func (e *workflowEngine) invoke(ar *activeRun, step workflow.Step, input []byte, attempt int) (int, []byte, error) {
+	ctx := ar.ctx
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout.D())
+		defer cancel()
+	}
+	method, path := step.Request()
+	req, err := http.NewRequestWithContext(ctx, method, "http://gofunc.local/"+step.Func+path, bytes.NewReader(input))
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, v := range step.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(input) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Gofunc-Trigger", triggerWorkflow)
+	req.Header.Set("Gofunc-Workflow", ar.run.Workflow)
+	req.Header.Set("Gofunc-Workflow-Run", ar.run.ID)
+	req.Header.Set("Gofunc-Step", step.ID)
+	req.Header.Set("Gofunc-Attempt", strconv.Itoa(attempt))
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+func (e *workflowEngine) save(run *workflow.Run) {
+	if err := e.runs.Put(run.ID, run); err != nil {
+		slog.Error("Unable to save workflow run", "workflow", run.Workflow, "id", run.ID, "error", err)
+	}
+}
+
+// load returns a run, from memory while it is in progress
+func (e *workflowEngine) load(id string) (*workflow.Run, error) {
+	e.lock.Lock()
+	ar := e.active[id]
+	e.lock.Unlock()
+	if ar != nil {
+		ar.lock.Lock()
+		defer ar.lock.Unlock()
+		return ar.run.Clone(), nil
+	}
+	var run workflow.Run
+	if err := e.runs.Get(id, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// gc removes finished runs past their retention period
+func (e *workflowEngine) gc(ctx maestro.Context) error {
+	ticker := time.NewTicker(workflowGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		keys, err := e.runs.Keys()
+		if err != nil {
+			slog.Error("Unable to list workflow runs", "error", err)
+			continue
+		}
+		for _, id := range keys {
+			var run workflow.Run
+			if err := e.runs.Get(id, &run); err != nil || run.Finished.IsZero() {
+				continue
+			}
+			if time.Since(run.Finished) > workflowRetention {
+				e.runs.Delete(id)
+			}
+		}
+	}
+}
+
+func (h *handler) listWorkflows(w http.ResponseWriter, r *http.Request) {
+	if h.workflows == nil {
+		http.Error(w, "workflows not available", http.StatusServiceUnavailable)
+		return
+	}
+	keys, err := h.workflows.defs.Keys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defs := []workflow.Definition{}
+	for _, name := range keys {
+		var def workflow.Definition
+		if err := h.workflows.defs.Get(name, &def); err == nil {
+			defs = append(defs, def)
+		}
+	}
+	writeJSON(w, http.StatusOK, defs)
+}
+
+// putWorkflow registers a workflow, written in JSON or in YAML (by Content-Type),
+// replacing the previous definition with the same name. Runs in progress keep
+// the definition they started with.
+func (h *handler) putWorkflow(w http.ResponseWriter, r *http.Request) {
+	if h.workflows == nil {
+		http.Error(w, "workflows not available", http.StatusServiceUnavailable)
+		return
+	}
+	def, err := workflow.Decode(http.MaxBytesReader(w, r.Body, 1<<20), r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, "invalid workflow: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	def.Normalize()
+	if err := def.Validate(); err != nil {
+		http.Error(w, "invalid workflow: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.workflows.defs.Put(def.Name, def); err != nil {
+		http.Error(w, "unable to save workflow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Registered workflow", "workflow", def.Name, "steps", len(def.Steps), "addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, def)
+}
+
+func (h *handler) getWorkflow(w http.ResponseWriter, r *http.Request) {
+	if h.workflows == nil {
+		http.Error(w, "workflows not available", http.StatusServiceUnavailable)
+		return
+	}
+	var def workflow.Definition
+	if err := h.workflows.defs.Get(r.PathValue("name"), &def); err != nil {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, def)
+}
+
+func (h *handler) deleteWorkflow(w http.ResponseWriter, r *http.Request) {
+	if h.workflows == nil {
+		http.Error(w, "workflows not available", http.StatusServiceUnavailable)
+		return
+	}
+	if err := h.workflows.defs.Delete(r.PathValue("name")); err != nil {
+		http.Error(w, "invalid workflow name", http.StatusBadRequest)
+		return
+	}
+	slog.Info("Deleted workflow", "workflow", r.PathValue("name"), "addr", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startWorkflow starts a run, the body holds the workflow name and its input
+func (h *handler) startWorkflow(w http.ResponseWriter, r *http.Request) {
+	if h.workflows == nil {
+		http.Error(w, "workflows not available", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		Workflow string          `json:"workflow"`
+		Input    json.RawMessage `json:"input"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAsyncBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var def workflow.Definition
+	if err := h.workflows.defs.Get(req.Workflow, &def); err != nil {
+		http.Error(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	run := workflow.NewRun(newJobID(), def, req.Input)
+	if err := h.workflows.runs.Put(run.ID, run); err != nil {
+		http.Error(w, "unable to start workflow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Starting workflow run", "workflow", def.Name, "id", run.ID, "addr", r.RemoteAddr)
+	h.workflows.start(run)
+	w.Header().Set("Location", "/_admin/workflows/runs/"+run.ID)
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": run.ID, "status": string(workflow.Running)})
+}
+
+// listWorkflowRuns returns a summary of the runs, optionally filtered by
+// workflow and status
+func (h *handler) listWorkflowRuns(w http.ResponseWriter, r *http.Request) {
+	if h.workflows == nil {
+		http.Error(w, "workflows not available", http.StatusServiceUnavailable)
+		return
+	}
+	name, status := r.URL.Query().Get("workflow"), workflow.Status(r.URL.Query().Get("status"))
+	keys, err := h.workflows.runs.Keys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	runs := []workflowRunSummary{}
+	for _, id := range keys {
+		run, err := h.workflows.load(id)
+		if err != nil || (name != "" && run.Workflow != name) || (status != "" && run.Status != status) {
+			continue
+		}
+		runs = append(runs, workflowRunSummary{
+			ID:       run.ID,
+			Workflow: run.Workflow,
+			Status:   run.Status,
+			Error:    run.Error,
+			Created:  run.Created,
+			Finished: run.Finished,
+		})
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (h *handler) getWorkflowRun(w http.ResponseWriter, r *http.Request) {
+	if h.workflows == nil {
+		http.Error(w, "workflows not available", http.StatusServiceUnavailable)
+		return
+	}
+	run, err := h.workflows.load(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// cancelWorkflowRun stops a run, steps in progress are interrupted
+func (h *handler) cancelWorkflowRun(w http.ResponseWriter, r *http.Request) {
+	if h.workflows == nil {
+		http.Error(w, "workflows not available", http.StatusServiceUnavailable)
+		return
+	}
+	id := r.PathValue("id")
+	h.workflows.lock.Lock()
+	ar := h.workflows.active[id]
+	h.workflows.lock.Unlock()
+	if ar == nil {
+		http.Error(w, "run is not in progress", http.StatusConflict)
+		return
+	}
+	ar.cancel(workflow.ErrCanceled)
+	slog.Info("Canceled workflow run", "workflow", ar.run.Workflow, "id", id, "addr", r.RemoteAddr)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrebq/gofunc/pkg/workflow"
+)
+
+const calcMain = `package main
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+func main() {
+	var calls int32
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("BIND_PORT"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var x float64
+		json.Unmarshal(body, &x)
+		switch strings.TrimPrefix(r.URL.Path, "/calc") {
+		case "/flaky":
+			if atomic.AddInt32(&calls, 1) == 1 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, x+1)
+		case "/inc":
+			fmt.Fprint(w, x+1)
+		case "/double":
+			fmt.Fprint(w, x*2)
+		case "/sum":
+			var m map[string]float64
+			json.Unmarshal(body, &m)
+			sum := 0.0
+			for _, v := range m {
+				sum += v
+			}
+			fmt.Fprint(w, sum)
+		default:
+			http.Error(w, "bad input", http.StatusBadRequest)
+		}
+	}))
+}`
+
+func adminJSON(t *testing.T, h http.Handler, method, path, body string, out any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if out != nil {
+		json.Unmarshal(rec.Body.Bytes(), out)
+	}
+	return rec.Code
+}
+
+func waitRun(t *testing.T, h http.Handler, id string) workflow.Run {
+	t.Helper()
+	var run workflow.Run
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		adminJSON(t, h, "GET", "/_admin/workflows/runs/"+id, "", &run)
+		if run.Status != workflow.Running {
+			return run
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("run %v did not finish: %+v", id, run)
+	return run
+}
+
+func TestHandler_Workflows(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHandler(ctx, t.TempDir(), t.TempDir(), t.TempDir())
+
+	zipPath := createTestZip(t, map[string]string{"main.go": calcMain, "go.mod": "module calc\n\ngo 1.24\n"})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/calc/recompile", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompile failed: %s", rec.Body.String())
+	}
+
+	// (x+1) fans out to double and inc, then sum; big only runs for large inputs
+	def := `{"name":"math","steps":[
+		{"id":"first","func":"calc","path":"/flaky","retry":{"maxAttempts":3,"backoff":"10ms"}},
+		{"id":"double","func":"calc","path":"/double"},
+		{"id":"inc","func":"calc","path":"/inc","after":["first"]},
+		{"id":"sum","func":"calc","path":"/sum","after":["double","inc"]},
+		{"id":"big","func":"calc","path":"/inc","after":["first"],"if":{"path":"first","op":"gt","value":100}}
+	]}`
+	if code := adminJSON(t, h, "POST", "/_admin/workflows", def, nil); code != http.StatusOK {
+		t.Fatalf("unable to register workflow: %v", code)
+	}
+	if code := adminJSON(t, h, "POST", "/_admin/workflows", `{"name":"bad","steps":[{"id":"a","func":"calc","after":["b"]}]}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("invalid workflows should be rejected, got %v", code)
+	}
+
+	req := httptest.NewRequest("POST", "/_admin/workflows", strings.NewReader("name: incs\nsteps:\n  - id: a\n    func: calc\n    path: /inc\n  - id: b\n    func: calc\n    path: /inc\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var registered workflow.Definition
+	json.Unmarshal(rec.Body.Bytes(), &registered)
+	if rec.Code != http.StatusOK || len(registered.Steps) != 2 || registered.Steps[1].After[0] != "a" {
+		t.Fatalf("unable to register a yaml workflow: %v %s", rec.Code, rec.Body.String())
+	}
+
+	var started struct{ ID string }
+	if code := adminJSON(t, h, "POST", "/_admin/workflows/runs", `{"workflow":"math","input":1}`, &started); code != http.StatusAccepted {
+		t.Fatalf("unable to start workflow: %v", code)
+	}
+	run := waitRun(t, h, started.ID)
+	if run.Status != workflow.Succeeded || string(run.Output) != `{"sum":7}` {
+		t.Fatalf("unexpected run %v %q %s", run.Status, run.Error, run.Output)
+	}
+	if run.Steps["first"].Attempts != 2 || run.Steps["big"].Status != workflow.Skipped {
+		t.Fatalf("unexpected steps %+v %+v", run.Steps["first"], run.Steps["big"])
+	}
+
+	adminJSON(t, h, "POST", "/_admin/workflows", `{"name":"broken","steps":[{"id":"a","func":"calc","path":"/nope"},{"id":"b","func":"calc","path":"/inc"}]}`, nil)
+	adminJSON(t, h, "POST", "/_admin/workflows/runs", `{"workflow":"broken","input":1}`, &started)
+	run = waitRun(t, h, started.ID)
+	if run.Status != workflow.Failed || !strings.Contains(run.Error, "step a failed") || run.Steps["b"].Status != workflow.Canceled {
+		t.Fatalf("unexpected run %v %q %+v", run.Status, run.Error, run.Steps["b"])
+	}
+
+	var runs []workflowRunSummary
+	adminJSON(t, h, "GET", "/_admin/workflows/runs?workflow=math", "", &runs)
+	if len(runs) != 1 || runs[0].Status != workflow.Succeeded {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+}
+
+func TestHandler_WorkflowsResume(t *testing.T) {
+	binDir, dataDir := t.TempDir(), t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewHandler(ctx, t.TempDir(), binDir, dataDir)
+	zipPath := createTestZip(t, map[string]string{"main.go": calcMain, "go.mod": "module calc\n\ngo 1.24\n"})
+	defer os.Remove(zipPath)
+	zipData, _ := os.ReadFile(zipPath)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/_admin/calc/recompile", bytes.NewReader(zipData)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompile failed: %s", rec.Body.String())
+	}
+	adminJSON(t, h, "POST", "/_admin/workflows", `{"name":"twice","steps":[{"id":"a","func":"calc","path":"/double"},{"id":"b","func":"calc","path":"/double"}]}`, nil)
+	var def workflow.Definition
+	adminJSON(t, h, "GET", "/_admin/workflows/defs/twice", "", &def)
+
+	// a run interrupted after its first step, while the second was running
+	run := workflow.NewRun("interrupted", def, json.RawMessage(`3`))
+	run.Steps["a"].Status = workflow.Succeeded
+	run.Steps["a"].Output = json.RawMessage(`6`)
+	run.Steps["b"].Status = workflow.Running
+	run.Steps["b"].Attempts = 1
+	h.workflows.runs.Put(run.ID, run)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	h = NewHandler(ctx, t.TempDir(), binDir, dataDir)
+	resumed := waitRun(t, h, run.ID)
+	if resumed.Status != workflow.Succeeded || string(resumed.Output) != "12" || resumed.Steps["b"].Attempts != 2 {
+		t.Fatalf("unexpected run %v %q %s %+v", resumed.Status, resumed.Error, resumed.Output, resumed.Steps["b"])
+	}
+}